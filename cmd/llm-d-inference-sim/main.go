@@ -20,6 +20,7 @@ package main
 
 import (
 	"context"
+	"os"
 
 	"k8s.io/klog/v2"
 
@@ -33,6 +34,15 @@ func main() {
 	ctx := klog.NewContext(context.Background(), logger)
 	ctx = signals.SetupSignalHandler(ctx)
 
+	// fleet mode - run several simulators defined in a fleet configuration file
+	if len(os.Args) > 1 && os.Args[1] == "fleet" {
+		logger.Info("Starting vLLM simulator fleet")
+		if err := vllmsim.NewFleet(logger).Start(ctx); err != nil {
+			logger.Error(err, "vLLM simulator fleet failed")
+		}
+		return
+	}
+
 	logger.Info("Starting vLLM simulator")
 
 	vllmSim, err := vllmsim.New(logger)
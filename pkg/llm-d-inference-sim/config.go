@@ -21,15 +21,22 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"slices"
 	"strings"
 	"time"
 
+	vllmapi "github.com/llm-d/llm-d-inference-sim/pkg/vllm-api"
 	"gopkg.in/yaml.v3"
 )
 
-type configuration struct {
+// Configuration is the configuration of the simulator, the parameters of the command line and of
+// the configuration file
+type Configuration struct {
 	// Port defines on which port the simulator runs
 	Port int `yaml:"port"`
+	// MetricsPort defines on which port the metrics are exposed, if not set the metrics are exposed
+	// on Port
+	MetricsPort int `yaml:"metrics-port"`
 	// Model defines the current base model name
 	Model string `yaml:"model"`
 	// ServedModelNames is one or many model names exposed by the API
@@ -38,6 +45,20 @@ type configuration struct {
 	MaxLoras int `yaml:"max-loras"`
 	// MaxCPULoras defines maximum number of LoRAs to store in CPU memory
 	MaxCPULoras int `yaml:"max-cpu-loras"`
+	// AllowRuntimeLoraUpdating enables the endpoints that load and unload LoRA adapters at runtime
+	AllowRuntimeLoraUpdating bool `yaml:"allow-runtime-lora-updating"`
+	// LoraResolverCacheDir is the directory of the filesystem LoRA resolver, requests for unknown LoRA
+	// adapters that are stored in this directory load the adapters automatically
+	LoraResolverCacheDir string `yaml:"lora-resolver-cache-dir"`
+	// ValidateLoraPath defines whether LoRA adapter paths must exist and contain an adapter_config.json file
+	ValidateLoraPath bool `yaml:"validate-lora-path"`
+	// LoraTokenOverhead is the additional time to generate each token of a LoRA request, in milliseconds,
+	// for an adapter of rank 16, scaled by the adapter's rank
+	LoraTokenOverhead int `yaml:"lora-token-overhead"`
+	// LoraLoadLatency is the time to load a LoRA adapter from storage to GPU memory, in milliseconds
+	LoraLoadLatency int `yaml:"lora-load-latency"`
+	// LoraSwapLatency is the time to move a LoRA adapter from CPU memory to GPU memory, in milliseconds
+	LoraSwapLatency int `yaml:"lora-swap-latency"`
 	// MaxNumSeqs is maximum number of sequences per iteration (the maximum
 	// number of inference requests that could be processed at the same time)
 	MaxNumSeqs int `yaml:"max-num-seqs"`
@@ -55,13 +76,91 @@ type configuration struct {
 	TimeToFirstToken int `yaml:"time-to-first-token"`
 	// InterTokenLatency time between generated tokens, in milliseconds
 	InterTokenLatency int `yaml:"inter-token-latency"`
+	// ErrorRate is the fraction of requests that fail with an internal server error, between 0 and 1
+	ErrorRate float64 `yaml:"error-rate"`
+	// DataParallelSize is the number of data parallel ranks, each rank is an independent engine
+	DataParallelSize int `yaml:"data-parallel-size"`
+	// BlockSize is the number of tokens in a KV cache block
+	BlockSize int `yaml:"block-size"`
+	// AdvertisedHost is the host other instances use to reach this simulator, returned to the
+	// decode instance in the KV transfer parameters of prefill requests
+	AdvertisedHost string `yaml:"advertised-host"`
+	// AdvertisedPort is the port other instances use to reach this simulator, if not set the
+	// simulator's port is used
+	AdvertisedPort int `yaml:"advertised-port"`
+	// KVCacheTransferBandwidth is the number of KV cache blocks transferred per second from a
+	// remote vLLM instance, zero means no per-block transfer time
+	KVCacheTransferBandwidth int `yaml:"kv-cache-transfer-bandwidth"`
+	// KVTransferTimeout is the time in seconds the KV cache blocks of a prefill request are kept
+	// for the decode instance
+	KVTransferTimeout int `yaml:"kv-transfer-timeout"`
 	// KVCacheTransferLatency time to "transfer" kv-cache from another vLLM instance in case P/D is activated, in milliseconds
 	KVCacheTransferLatency int `yaml:"kv-cache-transfer-latency"`
 
+	// StartupDelay is the time to load the model on startup, in milliseconds, if ModelSize is set,
+	// the time to load one GB of the model
+	StartupDelay int `yaml:"startup-delay"`
+	// StartupDelayMax is the maximal startup delay in milliseconds, if set, the startup delay is
+	// sampled between StartupDelay and StartupDelayMax
+	StartupDelayMax int `yaml:"startup-delay-max"`
+	// ModelSize is the size of the model's weights in GB, scales the startup delay
+	ModelSize float64 `yaml:"model-size"`
+	// EnableSleepMode enables the endpoints of sleep mode
+	EnableSleepMode bool `yaml:"enable-sleep-mode"`
+	// WakeUpLatency is the time to wake up from sleep mode, in milliseconds
+	WakeUpLatency int `yaml:"wake-up-latency"`
+	// EnableFaultInjection enables the endpoint that sets the simulated fault mode
+	EnableFaultInjection bool `yaml:"enable-fault-injection"`
+	// FaultMode is the fault mode of scheduled and random faults, valid values: unhealthy,
+	// engine-dead, stuck, slow
+	FaultMode string `yaml:"fault-mode"`
+	// FaultAfter is the time in milliseconds after startup to enter the fault mode, zero means no
+	// scheduled fault
+	FaultAfter int `yaml:"fault-after"`
+	// FaultMTBF is the mean time in milliseconds between random faults, zero means no random faults
+	FaultMTBF int `yaml:"fault-mtbf"`
+	// FaultRecoveryTime is the time in milliseconds to recover from scheduled and random faults,
+	// zero means no recovery
+	FaultRecoveryTime int `yaml:"fault-recovery-time"`
+	// FaultTokenLatency is the time in milliseconds added to each token in the slow fault mode
+	FaultTokenLatency int `yaml:"fault-token-latency"`
+	// DrainTimeout is the time in seconds to wait for the running and waiting requests to finish on shutdown
+	DrainTimeout int `yaml:"drain-timeout"`
+
 	// Mode defines the simulator response generation mode, valid values: echo, random
 	Mode string `yaml:"mode"`
+	// Tokenizer is the path to a HuggingFace tokenizer.json file, used to detokenize prompts of token IDs
+	Tokenizer string `yaml:"tokenizer"`
 	// Seed defines random seed for operations
 	Seed int64 `yaml:"seed"`
+
+	// EnableReasoning defines whether the simulator simulates a reasoning model, that generates
+	// reasoning content before the response in chat completions
+	EnableReasoning bool `yaml:"enable-reasoning"`
+	// ReasoningTokens is the number of reasoning tokens generated in reasoning mode
+	ReasoningTokens int `yaml:"reasoning-tokens"`
+	// MaxStoredResponses is the maximum number of stored /v1/responses responses, when the limit is
+	// reached the oldest response is evicted
+	MaxStoredResponses int `yaml:"max-stored-responses"`
+
+	// ToolResultsPolicy defines the response to chat requests that end with tool results,
+	// valid values: text - respond with text, random - respond with text or with more tool calls
+	ToolResultsPolicy string `yaml:"tool-results-policy"`
+
+	// MMImageTokens is the number of prompt tokens per image
+	MMImageTokens int `yaml:"mm-image-tokens"`
+	// MMImagePatchSize is the size in pixels of an image patch, if set, the number of tokens of an image
+	// in a base64 data URL is the number of patches in the image
+	MMImagePatchSize int `yaml:"mm-image-patch-size"`
+	// MMAudioTokens is the number of prompt tokens per audio
+	MMAudioTokens int `yaml:"mm-audio-tokens"`
+	// MMVideoTokens is the number of prompt tokens per video
+	MMVideoTokens int `yaml:"mm-video-tokens"`
+	// MMItemLatency is the time to process one multimodal item in the prompt, in milliseconds
+	MMItemLatency int `yaml:"mm-item-latency"`
+	// LimitMMPerPrompt is the maximum number of items of each modality (image, audio, video)
+	// in a single prompt, modalities that are not in the map are not limited
+	LimitMMPerPrompt map[string]int `yaml:"limit-mm-per-prompt"`
 }
 
 type loraModule struct {
@@ -71,6 +170,19 @@ type loraModule struct {
 	Path string `json:"path"`
 	// BaseModelName is the LoRA's base model
 	BaseModelName string `json:"base_model_name"`
+	// TimeToFirstToken overrides the time to first token of requests to this LoRA, in milliseconds
+	TimeToFirstToken *int `json:"time_to_first_token,omitempty"`
+	// InterTokenLatency overrides the time between generated tokens of requests to this LoRA, in milliseconds
+	InterTokenLatency *int `json:"inter_token_latency,omitempty"`
+	// Mode overrides the response generation mode of requests to this LoRA, valid values: echo, random
+	Mode string `json:"mode,omitempty"`
+	// Responses replaces the pre-defined sentences of the random mode for requests to this LoRA
+	Responses []string `json:"responses,omitempty"`
+	// ErrorRate overrides the fraction of requests to this LoRA that fail, between 0 and 1
+	ErrorRate *float64 `json:"error_rate,omitempty"`
+	// info is the LoRA's metadata read from the adapter_config.json file in its path,
+	// nil if the file was not found
+	info *vllmapi.LoraAdapterInfo
 }
 
 // Needed to parse values that contain multiple strings
@@ -91,7 +203,36 @@ func (l *multiString) Type() string {
 	return "strings"
 }
 
-func (c *configuration) unmarshalLoras() error {
+// Needed to parse a JSON object with integer values, e.g. '{"image": 2, "video": 1}'
+type intMap struct {
+	values *map[string]int
+}
+
+func (m *intMap) String() string {
+	if m.values == nil || *m.values == nil {
+		return ""
+	}
+	data, err := json.Marshal(*m.values)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+func (m *intMap) Set(val string) error {
+	values := make(map[string]int)
+	if err := json.Unmarshal([]byte(val), &values); err != nil {
+		return err
+	}
+	*m.values = values
+	return nil
+}
+
+func (m *intMap) Type() string {
+	return "json"
+}
+
+func (c *Configuration) unmarshalLoras() error {
 	c.LoraModules = make([]loraModule, 0)
 	for _, jsonStr := range c.LoraModulesString {
 		var lora loraModule
@@ -103,18 +244,33 @@ func (c *configuration) unmarshalLoras() error {
 	return nil
 }
 
-func newConfig() *configuration {
-	return &configuration{
-		Port:        vLLMDefaultPort,
-		MaxLoras:    1,
-		MaxNumSeqs:  5,
-		MaxModelLen: 1024,
-		Mode:        modeRandom,
-		Seed:        time.Now().UnixNano(),
+// NewConfig returns a configuration with the default values of the parameters
+func NewConfig() *Configuration {
+	return &Configuration{
+		Port:                     vLLMDefaultPort,
+		MaxLoras:                 1,
+		AllowRuntimeLoraUpdating: true,
+		MaxNumSeqs:               5,
+		MaxModelLen:              1024,
+		Mode:                     modeRandom,
+		Seed:                     time.Now().UnixNano(),
+		ToolResultsPolicy:        toolResultsPolicyText,
+		ReasoningTokens:          defaultReasoningTokens,
+		MaxStoredResponses:       defaultMaxStoredResponses,
+		MMImageTokens:            defaultImageTokens,
+		MMAudioTokens:            defaultAudioTokens,
+		MMVideoTokens:            defaultVideoTokens,
+		BlockSize:                defaultBlockSize,
+		DataParallelSize:         1,
+		AdvertisedHost:           defaultAdvertisedHost,
+		KVTransferTimeout:        defaultKVTransferTimeout,
+		DrainTimeout:             defaultDrainTimeout,
+		FaultMode:                faultModeEngineDead,
+		FaultTokenLatency:        defaultFaultTokenLatency,
 	}
 }
 
-func (c *configuration) load(configFile string) error {
+func (c *Configuration) load(configFile string) error {
 	configBytes, err := os.ReadFile(configFile)
 	if err != nil {
 		return fmt.Errorf("failed to read configuration file: %s", err)
@@ -127,7 +283,12 @@ func (c *configuration) load(configFile string) error {
 	return c.unmarshalLoras()
 }
 
-func (c *configuration) validate() error {
+// isBaseModel returns true if the given name is the base model or one of its served names
+func (c *Configuration) isBaseModel(name string) bool {
+	return name == c.Model || slices.Contains(c.ServedModelNames, name)
+}
+
+func (c *Configuration) validate() error {
 	if c.Model == "" {
 		return errors.New("model parameter is empty")
 	}
@@ -141,18 +302,104 @@ func (c *configuration) validate() error {
 	if c.Mode != modeEcho && c.Mode != modeRandom {
 		return fmt.Errorf("invalid mode '%s', valid values are 'random' and 'echo'", c.Mode)
 	}
+	if c.ToolResultsPolicy != toolResultsPolicyText && c.ToolResultsPolicy != toolResultsPolicyRandom {
+		return fmt.Errorf("invalid tool results policy '%s', valid values are 'text' and 'random'", c.ToolResultsPolicy)
+	}
 	if c.Port <= 0 {
 		return fmt.Errorf("invalid port '%d'", c.Port)
 	}
+	if c.MetricsPort < 0 {
+		return fmt.Errorf("invalid metrics port '%d'", c.MetricsPort)
+	}
+	if c.MetricsPort == c.Port {
+		return fmt.Errorf("metrics port '%d' must be different from port", c.MetricsPort)
+	}
 	if c.InterTokenLatency < 0 {
 		return errors.New("inter token latency cannot be negative")
 	}
 	if c.TimeToFirstToken < 0 {
 		return errors.New("time to first token cannot be negative")
 	}
+	if c.ErrorRate < 0 || c.ErrorRate > 1 {
+		return errors.New("error rate must be between 0 and 1")
+	}
+	if c.DataParallelSize < 1 {
+		return fmt.Errorf("invalid data parallel size '%d'", c.DataParallelSize)
+	}
+	if c.MetricsPort > 0 && c.MetricsPort >= c.Port && c.MetricsPort < c.Port+c.DataParallelSize {
+		return fmt.Errorf("metrics port '%d' cannot be one of the ports of the data parallel ranks", c.MetricsPort)
+	}
+	if c.BlockSize <= 0 {
+		return fmt.Errorf("invalid block size '%d'", c.BlockSize)
+	}
+	if c.AdvertisedHost == "" {
+		return errors.New("advertised host cannot be empty")
+	}
+	if c.AdvertisedPort < 0 {
+		return fmt.Errorf("invalid advertised port '%d'", c.AdvertisedPort)
+	}
+	if c.KVCacheTransferBandwidth < 0 {
+		return errors.New("kv-cache-transfer-bandwidth cannot be negative")
+	}
+	if c.KVTransferTimeout <= 0 {
+		return fmt.Errorf("invalid kv-transfer-timeout '%d'", c.KVTransferTimeout)
+	}
+	if c.StartupDelay < 0 {
+		return fmt.Errorf("invalid startup-delay '%d'", c.StartupDelay)
+	}
+	if c.StartupDelayMax != 0 && c.StartupDelayMax < c.StartupDelay {
+		return fmt.Errorf("startup-delay-max '%d' must be greater than or equal to startup-delay", c.StartupDelayMax)
+	}
+	if c.ModelSize < 0 {
+		return fmt.Errorf("invalid model-size '%g'", c.ModelSize)
+	}
+	if c.WakeUpLatency < 0 {
+		return fmt.Errorf("invalid wake-up-latency '%d'", c.WakeUpLatency)
+	}
+	if c.FaultMode == faultModeNone || !slices.Contains(validFaultModes, c.FaultMode) {
+		return fmt.Errorf("invalid fault-mode '%s', valid values are 'unhealthy', 'engine-dead', 'stuck' and 'slow'", c.FaultMode)
+	}
+	if c.FaultAfter < 0 {
+		return fmt.Errorf("invalid fault-after '%d'", c.FaultAfter)
+	}
+	if c.FaultMTBF < 0 {
+		return fmt.Errorf("invalid fault-mtbf '%d'", c.FaultMTBF)
+	}
+	if c.FaultRecoveryTime < 0 {
+		return fmt.Errorf("invalid fault-recovery-time '%d'", c.FaultRecoveryTime)
+	}
+	if c.FaultTokenLatency < 0 {
+		return fmt.Errorf("invalid fault-token-latency '%d'", c.FaultTokenLatency)
+	}
+	if c.DrainTimeout < 0 {
+		return fmt.Errorf("invalid drain-timeout '%d'", c.DrainTimeout)
+	}
 	if c.KVCacheTransferLatency < 0 {
 		return errors.New("kv-cache tranfer time cannot be negative")
 	}
+	if c.ReasoningTokens < 0 {
+		return errors.New("reasoning tokens cannot be negative")
+	}
+	if c.MaxStoredResponses < 1 {
+		return fmt.Errorf("invalid max-stored-responses '%d', must be at least 1", c.MaxStoredResponses)
+	}
+	if c.MMImageTokens < 0 || c.MMAudioTokens < 0 || c.MMVideoTokens < 0 {
+		return errors.New("multimodal tokens cannot be negative")
+	}
+	if c.MMImagePatchSize < 0 {
+		return errors.New("image patch size cannot be negative")
+	}
+	if c.MMItemLatency < 0 {
+		return errors.New("multimodal item latency cannot be negative")
+	}
+	for modality, limit := range c.LimitMMPerPrompt {
+		if modality != modalityImage && modality != modalityAudio && modality != modalityVideo {
+			return fmt.Errorf("invalid modality '%s' in limit-mm-per-prompt, valid values are 'image', 'audio' and 'video'", modality)
+		}
+		if limit < 0 {
+			return fmt.Errorf("limit-mm-per-prompt of modality '%s' cannot be negative", modality)
+		}
+	}
 	if c.MaxLoras < 1 {
 		return errors.New("max LoRAs cannot be less than 1")
 	}
@@ -163,6 +410,18 @@ func (c *configuration) validate() error {
 	if c.MaxCPULoras < c.MaxLoras {
 		return errors.New("max CPU LoRAs cannot be less than max LoRAs")
 	}
+	if c.LoraLoadLatency < 0 {
+		return errors.New("LoRA load latency cannot be negative")
+	}
+	if c.LoraSwapLatency < 0 {
+		return errors.New("LoRA swap latency cannot be negative")
+	}
+	if c.LoraResolverCacheDir != "" && !c.AllowRuntimeLoraUpdating {
+		return errors.New("LoRA resolver cannot be used when runtime LoRA updating is not allowed")
+	}
+	if c.LoraTokenOverhead < 0 {
+		return errors.New("LoRA token overhead cannot be negative")
+	}
 	if c.MaxModelLen < 1 {
 		return errors.New("max model len cannot be less than 1")
 	}
@@ -174,9 +433,21 @@ func (c *configuration) validate() error {
 		if lora.Name == "" {
 			return errors.New("empty LoRA name")
 		}
-		if lora.BaseModelName != "" && lora.BaseModelName != c.Model {
+		if lora.BaseModelName != "" && !c.isBaseModel(lora.BaseModelName) {
 			return fmt.Errorf("unknown base model '%s' for LoRA '%s'", lora.BaseModelName, lora.Name)
 		}
+		if lora.TimeToFirstToken != nil && *lora.TimeToFirstToken < 0 {
+			return fmt.Errorf("time to first token of LoRA '%s' cannot be negative", lora.Name)
+		}
+		if lora.InterTokenLatency != nil && *lora.InterTokenLatency < 0 {
+			return fmt.Errorf("inter token latency of LoRA '%s' cannot be negative", lora.Name)
+		}
+		if lora.Mode != "" && lora.Mode != modeEcho && lora.Mode != modeRandom {
+			return fmt.Errorf("invalid mode '%s' of LoRA '%s', valid values are 'random' and 'echo'", lora.Mode, lora.Name)
+		}
+		if lora.ErrorRate != nil && (*lora.ErrorRate < 0 || *lora.ErrorRate > 1) {
+			return fmt.Errorf("error rate of LoRA '%s' must be between 0 and 1", lora.Name)
+		}
 	}
 
 	return nil
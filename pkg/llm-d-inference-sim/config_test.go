@@ -28,7 +28,7 @@ const (
 	qwenModelName = "Qwen/Qwen2-0.5B"
 )
 
-func createSimConfig(args []string) (*configuration, error) {
+func createSimConfig(args []string) (*Configuration, error) {
 	oldArgs := os.Args
 	defer func() {
 		os.Args = oldArgs
@@ -45,8 +45,8 @@ func createSimConfig(args []string) (*configuration, error) {
 	return s.config, nil
 }
 
-func createDefaultConfig(model string) *configuration {
-	c := newConfig()
+func createDefaultConfig(model string) *Configuration {
+	c := NewConfig()
 
 	c.Model = model
 	c.ServedModelNames = []string{c.Model}
@@ -66,14 +66,14 @@ func createDefaultConfig(model string) *configuration {
 type testCase struct {
 	name           string
 	args           []string
-	expectedConfig *configuration
+	expectedConfig *Configuration
 }
 
 var _ = Describe("Simulator configuration", func() {
 	tests := make([]testCase, 0)
 
 	// Simple config with a few parameters
-	c := newConfig()
+	c := NewConfig()
 	c.Model = model
 	c.ServedModelNames = []string{c.Model}
 	c.MaxCPULoras = 1
@@ -232,8 +232,149 @@ var _ = Describe("Simulator configuration", func() {
 	}
 	tests = append(tests, test)
 
+	test = testCase{
+		name: "invalid tool results policy",
+		args: []string{"cmd", "--tool-results-policy", "tools", "--config", "../../manifests/config.yaml"},
+	}
+	tests = append(tests, test)
+
+	test = testCase{
+		name: "invalid limit-mm-per-prompt",
+		args: []string{"cmd", "--limit-mm-per-prompt", "{\"text\": 1}", "--config", "../../manifests/config.yaml"},
+	}
+	tests = append(tests, test)
+
+	test = testCase{
+		name: "invalid lora-load-latency",
+		args: []string{"cmd", "--lora-load-latency", "-1", "--config", "../../manifests/config.yaml"},
+	}
+	tests = append(tests, test)
+
+	test = testCase{
+		name: "invalid lora-token-overhead",
+		args: []string{"cmd", "--lora-token-overhead", "-1", "--config", "../../manifests/config.yaml"},
+	}
+	tests = append(tests, test)
+
+	test = testCase{
+		name: "LoRA resolver without runtime LoRA updating",
+		args: []string{"cmd", "--lora-resolver-cache-dir", "/tmp", "--allow-runtime-lora-updating=false",
+			"--config", "../../manifests/config.yaml"},
+	}
+	tests = append(tests, test)
+
+	test = testCase{
+		name: "invalid error-rate",
+		args: []string{"cmd", "--error-rate", "1.5", "--config", "../../manifests/config.yaml"},
+	}
+	tests = append(tests, test)
+
+	test = testCase{
+		name: "invalid metrics-port",
+		args: []string{"cmd", "--metrics-port", "-1", "--config", "../../manifests/config.yaml"},
+	}
+	tests = append(tests, test)
+
+	test = testCase{
+		name: "metrics-port same as port",
+		args: []string{"cmd", "--port", "8001", "--metrics-port", "8001", "--config", "../../manifests/config.yaml"},
+	}
+	tests = append(tests, test)
+
+	test = testCase{
+		name: "invalid block-size",
+		args: []string{"cmd", "--block-size", "0", "--config", "../../manifests/config.yaml"},
+	}
+	tests = append(tests, test)
+
+	test = testCase{
+		name: "invalid kv-transfer-timeout",
+		args: []string{"cmd", "--kv-transfer-timeout", "0", "--config", "../../manifests/config.yaml"},
+	}
+	tests = append(tests, test)
+
+	test = testCase{
+		name: "invalid data-parallel-size",
+		args: []string{"cmd", "--data-parallel-size", "0", "--config", "../../manifests/config.yaml"},
+	}
+	tests = append(tests, test)
+
+	test = testCase{
+		name: "metrics-port in the ports of the data parallel ranks",
+		args: []string{"cmd", "--port", "8001", "--data-parallel-size", "2", "--metrics-port", "8002",
+			"--config", "../../manifests/config.yaml"},
+	}
+	tests = append(tests, test)
+
+	test = testCase{
+		name: "invalid drain-timeout",
+		args: []string{"cmd", "--drain-timeout", "-1", "--config", "../../manifests/config.yaml"},
+	}
+	tests = append(tests, test)
+
+	test = testCase{
+		name: "startup-delay-max smaller than startup-delay",
+		args: []string{"cmd", "--startup-delay", "200", "--startup-delay-max", "100",
+			"--config", "../../manifests/config.yaml"},
+	}
+	tests = append(tests, test)
+
+	test = testCase{
+		name: "invalid model-size",
+		args: []string{"cmd", "--model-size", "-1", "--config", "../../manifests/config.yaml"},
+	}
+	tests = append(tests, test)
+
+	test = testCase{
+		name: "invalid wake-up-latency",
+		args: []string{"cmd", "--wake-up-latency", "-1", "--config", "../../manifests/config.yaml"},
+	}
+	tests = append(tests, test)
+
+	test = testCase{
+		name: "invalid fault-mode",
+		args: []string{"cmd", "--fault-mode", "broken", "--config", "../../manifests/config.yaml"},
+	}
+	tests = append(tests, test)
+
+	test = testCase{
+		name: "fault-mode none",
+		args: []string{"cmd", "--fault-mode", "none", "--config", "../../manifests/config.yaml"},
+	}
+	tests = append(tests, test)
+
+	test = testCase{
+		name: "invalid fault-after",
+		args: []string{"cmd", "--fault-after", "-1", "--config", "../../manifests/config.yaml"},
+	}
+	tests = append(tests, test)
+
+	test = testCase{
+		name: "invalid fault-mtbf",
+		args: []string{"cmd", "--fault-mtbf", "-1", "--config", "../../manifests/config.yaml"},
+	}
+	tests = append(tests, test)
+
+	test = testCase{
+		name: "invalid fault-recovery-time",
+		args: []string{"cmd", "--fault-recovery-time", "-1", "--config", "../../manifests/config.yaml"},
+	}
+	tests = append(tests, test)
+
+	test = testCase{
+		name: "invalid fault-token-latency",
+		args: []string{"cmd", "--fault-token-latency", "-1", "--config", "../../manifests/config.yaml"},
+	}
+	tests = append(tests, test)
+
+	test = testCase{
+		name: "invalid max-stored-responses",
+		args: []string{"cmd", "--max-stored-responses", "0", "--config", "../../manifests/config.yaml"},
+	}
+	tests = append(tests, test)
+
 	DescribeTable("check configurations",
-		func(args []string, expectedConfig *configuration) {
+		func(args []string, expectedConfig *Configuration) {
 			config, err := createSimConfig(args)
 			Expect(err).NotTo(HaveOccurred())
 			Expect(config).To(Equal(expectedConfig))
@@ -259,6 +400,29 @@ var _ = Describe("Simulator configuration", func() {
 		Entry(tests[11].name, tests[11].args),
 		Entry(tests[12].name, tests[12].args),
 		Entry(tests[13].name, tests[13].args),
+		Entry(tests[14].name, tests[14].args),
+		Entry(tests[15].name, tests[15].args),
+		Entry(tests[16].name, tests[16].args),
+		Entry(tests[17].name, tests[17].args),
+		Entry(tests[18].name, tests[18].args),
+		Entry(tests[19].name, tests[19].args),
+		Entry(tests[20].name, tests[20].args),
+		Entry(tests[21].name, tests[21].args),
+		Entry(tests[22].name, tests[22].args),
+		Entry(tests[23].name, tests[23].args),
+		Entry(tests[24].name, tests[24].args),
+		Entry(tests[25].name, tests[25].args),
+		Entry(tests[26].name, tests[26].args),
+		Entry(tests[27].name, tests[27].args),
+		Entry(tests[28].name, tests[28].args),
+		Entry(tests[29].name, tests[29].args),
+		Entry(tests[30].name, tests[30].args),
+		Entry(tests[31].name, tests[31].args),
+		Entry(tests[32].name, tests[32].args),
+		Entry(tests[33].name, tests[33].args),
+		Entry(tests[34].name, tests[34].args),
+		Entry(tests[35].name, tests[35].args),
+		Entry(tests[36].name, tests[36].args),
 	)
 
 	It("should accept max-num-batched-tokens parameter", func() {
@@ -271,8 +435,26 @@ var _ = Describe("Simulator configuration", func() {
 		Expect(config.MaxNumBatchedTokens).Should(Equal(1024))
 	})
 
+	It("should accept multimodal parameters", func() {
+		config, err := createSimConfig([]string{
+			"test",
+			"--model", qwenModelName,
+			"--mm-image-tokens", "100",
+			"--mm-image-patch-size", "28",
+			"--mm-item-latency", "10",
+			"--limit-mm-per-prompt", "{\"image\": 2, \"video\": 0}",
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(config.MMImageTokens).Should(Equal(100))
+		Expect(config.MMImagePatchSize).Should(Equal(28))
+		Expect(config.MMAudioTokens).Should(Equal(defaultAudioTokens))
+		Expect(config.MMVideoTokens).Should(Equal(defaultVideoTokens))
+		Expect(config.MMItemLatency).Should(Equal(10))
+		Expect(config.LimitMMPerPrompt).Should(Equal(map[string]int{"image": 2, "video": 0}))
+	})
+
 	It("should validate max-num-batched-tokens cannot be negative", func() {
-		config := newConfig()
+		config := NewConfig()
 		config.Model = qwenModelName
 		config.MaxNumBatchedTokens = -1
 
@@ -282,7 +464,7 @@ var _ = Describe("Simulator configuration", func() {
 	})
 
 	It("should allow max-num-batched-tokens to be zero (disabled)", func() {
-		config := newConfig()
+		config := NewConfig()
 		config.Model = qwenModelName
 		config.MaxNumBatchedTokens = 0
 
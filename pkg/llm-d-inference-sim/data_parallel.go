@@ -0,0 +1,93 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Simulation of data parallel ranks, each rank is an independent engine
+package llmdinferencesim
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/valyala/fasthttp"
+)
+
+// dataParallelRankHeader is the header that defines the data parallel rank that should process
+// a request, same as in vLLM
+const dataParallelRankHeader = "X-data-parallel-rank"
+
+// initDataParallelRanks creates the engines of the data parallel ranks, and initializes the
+// prometheus metrics and the request handler of each one of them. Rank i listens on port + i,
+// and exposes its metrics on metrics port + i if a metrics port is set.
+func (s *VllmSimulator) initDataParallelRanks() error {
+	s.dpRanks = []*VllmSimulator{s}
+	for rank := 1; rank < s.config.DataParallelSize; rank++ {
+		rankSim, err := New(s.logger.WithValues("rank", rank))
+		if err != nil {
+			return err
+		}
+		rankConfig := *s.config
+		rankConfig.Port += rank
+		if rankConfig.MetricsPort > 0 {
+			rankConfig.MetricsPort += rank
+		}
+		if rankConfig.AdvertisedPort > 0 {
+			rankConfig.AdvertisedPort += rank
+		}
+		if err := rankSim.initWithConfig(&rankConfig); err != nil {
+			return err
+		}
+		rankSim.rank = rank
+		s.dpRanks = append(s.dpRanks, rankSim)
+	}
+
+	for _, rankSim := range s.dpRanks {
+		rankSim.dpRanks = s.dpRanks
+		// a response stored by one rank can be retrieved and chained by the other ranks
+		rankSim.responsesStore = s.responsesStore
+		if err := rankSim.createAndRegisterPrometheus(); err != nil {
+			return err
+		}
+		rankSim.handler = rankSim.createRouter().Handler
+	}
+	return nil
+}
+
+// getEngines returns the engines of all the data parallel ranks, or this engine if data
+// parallelism is not used
+func (s *VllmSimulator) getEngines() []*VllmSimulator {
+	if s.dpRanks == nil {
+		return []*VllmSimulator{s}
+	}
+	return s.dpRanks
+}
+
+// handleDataParallelRequest passes the given request to the engine of the data parallel rank
+// defined in its header, or to this engine if the header is not set
+func (s *VllmSimulator) handleDataParallelRequest(ctx *fasthttp.RequestCtx) {
+	rankHeader := ctx.Request.Header.Peek(dataParallelRankHeader)
+	if len(rankHeader) == 0 || s.dpRanks == nil {
+		s.handler(ctx)
+		return
+	}
+
+	rank, err := strconv.Atoi(string(rankHeader))
+	if err != nil || rank < 0 || rank >= len(s.dpRanks) {
+		s.sendCompletionError(ctx, fmt.Sprintf("Invalid data parallel rank '%s', the data parallel size is %d",
+			rankHeader, len(s.dpRanks)), "BadRequestError", fasthttp.StatusBadRequest)
+		return
+	}
+	s.dpRanks[rank].handler(ctx)
+}
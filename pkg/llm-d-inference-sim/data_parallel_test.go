@@ -0,0 +1,146 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+// sendToRank sends the given request to the given data parallel rank, and returns the response's
+// status code and body
+func sendToRank(client *http.Client, method string, path string, rank string, body string) (int, string) {
+	req, err := http.NewRequest(method, "http://localhost"+path, strings.NewReader(body))
+	Expect(err).NotTo(HaveOccurred())
+	req.Header.Set("Content-Type", "application/json")
+	if rank != "" {
+		req.Header.Set(dataParallelRankHeader, rank)
+	}
+	resp, err := client.Do(req)
+	Expect(err).NotTo(HaveOccurred())
+	defer func() {
+		Expect(resp.Body.Close()).To(Succeed())
+	}()
+	respBody, err := io.ReadAll(resp.Body)
+	Expect(err).NotTo(HaveOccurred())
+	return resp.StatusCode, string(respBody)
+}
+
+var _ = Describe("Data parallel", func() {
+	completionBody := `{"model": "` + model + `", "prompt": "Hello world", "max_tokens": 2}`
+
+	It("Should create a rank per port", func() {
+		s, err := startSimulatorWithListener(context.TODO(), modeEcho, []string{"cmd", "--model", model,
+			"--data-parallel-size", "3", "--port", "8100", "--metrics-port", "9100"}, fasthttputil.NewInmemoryListener())
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(s.dpRanks).To(HaveLen(3))
+		for rank, rankSim := range s.dpRanks {
+			Expect(rankSim.rank).To(Equal(rank))
+			Expect(rankSim.config.Port).To(Equal(8100 + rank))
+			Expect(rankSim.config.MetricsPort).To(Equal(9100 + rank))
+			if rank > 0 {
+				Expect(rankSim.registry).NotTo(BeIdenticalTo(s.registry))
+			}
+		}
+		Expect(s.dpRanks[1].engineID).NotTo(Equal(s.engineID))
+	})
+
+	It("Should pass requests to the rank in the header", func() {
+		client, err := startServerWithArgs(context.TODO(), modeEcho, []string{"cmd", "--model", model, "--mode", modeEcho,
+			"--data-parallel-size", "2"})
+		Expect(err).NotTo(HaveOccurred())
+
+		status, body := sendToRank(client, http.MethodPost, "/v1/completions", "1", completionBody)
+		Expect(status).To(Equal(http.StatusOK), body)
+
+		// each rank reports its own metrics, labeled by its rank
+		_, metrics := sendToRank(client, http.MethodGet, "/metrics", "1", "")
+		Expect(metrics).To(ContainSubstring(`vllm:request_success_total{engine="1",finished_reason="stop",model_name="my_model"} 1`))
+		_, metrics = sendToRank(client, http.MethodGet, "/metrics", "", "")
+		Expect(metrics).To(ContainSubstring(`engine="0"`))
+		Expect(metrics).NotTo(ContainSubstring("vllm:request_success_total{"))
+
+		status, body = sendToRank(client, http.MethodPost, "/v1/completions", "2", completionBody)
+		Expect(status).To(Equal(http.StatusBadRequest))
+		Expect(body).To(ContainSubstring("Invalid data parallel rank '2'"))
+	})
+
+	It("Should process the requests of each rank in its own queue", func() {
+		client, err := startServerWithArgs(context.TODO(), modeEcho, []string{"cmd", "--model", model, "--mode", modeEcho,
+			"--data-parallel-size", "2", "--max-num-seqs", "1", "--time-to-first-token", "300"})
+		Expect(err).NotTo(HaveOccurred())
+
+		start := time.Now()
+		var wg sync.WaitGroup
+		for _, rank := range []string{"0", "1"} {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				status, body := sendToRank(client, http.MethodPost, "/v1/completions", rank, completionBody)
+				Expect(status).To(Equal(http.StatusOK), body)
+			}()
+		}
+		wg.Wait()
+		// with a single queue the second request would wait for the first one
+		Expect(time.Since(start)).To(BeNumerically("<", 550*time.Millisecond))
+	})
+
+	It("Should load and unload LoRA adapters on all the ranks", func() {
+		client, err := startServerWithArgs(context.TODO(), modeEcho, []string{"cmd", "--model", model, "--mode", modeEcho,
+			"--data-parallel-size", "2"})
+		Expect(err).NotTo(HaveOccurred())
+		loraBody := `{"model": "lora1", "prompt": "Hello world", "max_tokens": 2}`
+
+		status, body := sendToRank(client, http.MethodPost, "/v1/load_lora_adapter", "1",
+			`{"lora_name": "lora1", "lora_path": "/path/to/lora1"}`)
+		Expect(status).To(Equal(http.StatusOK), body)
+		for _, rank := range []string{"0", "1"} {
+			status, body = sendToRank(client, http.MethodPost, "/v1/completions", rank, loraBody)
+			Expect(status).To(Equal(http.StatusOK), body)
+		}
+
+		status, body = sendToRank(client, http.MethodPost, "/v1/unload_lora_adapter", "0", `{"lora_name": "lora1"}`)
+		Expect(status).To(Equal(http.StatusOK), body)
+		for _, rank := range []string{"0", "1"} {
+			status, _ = sendToRank(client, http.MethodPost, "/v1/completions", rank, loraBody)
+			Expect(status).To(Equal(http.StatusNotFound))
+		}
+	})
+
+	It("Should roll back LoRA updates that fail on one of the ranks", func() {
+		s, err := startSimulatorWithListener(context.TODO(), modeEcho, []string{"cmd", "--model", model, "--mode", modeEcho,
+			"--data-parallel-size", "2"}, fasthttputil.NewInmemoryListener())
+		Expect(err).NotTo(HaveOccurred())
+		s.dpRanks[1].loraAdaptors.Store("lora1", loraModule{Name: "lora1"})
+
+		errMsg, _, errCode := s.addLoraToEngines(loraModule{Name: "lora1"})
+		Expect(errMsg).To(ContainSubstring("has already been loaded"))
+		Expect(errCode).To(Equal(http.StatusBadRequest))
+		Expect(s.isLora("lora1")).To(BeFalse())
+		Expect(s.dpRanks[1].isLora("lora1")).To(BeTrue())
+	})
+})
@@ -0,0 +1,214 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Simulation of engine faults, health degradation and recovery
+package llmdinferencesim
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	// faultModeNone is the state of a healthy engine
+	faultModeNone = "none"
+	// faultModeUnhealthy fails the health checks, requests are processed as usual
+	faultModeUnhealthy = "unhealthy"
+	// faultModeEngineDead fails the health checks and all the requests, like vLLM when the engine
+	// core dies
+	faultModeEngineDead = "engine-dead"
+	// faultModeStuck makes the requests hang, they don't finish until the engine recovers
+	faultModeStuck = "stuck"
+	// faultModeSlow adds the fault token latency to the generation of each token
+	faultModeSlow = "slow"
+	// defaultFaultTokenLatency is the default latency in milliseconds added to each token in
+	// the slow fault mode
+	defaultFaultTokenLatency = 1000
+	// faultCheckInterval is the interval of checking whether a stuck engine recovered
+	faultCheckInterval = 10 * time.Millisecond
+	// engineDeadErrorMsg is the error message of requests to a dead engine, same as in vLLM
+	engineDeadErrorMsg = "EngineCore encountered an issue. See stack trace (above) for the root cause."
+)
+
+// validFaultModes are the modes that can be set by the fault endpoint
+var validFaultModes = []string{faultModeNone, faultModeUnhealthy, faultModeEngineDead, faultModeStuck, faultModeSlow}
+
+// faultRequest is a request to the fault endpoint, sets the fault mode of the engine
+type faultRequest struct {
+	// Mode is the fault mode
+	Mode string `json:"mode"`
+	// Duration is the time in milliseconds until the engine recovers, zero means the engine
+	// doesn't recover
+	Duration int `json:"duration"`
+}
+
+// faultResponse is the response of the fault endpoint
+type faultResponse struct {
+	// Mode is the current fault mode
+	Mode string `json:"mode"`
+}
+
+// HandleSetFault http handler for POST /fault, sets the fault mode of the engines of all the data
+// parallel ranks
+func (s *VllmSimulator) HandleSetFault(ctx *fasthttp.RequestCtx) {
+	var req faultRequest
+	if err := json.Unmarshal(ctx.Request.Body(), &req); err != nil {
+		s.sendCompletionError(ctx, "Failed to read and parse request body, "+err.Error(), "BadRequestError",
+			fasthttp.StatusBadRequest)
+		return
+	}
+	if !slices.Contains(validFaultModes, req.Mode) {
+		s.sendCompletionError(ctx, fmt.Sprintf("Invalid fault mode '%s', valid values are %v", req.Mode, validFaultModes),
+			"BadRequestError", fasthttp.StatusBadRequest)
+		return
+	}
+	if req.Duration < 0 {
+		s.sendCompletionError(ctx, fmt.Sprintf("Invalid fault duration '%d'", req.Duration), "BadRequestError",
+			fasthttp.StatusBadRequest)
+		return
+	}
+
+	for _, engine := range s.getEngines() {
+		engine.setFault(req.Mode, time.Duration(req.Duration)*time.Millisecond)
+	}
+	s.sendFaultMode(ctx)
+}
+
+// HandleGetFault http handler for GET /fault, returns the fault mode of the engine
+func (s *VllmSimulator) HandleGetFault(ctx *fasthttp.RequestCtx) {
+	s.sendFaultMode(ctx)
+}
+
+// sendFaultMode sends the current fault mode of the engine
+func (s *VllmSimulator) sendFaultMode(ctx *fasthttp.RequestCtx) {
+	data, err := json.Marshal(faultResponse{Mode: s.getFaultMode()})
+	if err != nil {
+		s.logger.Error(err, "Failed to marshal fault response")
+		ctx.Error("Failed to marshal fault response, "+err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	ctx.Response.Header.SetContentType("application/json")
+	ctx.Response.Header.SetStatusCode(fasthttp.StatusOK)
+	ctx.Response.SetBody(data)
+}
+
+// setFault sets the fault mode of the engine, if the duration is not zero, the engine recovers
+// after the duration unless the mode is changed in the meantime
+func (s *VllmSimulator) setFault(mode string, duration time.Duration) {
+	s.faultLock.Lock()
+	defer s.faultLock.Unlock()
+
+	s.faultMode = mode
+	s.faultID++
+	s.logger.Info("Fault mode changed", "mode", mode, "duration", duration)
+	if mode == faultModeNone || duration == 0 {
+		return
+	}
+
+	faultID := s.faultID
+	time.AfterFunc(duration, func() {
+		s.faultLock.Lock()
+		defer s.faultLock.Unlock()
+
+		if s.faultID == faultID {
+			s.faultMode = faultModeNone
+			s.logger.Info("Recovered from fault", "mode", mode)
+		}
+	})
+}
+
+// getFaultMode returns the fault mode of the engine
+func (s *VllmSimulator) getFaultMode() string {
+	s.faultLock.Lock()
+	defer s.faultLock.Unlock()
+
+	if s.faultMode == "" {
+		return faultModeNone
+	}
+	return s.faultMode
+}
+
+// isHealthy returns false if the engine's health checks fail
+func (s *VllmSimulator) isHealthy() bool {
+	mode := s.getFaultMode()
+	return mode != faultModeUnhealthy && mode != faultModeEngineDead
+}
+
+// isEngineDead returns true if the engine is dead, all the requests fail
+func (s *VllmSimulator) isEngineDead() bool {
+	return s.getFaultMode() == faultModeEngineDead
+}
+
+// waitWhileStuck blocks while the engine is stuck
+func (s *VllmSimulator) waitWhileStuck() {
+	for s.getFaultMode() == faultModeStuck {
+		time.Sleep(faultCheckInterval)
+	}
+}
+
+// getFaultTokenLatency returns the latency in milliseconds added to each token by the current
+// fault mode
+func (s *VllmSimulator) getFaultTokenLatency() int {
+	if s.getFaultMode() == faultModeSlow {
+		return s.config.FaultTokenLatency
+	}
+	return 0
+}
+
+// startFaultInjection starts the faults defined in the configuration, a fault after fault-after
+// milliseconds, and random faults with a mean time between failures of fault-mtbf milliseconds.
+// The engine recovers from these faults after fault-recovery-time milliseconds, if it is set.
+func (s *VllmSimulator) startFaultInjection(ctx context.Context) {
+	recoveryTime := time.Duration(s.config.FaultRecoveryTime) * time.Millisecond
+	if s.config.FaultAfter > 0 {
+		time.AfterFunc(time.Duration(s.config.FaultAfter)*time.Millisecond, func() {
+			if ctx.Err() == nil {
+				s.setFault(s.config.FaultMode, recoveryTime)
+			}
+		})
+	}
+	if s.config.FaultMTBF > 0 {
+		go s.injectRandomFaults(ctx, recoveryTime)
+	}
+}
+
+// injectRandomFaults injects faults with exponentially distributed times between them, until
+// the context is done or the engine doesn't recover from a fault
+func (s *VllmSimulator) injectRandomFaults(ctx context.Context, recoveryTime time.Duration) {
+	for {
+		timeToFailure := time.Duration(s.random.randomExponential(float64(s.config.FaultMTBF)) * float64(time.Millisecond))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(timeToFailure):
+		}
+
+		s.setFault(s.config.FaultMode, recoveryTime)
+		if recoveryTime == 0 {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(recoveryTime):
+		}
+	}
+}
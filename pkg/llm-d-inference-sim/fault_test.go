@@ -0,0 +1,157 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Fault injection", func() {
+	completionBody := `{"model": "` + model + `", "prompt": "Hello world", "max_tokens": 2}`
+
+	It("Should not support fault injection by default", func() {
+		client, err := startServerWithArgs(context.TODO(), modeEcho, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		status, _ := sendToRank(client, http.MethodGet, "/fault", "", "")
+		Expect(status).To(Equal(http.StatusNotFound))
+		status, _ = sendToRank(client, http.MethodPost, "/fault", "", `{"mode": "engine-dead"}`)
+		Expect(status).To(Equal(http.StatusNotFound))
+	})
+
+	It("Should fail health checks and requests of a dead engine until it recovers", func() {
+		client, err := startServerWithArgs(context.TODO(), modeEcho, []string{"cmd", "--model", model, "--mode", modeEcho,
+			"--enable-fault-injection"})
+		Expect(err).NotTo(HaveOccurred())
+
+		_, body := sendToRank(client, http.MethodGet, "/fault", "", "")
+		Expect(body).To(MatchJSON(`{"mode": "none"}`))
+
+		status, body := sendToRank(client, http.MethodPost, "/fault", "", `{"mode": "engine-dead", "duration": 500}`)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body).To(MatchJSON(`{"mode": "engine-dead"}`))
+
+		status, _ = sendToRank(client, http.MethodGet, "/health", "", "")
+		Expect(status).To(Equal(http.StatusInternalServerError))
+		status, _ = sendToRank(client, http.MethodGet, "/ready", "", "")
+		Expect(status).To(Equal(http.StatusServiceUnavailable))
+		status, body = sendToRank(client, http.MethodPost, "/v1/completions", "", completionBody)
+		Expect(status).To(Equal(http.StatusInternalServerError))
+		Expect(body).To(ContainSubstring("EngineDeadError"))
+		Expect(body).To(ContainSubstring(engineDeadErrorMsg))
+
+		Eventually(func() int {
+			status, _ := sendToRank(client, http.MethodGet, "/health", "", "")
+			return status
+		}, time.Second).Should(Equal(http.StatusOK))
+		_, body = sendToRank(client, http.MethodGet, "/fault", "", "")
+		Expect(body).To(MatchJSON(`{"mode": "none"}`))
+		status, body = sendToRank(client, http.MethodPost, "/v1/completions", "", completionBody)
+		Expect(status).To(Equal(http.StatusOK), body)
+	})
+
+	It("Should fail health checks of an unhealthy engine and process requests", func() {
+		client, err := startServerWithArgs(context.TODO(), modeEcho, []string{"cmd", "--model", model, "--mode", modeEcho,
+			"--enable-fault-injection"})
+		Expect(err).NotTo(HaveOccurred())
+
+		status, _ := sendToRank(client, http.MethodPost, "/fault", "", `{"mode": "unhealthy"}`)
+		Expect(status).To(Equal(http.StatusOK))
+		status, _ = sendToRank(client, http.MethodGet, "/health", "", "")
+		Expect(status).To(Equal(http.StatusInternalServerError))
+		status, body := sendToRank(client, http.MethodPost, "/v1/completions", "", completionBody)
+		Expect(status).To(Equal(http.StatusOK), body)
+
+		status, _ = sendToRank(client, http.MethodPost, "/fault", "", `{"mode": "none"}`)
+		Expect(status).To(Equal(http.StatusOK))
+		status, _ = sendToRank(client, http.MethodGet, "/health", "", "")
+		Expect(status).To(Equal(http.StatusOK))
+	})
+
+	It("Should not finish requests to a stuck engine until it recovers", func() {
+		client, err := startServerWithArgs(context.TODO(), modeEcho, []string{"cmd", "--model", model, "--mode", modeEcho,
+			"--enable-fault-injection"})
+		Expect(err).NotTo(HaveOccurred())
+
+		status, _ := sendToRank(client, http.MethodPost, "/fault", "", `{"mode": "stuck"}`)
+		Expect(status).To(Equal(http.StatusOK))
+
+		completed := make(chan int, 1)
+		go func() {
+			defer GinkgoRecover()
+			status, _ := sendToRank(client, http.MethodPost, "/v1/completions", "", completionBody)
+			completed <- status
+		}()
+		Consistently(completed, 300*time.Millisecond).ShouldNot(Receive())
+
+		status, _ = sendToRank(client, http.MethodPost, "/fault", "", `{"mode": "none"}`)
+		Expect(status).To(Equal(http.StatusOK))
+		Eventually(completed).Should(Receive(Equal(http.StatusOK)))
+	})
+
+	It("Should add the fault token latency to the tokens of a slow engine", func() {
+		client, err := startServerWithArgs(context.TODO(), modeEcho, []string{"cmd", "--model", model, "--mode", modeEcho,
+			"--enable-fault-injection", "--fault-token-latency", "100"})
+		Expect(err).NotTo(HaveOccurred())
+
+		status, _ := sendToRank(client, http.MethodPost, "/fault", "", `{"mode": "slow"}`)
+		Expect(status).To(Equal(http.StatusOK))
+
+		// the time to first token and the latency of the second token
+		start := time.Now()
+		status, body := sendToRank(client, http.MethodPost, "/v1/completions", "", completionBody)
+		Expect(status).To(Equal(http.StatusOK), body)
+		Expect(time.Since(start)).To(BeNumerically(">=", 200*time.Millisecond))
+	})
+
+	It("Should enter the fault mode after the configured time and recover", func() {
+		client, err := startServerWithArgs(context.TODO(), modeEcho, []string{"cmd", "--model", model, "--mode", modeEcho,
+			"--fault-mode", "unhealthy", "--fault-after", "200", "--fault-recovery-time", "300"})
+		Expect(err).NotTo(HaveOccurred())
+
+		status, _ := sendToRank(client, http.MethodGet, "/health", "", "")
+		Expect(status).To(Equal(http.StatusOK))
+		Eventually(func() int {
+			status, _ := sendToRank(client, http.MethodGet, "/health", "", "")
+			return status
+		}, time.Second, 10*time.Millisecond).Should(Equal(http.StatusInternalServerError))
+		Eventually(func() int {
+			status, _ := sendToRank(client, http.MethodGet, "/health", "", "")
+			return status
+		}, time.Second, 10*time.Millisecond).Should(Equal(http.StatusOK))
+	})
+
+	It("Should reject invalid fault modes", func() {
+		client, err := startServerWithArgs(context.TODO(), modeEcho, []string{"cmd", "--model", model, "--mode", modeEcho,
+			"--enable-fault-injection"})
+		Expect(err).NotTo(HaveOccurred())
+
+		status, body := sendToRank(client, http.MethodPost, "/fault", "", `{"mode": "broken"}`)
+		Expect(status).To(Equal(http.StatusBadRequest))
+		Expect(body).To(ContainSubstring("Invalid fault mode 'broken'"))
+		status, body = sendToRank(client, http.MethodPost, "/fault", "", `{"mode": "slow", "duration": -1}`)
+		Expect(status).To(Equal(http.StatusBadRequest))
+		Expect(body).To(ContainSubstring("Invalid fault duration '-1'"))
+		_, body = sendToRank(client, http.MethodGet, "/fault", "", "")
+		Expect(body).To(MatchJSON(`{"mode": "none"}`))
+	})
+})
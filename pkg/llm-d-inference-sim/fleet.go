@@ -0,0 +1,278 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Fleet mode, runs several simulator instances in a single process
+package llmdinferencesim
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/go-logr/logr"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+	"k8s.io/klog/v2"
+)
+
+// fleetConfig is the configuration of a fleet of simulators
+type fleetConfig struct {
+	// BasePort is the port of the first instance, the other instances listen on consecutive ports
+	BasePort int `yaml:"base-port"`
+	// MetricsBasePort is the metrics port of the first instance, if set, the instances expose their
+	// metrics on consecutive ports starting at this port
+	MetricsBasePort int `yaml:"metrics-base-port"`
+	// Defaults are the parameters of all the instances, in the format of the simulator's
+	// configuration file
+	Defaults yaml.Node `yaml:"defaults"`
+	// Instances are the instances of the fleet
+	Instances []fleetInstance `yaml:"instances"`
+}
+
+// fleetInstance defines one or more identical instances of a fleet
+type fleetInstance struct {
+	// Replicas is the number of instances with this configuration, by default one
+	Replicas int `yaml:"replicas"`
+	// Config contains the parameters of the instance that override the fleet's defaults, in the
+	// format of the simulator's configuration file
+	Config yaml.Node `yaml:"config"`
+}
+
+// loadFleetConfig reads the fleet configuration from the given file
+func loadFleetConfig(configFile string) (*fleetConfig, error) {
+	configBytes, err := os.ReadFile(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fleet configuration file: %s", err)
+	}
+
+	config := fleetConfig{BasePort: vLLMDefaultPort}
+	if err := yaml.Unmarshal(configBytes, &config); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal fleet configuration: %s", err)
+	}
+	return &config, nil
+}
+
+// instanceConfigs returns the validated configurations of the instances of the fleet. The ports
+// of the instances are assigned consecutively, an instance with data parallel ranks gets a port
+// per rank.
+func (c *fleetConfig) instanceConfigs() ([]*Configuration, error) {
+	if c.BasePort <= 0 {
+		return nil, fmt.Errorf("invalid base port '%d'", c.BasePort)
+	}
+	if c.MetricsBasePort < 0 {
+		return nil, fmt.Errorf("invalid metrics base port '%d'", c.MetricsBasePort)
+	}
+	if len(c.Instances) == 0 {
+		return nil, errors.New("the fleet has no instances")
+	}
+	if err := checkNoPorts(&c.Defaults); err != nil {
+		return nil, fmt.Errorf("invalid fleet defaults: %s", err)
+	}
+
+	configs := make([]*Configuration, 0, len(c.Instances))
+	portOffset := 0
+	for i, instance := range c.Instances {
+		if instance.Replicas < 0 {
+			return nil, fmt.Errorf("invalid number of replicas '%d' of fleet instance %d", instance.Replicas, i)
+		}
+		if err := checkNoPorts(&instance.Config); err != nil {
+			return nil, fmt.Errorf("invalid fleet instance %d: %s", i, err)
+		}
+		replicas := max(instance.Replicas, 1)
+		for range replicas {
+			config, err := c.instanceConfig(&instance, portOffset)
+			if err != nil {
+				return nil, fmt.Errorf("invalid fleet instance %d: %s", i, err)
+			}
+			configs = append(configs, config)
+			portOffset += config.DataParallelSize
+		}
+	}
+
+	if c.MetricsBasePort > 0 && c.MetricsBasePort < c.BasePort+portOffset && c.BasePort < c.MetricsBasePort+portOffset {
+		return nil, fmt.Errorf("the metrics ports %d-%d overlap the ports %d-%d", c.MetricsBasePort,
+			c.MetricsBasePort+portOffset-1, c.BasePort, c.BasePort+portOffset-1)
+	}
+	return configs, nil
+}
+
+// instanceConfig returns the validated configuration of one instance, the fleet's defaults
+// overridden by the instance's parameters, with the ports at the given offset from the base ports
+func (c *fleetConfig) instanceConfig(instance *fleetInstance, portOffset int) (*Configuration, error) {
+	config := NewConfig()
+	for _, node := range []*yaml.Node{&c.Defaults, &instance.Config} {
+		if node.IsZero() {
+			continue
+		}
+		if err := node.Decode(config); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal configuration: %s", err)
+		}
+	}
+	if err := config.unmarshalLoras(); err != nil {
+		return nil, err
+	}
+
+	config.Port = c.BasePort + portOffset
+	if c.MetricsBasePort > 0 {
+		config.MetricsPort = c.MetricsBasePort + portOffset
+	}
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// checkNoPorts returns an error if the given configuration defines ports, the ports of the
+// instances are assigned by the fleet
+func checkNoPorts(node *yaml.Node) error {
+	if node.IsZero() {
+		return nil
+	}
+	var params map[string]any
+	if err := node.Decode(&params); err != nil {
+		return fmt.Errorf("failed to unmarshal configuration: %s", err)
+	}
+	for _, param := range []string{"port", "metrics-port"} {
+		if _, found := params[param]; found {
+			return fmt.Errorf("'%s' cannot be set, the ports are defined by base-port and metrics-base-port", param)
+		}
+	}
+	return nil
+}
+
+// Fleet runs several simulator instances in a single process, each instance has its own
+// configuration, queues and metrics
+type Fleet struct {
+	logger logr.Logger
+	// simulators are the instances of the fleet
+	simulators []*VllmSimulator
+}
+
+// NewFleet creates a new fleet of simulators
+func NewFleet(logger logr.Logger) *Fleet {
+	return &Fleet{logger: logger}
+}
+
+// Start loads the fleet configuration file defined in the command line, and starts all the
+// instances. Blocks until one of the instances stops.
+func (f *Fleet) Start(ctx context.Context) error {
+	configFile, err := parseFleetCommandParams()
+	if err != nil {
+		return err
+	}
+	fleetConfig, err := loadFleetConfig(configFile)
+	if err != nil {
+		return err
+	}
+	if err := f.init(fleetConfig); err != nil {
+		return err
+	}
+
+	// create the listeners of all the instances before starting them, so that the start fails
+	// if one of the ports is not available
+	listeners := make([][]net.Listener, 0, len(f.simulators))
+	for _, s := range f.simulators {
+		instanceListeners, err := s.newRankListeners()
+		if err != nil {
+			for _, created := range listeners {
+				closeListeners(created)
+			}
+			return err
+		}
+		listeners = append(listeners, instanceListeners)
+	}
+	return f.startInstances(ctx, listeners)
+}
+
+// parseFleetCommandParams parses the command line parameters of the fleet mode, and returns the
+// path of the fleet configuration file
+func parseFleetCommandParams() (string, error) {
+	var configFile string
+	f := pflag.NewFlagSet("llm-d-inference-sim fleet flags", pflag.ContinueOnError)
+	f.StringVar(&configFile, "config", "", "The path to a yaml fleet configuration file")
+
+	flagSet := flag.NewFlagSet("simFlagSet", flag.ExitOnError)
+	klog.InitFlags(flagSet)
+	f.AddGoFlagSet(flagSet)
+
+	if err := f.Parse(os.Args[1:]); err != nil {
+		if err == pflag.ErrHelp {
+			// --help - exit without printing an error message
+			os.Exit(0)
+		}
+		return "", err
+	}
+	if configFile == "" {
+		return "", errors.New("the fleet configuration file is not set, use --config")
+	}
+	return configFile, nil
+}
+
+// init creates the instances of the fleet according to the given configuration
+func (f *Fleet) init(fleetConfig *fleetConfig) error {
+	configs, err := fleetConfig.instanceConfigs()
+	if err != nil {
+		return err
+	}
+
+	f.simulators = make([]*VllmSimulator, 0, len(configs))
+	for i, config := range configs {
+		s, err := New(f.logger.WithValues("instance", i))
+		if err != nil {
+			return err
+		}
+		if err := s.initWithConfig(config); err != nil {
+			return fmt.Errorf("failed to initialize fleet instance %d: %s", i, err)
+		}
+		if err := s.initDataParallelRanks(); err != nil {
+			return fmt.Errorf("failed to initialize fleet instance %d: %s", i, err)
+		}
+		f.simulators = append(f.simulators, s)
+	}
+	return nil
+}
+
+// startInstances starts all the instances of the fleet, each instance serves the listeners in
+// its index. When the given context is done or one of the instances fails, all the instances are
+// drained and stopped. Blocks until all the instances stop, and returns the first error.
+func (f *Fleet) startInstances(ctx context.Context, listeners [][]net.Listener) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errChan := make(chan error, len(f.simulators))
+	for i, s := range f.simulators {
+		f.logger.Info("Starting fleet instance", "instance", i, "model", s.config.Model, "port", s.config.Port)
+		go func(instance int, s *VllmSimulator) {
+			if err := s.startRanks(ctx, listeners[instance]); err != nil {
+				errChan <- fmt.Errorf("fleet instance %d failed: %w", instance, err)
+				return
+			}
+			errChan <- nil
+		}(i, s)
+	}
+
+	var firstErr error
+	for range f.simulators {
+		if err := <-errChan; err != nil && firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/valyala/fasthttp/fasthttputil"
+	"k8s.io/klog/v2"
+)
+
+// loadFleetConfigFromString writes the given fleet configuration to a file and loads it
+func loadFleetConfigFromString(content string) (*fleetConfig, error) {
+	configFile := filepath.Join(GinkgoT().TempDir(), "fleet.yaml")
+	Expect(os.WriteFile(configFile, []byte(content), 0o644)).To(Succeed())
+	return loadFleetConfig(configFile)
+}
+
+var _ = Describe("Fleet", func() {
+	It("Should create the configurations of the instances", func() {
+		fleetConfig, err := loadFleetConfigFromString(`
+base-port: 8100
+metrics-base-port: 9100
+defaults:
+  mode: echo
+  time-to-first-token: 100
+instances:
+- config:
+    model: model1
+    lora-modules:
+    - '{"name":"lora1","error_rate":0.5}'
+- replicas: 2
+  config:
+    model: model2
+    time-to-first-token: 200
+    error-rate: 0.1
+    data-parallel-size: 2
+`)
+		Expect(err).NotTo(HaveOccurred())
+		configs, err := fleetConfig.instanceConfigs()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(configs).To(HaveLen(3))
+
+		Expect(configs[0].Model).To(Equal("model1"))
+		Expect(configs[0].Mode).To(Equal(modeEcho))
+		Expect(configs[0].TimeToFirstToken).To(Equal(100))
+		Expect(configs[0].ErrorRate).To(BeZero())
+		Expect(configs[0].LoraModules).To(HaveLen(1))
+		Expect(*configs[0].LoraModules[0].ErrorRate).To(Equal(0.5))
+		Expect(configs[0].Port).To(Equal(8100))
+		Expect(configs[0].MetricsPort).To(Equal(9100))
+
+		for i, port := range []int{8101, 8103} {
+			config := configs[i+1]
+			Expect(config.Model).To(Equal("model2"))
+			Expect(config.Mode).To(Equal(modeEcho))
+			Expect(config.TimeToFirstToken).To(Equal(200))
+			Expect(config.ErrorRate).To(Equal(0.1))
+			Expect(config.LoraModules).To(BeEmpty())
+			// the ranks of the previous instance use the ports in between
+			Expect(config.Port).To(Equal(port))
+			Expect(config.MetricsPort).To(Equal(port + 1000))
+		}
+	})
+
+	It("Should load the example fleet configuration", func() {
+		fleetConfig, err := loadFleetConfig("../../manifests/fleet.yaml")
+		Expect(err).NotTo(HaveOccurred())
+		configs, err := fleetConfig.instanceConfigs()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(configs).To(HaveLen(3))
+	})
+
+	DescribeTable("invalid fleet configurations",
+		func(content string) {
+			fleetConfig, err := loadFleetConfigFromString(content)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = fleetConfig.instanceConfigs()
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("no instances", "base-port: 8100"),
+		Entry("invalid base port", "base-port: -1\ninstances:\n- config:\n    model: model1"),
+		Entry("port in the defaults", "defaults:\n  port: 8100\ninstances:\n- config:\n    model: model1"),
+		Entry("metrics port of an instance", "instances:\n- config:\n    model: model1\n    metrics-port: 9100"),
+		Entry("negative replicas", "instances:\n- replicas: -1\n  config:\n    model: model1"),
+		Entry("instance without a model", "instances:\n- config:\n    mode: echo"),
+		Entry("invalid instance parameter", "instances:\n- config:\n    model: model1\n    error-rate: 2"),
+		Entry("overlapping metrics ports", "base-port: 8100\nmetrics-base-port: 8101\n"+
+			"instances:\n- replicas: 2\n  config:\n    model: model1"),
+	)
+
+	It("Should run isolated instances", func() {
+		fleetConfig, err := loadFleetConfigFromString(`
+defaults:
+  mode: echo
+instances:
+- config:
+    model: model1
+- config:
+    model: model2
+    error-rate: 1
+`)
+		Expect(err).NotTo(HaveOccurred())
+		fleet := NewFleet(klog.Background())
+		Expect(fleet.init(fleetConfig)).To(Succeed())
+		Expect(fleet.simulators).To(HaveLen(2))
+		Expect(fleet.simulators[0].registry).NotTo(BeIdenticalTo(fleet.simulators[1].registry))
+		Expect(fleet.simulators[0].random).NotTo(BeIdenticalTo(fleet.simulators[1].random))
+
+		listeners := make([][]net.Listener, 0, len(fleet.simulators))
+		clients := make([]*http.Client, 0, len(fleet.simulators))
+		for range fleet.simulators {
+			listener := fasthttputil.NewInmemoryListener()
+			listeners = append(listeners, []net.Listener{listener})
+			clients = append(clients, &http.Client{
+				Transport: &http.Transport{
+					DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
+						return listener.Dial()
+					},
+				},
+			})
+		}
+		ctx, stop := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			done <- fleet.startInstances(ctx, listeners)
+		}()
+
+		body := func(model string) string {
+			return `{"model": "` + model + `", "prompt": "Hello world", "max_tokens": 2}`
+		}
+		status, respBody := sendToRank(clients[0], http.MethodPost, "/v1/completions", "", body("model1"))
+		Expect(status).To(Equal(http.StatusOK), respBody)
+		// each instance serves only its own model
+		status, _ = sendToRank(clients[0], http.MethodPost, "/v1/completions", "", body("model2"))
+		Expect(status).To(Equal(http.StatusNotFound))
+		// with the instance's fault profile
+		status, _ = sendToRank(clients[1], http.MethodPost, "/v1/completions", "", body("model2"))
+		Expect(status).To(Equal(http.StatusInternalServerError))
+
+		_, metrics := sendToRank(clients[0], http.MethodGet, "/metrics", "", "")
+		Expect(metrics).To(ContainSubstring(`model_name="model1"`))
+		Expect(metrics).NotTo(ContainSubstring(`model_name="model2"`))
+		_, metrics = sendToRank(clients[1], http.MethodGet, "/metrics", "", "")
+		Expect(metrics).NotTo(ContainSubstring(`model_name="model1"`))
+
+		// all the instances stop
+		stop()
+		Eventually(done).Should(Receive(BeNil()))
+	})
+})
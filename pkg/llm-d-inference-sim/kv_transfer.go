@@ -0,0 +1,234 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// KV cache transfer between prefill and decode instances in P/D disaggregation
+package llmdinferencesim
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	// defaultBlockSize is the default number of tokens in a KV cache block, same as in vLLM
+	defaultBlockSize = 16
+	// defaultAdvertisedHost is the default host other instances use to reach the simulator
+	defaultAdvertisedHost = "localhost"
+	// defaultKVTransferTimeout is the default time in seconds KV cache blocks of prefill requests
+	// are kept for the decode instance, same as in vLLM's NIXL connector
+	defaultKVTransferTimeout = 480
+	// kvTransferPath is the path of the side channel endpoint, used by decode instances to pull
+	// KV cache blocks from prefill instances
+	kvTransferPath = "/kv_transfer/pull"
+	// kvTransferRequestTimeout is the timeout of a request to the side channel of a prefill instance
+	kvTransferRequestTimeout = 10 * time.Second
+)
+
+// kvPullRequest is a request of a decode instance to pull KV cache blocks from a prefill instance
+type kvPullRequest struct {
+	// EngineID is the engine ID of the prefill instance
+	EngineID string `json:"engine_id"`
+	// BlockIds are the IDs of the KV cache blocks to pull
+	BlockIds []int `json:"block_ids"`
+}
+
+// kvTransferParams contains the parameters of a KV cache transfer between a prefill instance and
+// a decode instance, in vLLM's kv_transfer_params format
+type kvTransferParams struct {
+	// DoRemoteDecode is true when the request's decode will be done on a remote instance
+	DoRemoteDecode bool `json:"do_remote_decode"`
+	// DoRemotePrefill is true when the request's prefill was done on a remote instance
+	DoRemotePrefill bool `json:"do_remote_prefill"`
+	// RemoteBlockIds are the IDs of the KV cache blocks of the request on the prefill instance
+	RemoteBlockIds []int `json:"remote_block_ids"`
+	// RemoteEngineId is the engine ID of the prefill instance
+	RemoteEngineId string `json:"remote_engine_id"`
+	// RemoteHost is the host of the prefill instance
+	RemoteHost string `json:"remote_host"`
+	// RemotePort is the port of the prefill instance
+	RemotePort int `json:"remote_port"`
+	// TPSize is the tensor parallel size of the prefill instance
+	TPSize int `json:"tp_size,omitempty"`
+	// legacy is true if the parameters are sent in the flat remote_* fields of the response,
+	// and not in kv_transfer_params
+	legacy bool
+}
+
+// createKVTransferParams allocates the KV cache blocks of the prompt of a prefill request, and
+// returns the parameters the decode instance needs to transfer them
+func (s *VllmSimulator) createKVTransferParams(req completionRequest) *kvTransferParams {
+	return &kvTransferParams{
+		DoRemotePrefill: true,
+		RemoteBlockIds:  s.allocateKVBlocks(req.getNumberOfPromptTokens()),
+		RemoteEngineId:  s.engineID,
+		RemoteHost:      s.config.AdvertisedHost,
+		RemotePort:      s.getAdvertisedPort(),
+		TPSize:          1,
+		legacy:          req.getKVTransferParams().legacy,
+	}
+}
+
+// allocateKVBlocks allocates the KV cache blocks for the given number of tokens, and returns
+// their IDs. The blocks are kept until a decode instance pulls them, or until they expire.
+func (s *VllmSimulator) allocateKVBlocks(numOfTokens int) []int {
+	numOfBlocks := (numOfTokens + s.config.BlockSize - 1) / s.config.BlockSize
+
+	s.kvBlocksLock.Lock()
+	defer s.kvBlocksLock.Unlock()
+
+	now := time.Now()
+	for id, allocated := range s.kvBlocks {
+		if s.isKVBlockExpired(allocated, now) {
+			delete(s.kvBlocks, id)
+		}
+	}
+
+	blockIds := make([]int, 0, numOfBlocks)
+	for range numOfBlocks {
+		blockIds = append(blockIds, s.nextKVBlockID)
+		s.kvBlocks[s.nextKVBlockID] = now
+		s.nextKVBlockID++
+	}
+	return blockIds
+}
+
+// isKVBlockExpired returns true if a KV cache block allocated at the given time has expired
+func (s *VllmSimulator) isKVBlockExpired(allocated time.Time, now time.Time) bool {
+	return now.Sub(allocated) > time.Duration(s.config.KVTransferTimeout)*time.Second
+}
+
+// freeKVBlocks frees the given KV cache blocks after they were pulled by a decode instance,
+// returns an error message, an error type and a status code if some of the blocks don't exist
+// or have expired
+func (s *VllmSimulator) freeKVBlocks(blockIds []int) (string, string, int) {
+	s.kvBlocksLock.Lock()
+	defer s.kvBlocksLock.Unlock()
+
+	now := time.Now()
+	for _, id := range blockIds {
+		allocated, found := s.kvBlocks[id]
+		if !found {
+			return fmt.Sprintf("KV cache block %d not found", id), "NotFoundError", fasthttp.StatusNotFound
+		}
+		if s.isKVBlockExpired(allocated, now) {
+			return fmt.Sprintf("KV cache block %d has expired", id), "NotFoundError", fasthttp.StatusNotFound
+		}
+	}
+	for _, id := range blockIds {
+		delete(s.kvBlocks, id)
+	}
+	return "", "", fasthttp.StatusOK
+}
+
+// HandleKVTransfer handles a request of a decode instance to pull KV cache blocks, the side channel
+// of the KV cache transfer
+func (s *VllmSimulator) HandleKVTransfer(ctx *fasthttp.RequestCtx) {
+	var req kvPullRequest
+	if err := json.Unmarshal(ctx.Request.Body(), &req); err != nil {
+		s.sendCompletionError(ctx, "Failed to read and parse request body, "+err.Error(), "BadRequestError",
+			fasthttp.StatusBadRequest)
+		return
+	}
+	if req.EngineID != s.engineID {
+		s.sendCompletionError(ctx, fmt.Sprintf("Engine '%s' not found", req.EngineID), "NotFoundError",
+			fasthttp.StatusNotFound)
+		return
+	}
+	if errMsg, errType, errCode := s.freeKVBlocks(req.BlockIds); errMsg != "" {
+		s.sendCompletionError(ctx, errMsg, errType, errCode)
+		return
+	}
+	ctx.SetStatusCode(fasthttp.StatusOK)
+}
+
+// pullRemoteKVBlocks pulls the KV cache blocks of a decode request from the prefill instance
+// defined in the given parameters, and waits for the transfer time of the blocks. Returns an
+// error message, an error type and a status code if the transfer failed. Requests without the
+// prefill instance's address are not validated.
+func (s *VllmSimulator) pullRemoteKVBlocks(params *kvTransferParams) (string, string, int) {
+	if params.RemoteHost == "" || params.RemotePort == 0 {
+		return "", "", fasthttp.StatusOK
+	}
+
+	body, err := json.Marshal(kvPullRequest{EngineID: params.RemoteEngineId, BlockIds: params.RemoteBlockIds})
+	if err != nil {
+		return err.Error(), "InternalServerError", fasthttp.StatusInternalServerError
+	}
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.SetRequestURI(fmt.Sprintf("http://%s%s", net.JoinHostPort(params.RemoteHost, strconv.Itoa(params.RemotePort)),
+		kvTransferPath))
+	req.Header.SetMethod(fasthttp.MethodPost)
+	req.Header.SetContentType("application/json")
+	req.SetBody(body)
+
+	if err := fasthttp.DoTimeout(req, resp, kvTransferRequestTimeout); err != nil {
+		return fmt.Sprintf("Failed to transfer the KV cache from %s:%d: %s", params.RemoteHost, params.RemotePort, err),
+			"InternalServerError", fasthttp.StatusInternalServerError
+	}
+	if resp.StatusCode() != fasthttp.StatusOK {
+		var compErr completionError
+		if err := json.Unmarshal(resp.Body(), &compErr); err != nil {
+			compErr.Message = string(resp.Body())
+		}
+		return fmt.Sprintf("Failed to transfer the KV cache from engine '%s': %s", params.RemoteEngineId, compErr.Message),
+			"BadRequestError", fasthttp.StatusBadRequest
+	}
+
+	if s.config.KVCacheTransferBandwidth > 0 {
+		transferTime := time.Duration(len(params.RemoteBlockIds)) * time.Second /
+			time.Duration(s.config.KVCacheTransferBandwidth)
+		time.Sleep(transferTime)
+	}
+	return "", "", fasthttp.StatusOK
+}
+
+// getAdvertisedPort returns the port the simulator is reachable at by other instances
+func (s *VllmSimulator) getAdvertisedPort() int {
+	if s.config.AdvertisedPort > 0 {
+		return s.config.AdvertisedPort
+	}
+	return s.config.Port
+}
+
+// setKVTransferParams sets the given KV transfer parameters in the given response, either in
+// kv_transfer_params or in the legacy flat fields, according to the format of the request
+func setKVTransferParams(resp *baseCompletionResponse, params *kvTransferParams) {
+	if params == nil {
+		return
+	}
+	if !params.legacy {
+		resp.KVTransferParams = params
+		return
+	}
+	resp.DoRemoteDecode = true
+	resp.DoRemotePrefill = false
+	resp.RemoteBlockIds = make([]string, 0, len(params.RemoteBlockIds))
+	for _, id := range params.RemoteBlockIds {
+		resp.RemoteBlockIds = append(resp.RemoteBlockIds, strconv.Itoa(id))
+	}
+	resp.RemoteEngineId = params.RemoteEngineId
+	resp.RemoteHost = params.RemoteHost
+	resp.RemotePort = params.RemotePort
+}
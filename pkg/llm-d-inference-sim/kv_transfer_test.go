@@ -0,0 +1,314 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// prefillResponse contains the P/D fields of a completion response
+type prefillResponse struct {
+	baseCompletionResponse
+	Choices []textRespChoice `json:"choices"`
+}
+
+// postCompletion sends the given text completion request to the given server, and returns the
+// response's status code and body
+func postCompletion(client *http.Client, serverURL string, body string) (int, []byte) {
+	resp, err := client.Post(serverURL+"/v1/completions", "application/json", strings.NewReader(body))
+	Expect(err).NotTo(HaveOccurred())
+	defer func() {
+		Expect(resp.Body.Close()).To(Succeed())
+	}()
+	respBody, err := io.ReadAll(resp.Body)
+	Expect(err).NotTo(HaveOccurred())
+	return resp.StatusCode, respBody
+}
+
+// sendPrefillRequest sends the given text completion request and returns its response
+func sendPrefillRequest(client *http.Client, body string) prefillResponse {
+	return sendPrefillRequestTo(client, "http://localhost", body)
+}
+
+// sendPrefillRequestTo sends the given text completion request to the given server and returns its response
+func sendPrefillRequestTo(client *http.Client, serverURL string, body string) prefillResponse {
+	status, respBody := postCompletion(client, serverURL, body)
+	Expect(status).To(Equal(http.StatusOK))
+	var prefillResp prefillResponse
+	Expect(json.Unmarshal(respBody, &prefillResp)).To(Succeed())
+	return prefillResp
+}
+
+// startPrefillServer starts a simulator that listens on a local TCP port with the given extra
+// arguments, and returns it and its URL
+func startPrefillServer(ctx context.Context, extraArgs ...string) (*VllmSimulator, string) {
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	Expect(err).NotTo(HaveOccurred())
+	port := listener.Addr().(*net.TCPAddr).Port
+	args := append([]string{"cmd", "--model", model, "--mode", modeEcho, "--advertised-host", "127.0.0.1",
+		"--advertised-port", strconv.Itoa(port)}, extraArgs...)
+	s, err := startSimulatorWithListener(ctx, modeEcho, args, listener)
+	Expect(err).NotTo(HaveOccurred())
+	return s, fmt.Sprintf("http://127.0.0.1:%d", port)
+}
+
+// readStreamedChunks sends the given streaming request to the given path, and returns the data of
+// the chunks of the response, without the [DONE] chunk
+func readStreamedChunks(client *http.Client, path string, body string) []string {
+	resp, err := client.Post("http://localhost/v1/"+path, "application/json", strings.NewReader(body))
+	Expect(err).NotTo(HaveOccurred())
+	defer func() {
+		Expect(resp.Body.Close()).To(Succeed())
+	}()
+	Expect(resp.StatusCode).To(Equal(http.StatusOK))
+	respBody, err := io.ReadAll(resp.Body)
+	Expect(err).NotTo(HaveOccurred())
+
+	chunks := make([]string, 0)
+	for _, line := range strings.Split(string(respBody), "\n") {
+		data, found := strings.CutPrefix(line, "data: ")
+		if found && data != "[DONE]" {
+			chunks = append(chunks, data)
+		}
+	}
+	Expect(string(respBody)).To(HaveSuffix("data: [DONE]\n\n"))
+	return chunks
+}
+
+// createDecodeRequest returns a decode request with the given KV transfer parameters
+func createDecodeRequest(params *kvTransferParams) string {
+	params.DoRemotePrefill = true
+	params.DoRemoteDecode = false
+	data, err := json.Marshal(params)
+	Expect(err).NotTo(HaveOccurred())
+	return `{"model": "` + model + `", "prompt": "Hello", "max_tokens": 2, "kv_transfer_params": ` + string(data) + `}`
+}
+
+var _ = Describe("KV transfer", func() {
+	// a prompt of 20 tokens, which occupy two blocks of 16 tokens
+	prompt := `[1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20]`
+
+	It("Should return the KV transfer parameters in the legacy fields", func() {
+		ctx := context.TODO()
+		client, err := startServerWithArgs(ctx, modeEcho, []string{"cmd", "--model", model, "--mode", modeEcho,
+			"--advertised-host", "10.0.0.1", "--port", "8100"})
+		Expect(err).NotTo(HaveOccurred())
+
+		resp := sendPrefillRequest(client, `{"model": "`+model+`", "prompt": `+prompt+`, "max_tokens": 1, "do_remote_decode": true}`)
+		Expect(resp.KVTransferParams).To(BeNil())
+		Expect(resp.DoRemoteDecode).To(BeTrue())
+		Expect(resp.RemoteBlockIds).To(HaveLen(2))
+		Expect(resp.RemoteEngineId).NotTo(BeEmpty())
+		Expect(resp.RemoteHost).To(Equal("10.0.0.1"))
+		Expect(resp.RemotePort).To(Equal(8100))
+		Expect(*resp.Choices[0].FinishReason).To(Equal(remoteDecodeFinishReason))
+
+		// the blocks of the next request are different, the engine is the same
+		nextResp := sendPrefillRequest(client, `{"model": "`+model+`", "prompt": `+prompt+`, "max_tokens": 1, "do_remote_decode": true}`)
+		Expect(nextResp.RemoteBlockIds).To(HaveLen(2))
+		Expect(nextResp.RemoteBlockIds).NotTo(ContainElements(resp.RemoteBlockIds))
+		Expect(nextResp.RemoteEngineId).To(Equal(resp.RemoteEngineId))
+	})
+
+	It("Should return kv_transfer_params", func() {
+		ctx := context.TODO()
+		client, err := startServerWithArgs(ctx, modeEcho, []string{"cmd", "--model", model, "--mode", modeEcho,
+			"--block-size", "8", "--advertised-port", "9000"})
+		Expect(err).NotTo(HaveOccurred())
+
+		resp := sendPrefillRequest(client, `{"model": "`+model+`", "prompt": `+prompt+`, "max_tokens": 1,
+			"kv_transfer_params": {"do_remote_decode": true, "do_remote_prefill": false}}`)
+		Expect(resp.RemoteBlockIds).To(BeEmpty())
+		Expect(resp.DoRemoteDecode).To(BeFalse())
+		params := resp.KVTransferParams
+		Expect(params).NotTo(BeNil())
+		Expect(params.DoRemotePrefill).To(BeTrue())
+		Expect(params.DoRemoteDecode).To(BeFalse())
+		Expect(params.RemoteBlockIds).To(Equal([]int{0, 1, 2}))
+		Expect(params.RemoteEngineId).NotTo(BeEmpty())
+		Expect(params.RemoteHost).To(Equal(defaultAdvertisedHost))
+		Expect(params.RemotePort).To(Equal(9000))
+		Expect(params.TPSize).To(Equal(1))
+		Expect(*resp.Choices[0].FinishReason).To(Equal(remoteDecodeFinishReason))
+	})
+
+	It("Should not return KV transfer parameters for other requests", func() {
+		ctx := context.TODO()
+		client, err := startServerWithArgs(ctx, modeEcho, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		resp := sendPrefillRequest(client, `{"model": "`+model+`", "prompt": `+prompt+`, "max_tokens": 1}`)
+		Expect(resp.KVTransferParams).To(BeNil())
+		Expect(resp.RemoteBlockIds).To(BeEmpty())
+	})
+
+	It("Should stream a chat completion prefill request", func() {
+		ctx := context.TODO()
+		client, err := startServerWithArgs(ctx, modeEcho, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		chunks := readStreamedChunks(client, "chat/completions", `{"model": "`+model+`", "stream": true, "max_tokens": 1,
+			"messages": [{"role": "user", "content": "Hello world"}], "kv_transfer_params": {"do_remote_decode": true}}`)
+		// the role chunk, the prefill token chunk, and the final chunk
+		Expect(chunks).To(HaveLen(3))
+		var tokenChunk, lastChunk chatCompletionRespChunk
+		Expect(json.Unmarshal([]byte(chunks[1]), &tokenChunk)).To(Succeed())
+		Expect(tokenChunk.Choices[0].Delta.Content.Raw).NotTo(BeEmpty())
+		Expect(tokenChunk.Choices[0].FinishReason).To(BeNil())
+		Expect(tokenChunk.KVTransferParams).To(BeNil())
+
+		Expect(json.Unmarshal([]byte(chunks[2]), &lastChunk)).To(Succeed())
+		Expect(*lastChunk.Choices[0].FinishReason).To(Equal(remoteDecodeFinishReason))
+		Expect(lastChunk.KVTransferParams).NotTo(BeNil())
+		Expect(lastChunk.KVTransferParams.DoRemotePrefill).To(BeTrue())
+		Expect(lastChunk.KVTransferParams.RemoteBlockIds).To(HaveLen(1))
+		Expect(lastChunk.KVTransferParams.RemoteEngineId).NotTo(BeEmpty())
+	})
+
+	It("Should stream a text completion prefill request in the legacy format", func() {
+		ctx := context.TODO()
+		client, err := startServerWithArgs(ctx, modeEcho, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		chunks := readStreamedChunks(client, "completions", `{"model": "`+model+`", "stream": true, "max_tokens": 1,
+			"prompt": `+prompt+`, "do_remote_decode": true}`)
+		// the prefill token chunk and the final chunk
+		Expect(chunks).To(HaveLen(2))
+		var lastChunk prefillResponse
+		Expect(json.Unmarshal([]byte(chunks[1]), &lastChunk)).To(Succeed())
+		Expect(*lastChunk.Choices[0].FinishReason).To(Equal(remoteDecodeFinishReason))
+		Expect(lastChunk.KVTransferParams).To(BeNil())
+		Expect(lastChunk.DoRemoteDecode).To(BeTrue())
+		Expect(lastChunk.RemoteBlockIds).To(HaveLen(2))
+	})
+
+	Context("side channel", func() {
+		var prefillSim *VllmSimulator
+		var prefillURL string
+		var decodeClient *http.Client
+
+		BeforeEach(func() {
+			ctx := context.TODO()
+			prefillSim, prefillURL = startPrefillServer(ctx)
+			var err error
+			decodeClient, err = startServerWithArgs(ctx, modeEcho, nil)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		// prefill sends a prefill request to the prefill instance and returns its KV transfer parameters
+		prefill := func() *kvTransferParams {
+			resp := sendPrefillRequestTo(http.DefaultClient, prefillURL, `{"model": "`+model+`", "prompt": `+prompt+`,
+				"max_tokens": 1, "kv_transfer_params": {"do_remote_decode": true}}`)
+			Expect(resp.KVTransferParams).NotTo(BeNil())
+			return resp.KVTransferParams
+		}
+
+		It("Should pull the KV cache blocks from the prefill instance", func() {
+			params := prefill()
+			Expect(params.RemoteHost).To(Equal("127.0.0.1"))
+			Expect(prefillSim.kvBlocks).To(HaveLen(2))
+
+			status, body := postCompletion(decodeClient, "http://localhost", createDecodeRequest(params))
+			Expect(status).To(Equal(http.StatusOK), string(body))
+			// the prefill instance frees the blocks after the transfer
+			Expect(prefillSim.kvBlocks).To(BeEmpty())
+
+			// the blocks cannot be pulled twice
+			status, body = postCompletion(decodeClient, "http://localhost", createDecodeRequest(params))
+			Expect(status).To(Equal(http.StatusBadRequest))
+			Expect(string(body)).To(ContainSubstring("KV cache block 0 not found"))
+		})
+
+		It("Should pull the KV cache blocks of a request in the legacy format", func() {
+			resp := sendPrefillRequestTo(http.DefaultClient, prefillURL, `{"model": "`+model+`", "prompt": `+prompt+`,
+				"max_tokens": 1, "do_remote_decode": true}`)
+			data, err := json.Marshal(resp.RemoteBlockIds)
+			Expect(err).NotTo(HaveOccurred())
+			decodeReq := fmt.Sprintf(`{"model": "%s", "prompt": "Hello", "max_tokens": 2, "do_remote_prefill": true,
+				"remote_block_ids": %s, "remote_engine_id": "%s", "remote_host": "%s", "remote_port": %d}`,
+				model, data, resp.RemoteEngineId, resp.RemoteHost, resp.RemotePort)
+			status, body := postCompletion(decodeClient, "http://localhost", decodeReq)
+			Expect(status).To(Equal(http.StatusOK), string(body))
+			Expect(prefillSim.kvBlocks).To(BeEmpty())
+		})
+
+		It("Should fail if the blocks have expired", func() {
+			params := prefill()
+			prefillSim.kvBlocksLock.Lock()
+			for id := range prefillSim.kvBlocks {
+				prefillSim.kvBlocks[id] = time.Now().Add(-time.Duration(defaultKVTransferTimeout+1) * time.Second)
+			}
+			prefillSim.kvBlocksLock.Unlock()
+
+			status, body := postCompletion(decodeClient, "http://localhost", createDecodeRequest(params))
+			Expect(status).To(Equal(http.StatusBadRequest))
+			Expect(string(body)).To(ContainSubstring("has expired"))
+		})
+
+		It("Should fail if the engine is unknown", func() {
+			params := prefill()
+			params.RemoteEngineId = "unknown"
+
+			status, body := postCompletion(decodeClient, "http://localhost", createDecodeRequest(params))
+			Expect(status).To(Equal(http.StatusBadRequest))
+			Expect(string(body)).To(ContainSubstring("Engine 'unknown' not found"))
+		})
+
+		It("Should fail if the prefill instance is unreachable", func() {
+			params := prefill()
+			params.RemotePort = 1
+
+			status, _ := postCompletion(decodeClient, "http://localhost", createDecodeRequest(params))
+			Expect(status).To(Equal(http.StatusInternalServerError))
+		})
+
+		It("Should reject invalid legacy block IDs", func() {
+			decodeReq := `{"model": "` + model + `", "prompt": "Hello", "do_remote_prefill": true,
+				"remote_block_ids": ["DUMMY_ID"], "remote_host": "127.0.0.1", "remote_port": 1234}`
+			status, body := postCompletion(decodeClient, "http://localhost", decodeReq)
+			Expect(status).To(Equal(http.StatusBadRequest))
+			Expect(string(body)).To(ContainSubstring("invalid remote block ID 'DUMMY_ID'"))
+		})
+	})
+
+	It("Should derive the transfer time from the number of blocks", func() {
+		ctx := context.TODO()
+		_, prefillURL := startPrefillServer(ctx)
+		decodeClient, err := startServerWithArgs(ctx, modeEcho, []string{"cmd", "--model", model, "--mode", modeEcho,
+			"--kv-cache-transfer-bandwidth", "10"})
+		Expect(err).NotTo(HaveOccurred())
+
+		resp := sendPrefillRequestTo(http.DefaultClient, prefillURL, `{"model": "`+model+`", "prompt": `+prompt+`,
+			"max_tokens": 1, "kv_transfer_params": {"do_remote_decode": true}}`)
+		start := time.Now()
+		status, body := postCompletion(decodeClient, "http://localhost", createDecodeRequest(resp.KVTransferParams))
+		Expect(status).To(Equal(http.StatusOK), string(body))
+		// two blocks in 10 blocks per second
+		Expect(time.Since(start)).To(BeNumerically(">=", 200*time.Millisecond))
+	})
+})
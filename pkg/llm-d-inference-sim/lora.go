@@ -19,13 +19,36 @@ package llmdinferencesim
 
 import (
 	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
 
+	vllmapi "github.com/llm-d/llm-d-inference-sim/pkg/vllm-api"
 	"github.com/valyala/fasthttp"
 )
 
+const (
+	// adapterConfigFile is the name of the configuration file of a LoRA adapter
+	adapterConfigFile = "adapter_config.json"
+	// defaultLoraRank is the adapter rank for which the LoRA latencies are defined
+	defaultLoraRank = 16
+)
+
+// adapterConfig is the part of a LoRA adapter's adapter_config.json file that is used by the simulator
+type adapterConfig struct {
+	// Rank is the rank of the adapter's matrices
+	Rank int `json:"r"`
+	// TargetModules is either a list of module names or a regular expression
+	TargetModules any `json:"target_modules"`
+	// BaseModel is the model the adapter was trained for
+	BaseModel string `json:"base_model_name_or_path"`
+}
+
 type loadLoraRequest struct {
-	LoraName string `json:"lora_name"`
-	LoraPath string `json:"lora_path"`
+	LoraName      string `json:"lora_name"`
+	LoraPath      string `json:"lora_path"`
+	BaseModelName string `json:"base_model_name"`
 }
 
 type unloadLoraRequest struct {
@@ -47,6 +70,16 @@ func (s *VllmSimulator) getLoras() []string {
 	return loras
 }
 
+// getLoraModule returns the stored information of the given LoRA
+func (s *VllmSimulator) getLoraModule(lora string) (loraModule, bool) {
+	value, ok := s.loraAdaptors.Load(lora)
+	if !ok {
+		return loraModule{}, false
+	}
+	module, ok := value.(loraModule)
+	return module, ok
+}
+
 func (s *VllmSimulator) loadLora(ctx *fasthttp.RequestCtx) {
 	var req loadLoraRequest
 	err := json.Unmarshal(ctx.Request.Body(), &req)
@@ -56,7 +89,62 @@ func (s *VllmSimulator) loadLora(ctx *fasthttp.RequestCtx) {
 		return
 	}
 
-	s.loraAdaptors.Store(req.LoraName, "")
+	if req.LoraName == "" || req.LoraPath == "" {
+		s.sendCompletionError(ctx, "Both 'lora_name' and 'lora_path' must be provided.",
+			"InvalidUserInput", fasthttp.StatusBadRequest)
+		return
+	}
+	errMsg, errType, errCode := s.addLoraToEngines(loraModule{Name: req.LoraName, Path: req.LoraPath, BaseModelName: req.BaseModelName})
+	if errMsg != "" {
+		s.sendCompletionError(ctx, errMsg, errType, errCode)
+		return
+	}
+	sendTextResponse(ctx, fmt.Sprintf("Success: LoRA adapter '%s' added successfully.", req.LoraName))
+}
+
+// addLoraToEngines adds the given LoRA adapter to the engines of all the data parallel ranks. If the
+// adapter cannot be added to one of the engines, it is removed from the others. In case of an error,
+// returns the error message, type and code.
+func (s *VllmSimulator) addLoraToEngines(module loraModule) (string, string, int) {
+	engines := s.getEngines()
+	for i, engine := range engines {
+		if errMsg, errType, errCode := engine.addLora(module); errMsg != "" {
+			for _, added := range engines[:i] {
+				added.removeLora(module.Name)
+			}
+			return errMsg, errType, errCode
+		}
+	}
+	return "", "", fasthttp.StatusOK
+}
+
+// addLora validates the given LoRA adapter, reads its metadata and adds it to the available adapters.
+// In case of an error, returns the error message, type and code.
+func (s *VllmSimulator) addLora(module loraModule) (string, string, int) {
+	if module.BaseModelName != "" && !s.config.isBaseModel(module.BaseModelName) {
+		return fmt.Sprintf("The base model '%s' of LoRA adapter '%s' is not served.", module.BaseModelName, module.Name),
+			"InvalidUserInput", fasthttp.StatusBadRequest
+	}
+	info, errMsg, errType, errCode := s.readLoraAdapter(module.Name, module.Path)
+	if errMsg != "" {
+		return errMsg, errType, errCode
+	}
+	module.info = info
+
+	s.loraUpdateLock.Lock()
+	defer s.loraUpdateLock.Unlock()
+
+	if _, ok := s.loraAdaptors.Load(module.Name); ok {
+		return fmt.Sprintf("The lora adapter '%s' has already been loaded.", module.Name),
+			"InvalidUserInput", fasthttp.StatusBadRequest
+	}
+	if len(s.getLoras()) >= s.config.MaxCPULoras {
+		return fmt.Sprintf("Cannot load LoRA adapter '%s', the maximum number of LoRA adapters (%d) is already loaded.",
+			module.Name, s.config.MaxCPULoras), "InvalidUserInput", fasthttp.StatusBadRequest
+	}
+
+	s.loraAdaptors.Store(module.Name, module)
+	return "", "", fasthttp.StatusOK
 }
 
 func (s *VllmSimulator) unloadLora(ctx *fasthttp.RequestCtx) {
@@ -68,5 +156,231 @@ func (s *VllmSimulator) unloadLora(ctx *fasthttp.RequestCtx) {
 		return
 	}
 
-	s.loraAdaptors.Delete(req.LoraName)
+	if req.LoraName == "" {
+		s.sendCompletionError(ctx, "'lora_name' needs to be provided to unload a LoRA adapter.",
+			"InvalidUserInput", fasthttp.StatusBadRequest)
+		return
+	}
+
+	// the adapter is removed from the engines of all the data parallel ranks, only if all of them loaded it
+	engines := s.getEngines()
+	for _, engine := range engines {
+		if !engine.isLora(req.LoraName) {
+			s.sendCompletionError(ctx, fmt.Sprintf("The lora adapter '%s' cannot be found.", req.LoraName),
+				"NotFoundError", fasthttp.StatusNotFound)
+			return
+		}
+	}
+	for _, engine := range engines {
+		engine.removeLora(req.LoraName)
+	}
+	sendTextResponse(ctx, fmt.Sprintf("Success: LoRA adapter '%s' removed successfully.", req.LoraName))
+}
+
+// removeLora removes the given LoRA adapter from the available adapters and from the LoRA slots
+func (s *VllmSimulator) removeLora(lora string) {
+	s.loraUpdateLock.Lock()
+	defer s.loraUpdateLock.Unlock()
+
+	s.loraAdaptors.Delete(lora)
+	s.removeLoraFromSlots(lora)
+}
+
+// readLoraAdapter reads the metadata of a LoRA adapter from the adapter_config.json file in the given
+// local path. If the file cannot be read, e.g., the path does not exist or is not a directory, returns
+// nil metadata, unless validate-lora-path is set, in which case an error is returned. Adapters that were
+// trained for another base model are rejected. In case of an error, returns the error message, type and code.
+func (s *VllmSimulator) readLoraAdapter(lora string, path string) (*vllmapi.LoraAdapterInfo, string, string, int) {
+	data, err := os.ReadFile(filepath.Join(path, adapterConfigFile))
+	if err != nil {
+		if s.config.ValidateLoraPath {
+			return nil, fmt.Sprintf("Loading lora %s failed: No adapter found for %s", lora, path),
+				"NotFoundError", fasthttp.StatusNotFound
+		}
+		return nil, "", "", fasthttp.StatusOK
+	}
+
+	var config adapterConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Sprintf("Loading lora %s failed: invalid %s: %s", lora, adapterConfigFile, err),
+			"InvalidUserInput", fasthttp.StatusBadRequest
+	}
+	if config.BaseModel != "" && !s.config.isBaseModel(config.BaseModel) {
+		return nil, fmt.Sprintf("Loading lora %s failed: the adapter was trained for base model '%s', but the served model is '%s'",
+			lora, config.BaseModel, s.config.Model), "InvalidUserInput", fasthttp.StatusBadRequest
+	}
+
+	info := vllmapi.LoraAdapterInfo{Rank: config.Rank, BaseModel: config.BaseModel}
+	switch modules := config.TargetModules.(type) {
+	case string:
+		info.TargetModules = []string{modules}
+	case []any:
+		for _, module := range modules {
+			if name, ok := module.(string); ok {
+				info.TargetModules = append(info.TargetModules, name)
+			}
+		}
+	}
+	return &info, "", "", fasthttp.StatusOK
+}
+
+// scaleByLoraRank scales the given latency by the rank of the given lora relative to defaultLoraRank,
+// latencies of loras with unknown rank are not scaled
+func (s *VllmSimulator) scaleByLoraRank(latency int, lora string) int {
+	module, ok := s.getLoraModule(lora)
+	if !ok || module.info == nil || module.info.Rank <= 0 {
+		return latency
+	}
+	return latency * module.info.Rank / defaultLoraRank
+}
+
+// sendTextResponse sends a successful response with the given plain text body
+func sendTextResponse(ctx *fasthttp.RequestCtx, text string) {
+	ctx.Response.Header.SetContentType("text/plain; charset=utf-8")
+	ctx.Response.Header.SetStatusCode(fasthttp.StatusOK)
+	ctx.Response.SetBodyString(text)
+}
+
+// addWaitingLora adds a waiting request of the given lora
+func (s *VllmSimulator) addWaitingLora(lora string) {
+	s.loraLock.Lock()
+	s.waitingLoras[lora]++
+	s.loraLock.Unlock()
+
+	s.reportLoras()
+}
+
+// acquireLoraSlot is called before a waiting request starts running, if the request is for a lora,
+// checks that the lora is in a GPU slot, or can be loaded to one: either a free slot, or a slot of
+// the least recently used lora without running requests, which is evicted.
+// Returns false if all the GPU slots are taken by loras of running requests, in this case the request
+// keeps waiting. Otherwise, the request becomes a running request of the lora, and the time to load
+// the lora to the GPU slot is stored in the request's context.
+func (s *VllmSimulator) acquireLoraSlot(reqCtx *completionReqCtx) bool {
+	lora := reqCtx.completionReq.getModel()
+	if !s.isLora(lora) {
+		s.loraLock.Lock()
+		// the lora could have been unloaded while the request was waiting
+		s.removeWaitingLora(lora)
+		s.loraLock.Unlock()
+		return true
+	}
+
+	s.loraLock.Lock()
+	latency, ok := s.loadLoraToGPU(lora)
+	if ok {
+		s.removeWaitingLora(lora)
+		s.runningLoras[lora]++
+	}
+	s.loraLock.Unlock()
+
+	if ok {
+		reqCtx.loraLoadLatency = latency
+		s.reportLoras()
+	}
+	return ok
+}
+
+// loadLoraToGPU places the given lora in a GPU slot, and marks it as the most recently used lora.
+// Returns the time to load the lora to the slot, and false if there is no slot for the lora.
+// Must be called under loraLock.
+func (s *VllmSimulator) loadLoraToGPU(lora string) (int, bool) {
+	latency := 0
+	if index := slices.Index(s.gpuLoras, lora); index >= 0 {
+		s.gpuLoras = slices.Delete(s.gpuLoras, index, index+1)
+	} else {
+		if len(s.gpuLoras) >= s.config.MaxLoras {
+			// evict the least recently used lora without running requests to CPU
+			index := slices.IndexFunc(s.gpuLoras, func(gpuLora string) bool {
+				return s.runningLoras[gpuLora] == 0
+			})
+			if index < 0 {
+				return 0, false
+			}
+			s.logger.Info("Evict LoRA from GPU", "lora", s.gpuLoras[index])
+			s.gpuLoras = slices.Delete(s.gpuLoras, index, index+1)
+		}
+		if slices.Contains(s.cpuLoras, lora) {
+			latency = s.scaleByLoraRank(s.config.LoraSwapLatency, lora)
+		} else {
+			latency = s.scaleByLoraRank(s.config.LoraLoadLatency, lora)
+		}
+		s.logger.Info("Load LoRA to GPU", "lora", lora, "latency", latency)
+	}
+	s.gpuLoras = append(s.gpuLoras, lora)
+
+	// every lora in a GPU slot is stored in CPU memory too
+	if index := slices.Index(s.cpuLoras, lora); index >= 0 {
+		s.cpuLoras = slices.Delete(s.cpuLoras, index, index+1)
+	}
+	s.cpuLoras = append(s.cpuLoras, lora)
+	if len(s.cpuLoras) > s.config.MaxCPULoras {
+		// drop the least recently used lora that is not in a GPU slot
+		index := slices.IndexFunc(s.cpuLoras, func(cpuLora string) bool {
+			return !slices.Contains(s.gpuLoras, cpuLora)
+		})
+		if index >= 0 {
+			s.logger.Info("Drop LoRA from CPU", "lora", s.cpuLoras[index])
+			s.cpuLoras = slices.Delete(s.cpuLoras, index, index+1)
+		}
+	}
+	return latency, true
+}
+
+// removeWaitingLora removes a waiting request of the given lora, must be called under loraLock
+func (s *VllmSimulator) removeWaitingLora(lora string) {
+	if s.waitingLoras[lora] > 1 {
+		s.waitingLoras[lora]--
+	} else {
+		delete(s.waitingLoras, lora)
+	}
+}
+
+// releaseLora removes a running request of the given lora, does nothing if the model is not
+// a running lora
+func (s *VllmSimulator) releaseLora(lora string) {
+	s.loraLock.Lock()
+	count, ok := s.runningLoras[lora]
+	if ok {
+		if count > 1 {
+			s.runningLoras[lora] = count - 1
+		} else {
+			// last lora instance stopped its execution - remove from the map
+			delete(s.runningLoras, lora)
+			s.logger.Info("Remove LoRA from set of running loras", "model", lora)
+		}
+	}
+	s.loraLock.Unlock()
+
+	if ok {
+		s.reportLoras()
+	}
+}
+
+// removeLoraFromSlots removes an unloaded lora from the GPU slots and from CPU memory
+func (s *VllmSimulator) removeLoraFromSlots(lora string) {
+	s.loraLock.Lock()
+	defer s.loraLock.Unlock()
+
+	s.gpuLoras = slices.DeleteFunc(s.gpuLoras, func(gpuLora string) bool { return gpuLora == lora })
+	s.cpuLoras = slices.DeleteFunc(s.cpuLoras, func(cpuLora string) bool { return cpuLora == lora })
+}
+
+// getRunningAndWaitingLoras returns the sorted names of the loras of running requests
+// and of waiting requests
+func (s *VllmSimulator) getRunningAndWaitingLoras() ([]string, []string) {
+	s.loraLock.Lock()
+	defer s.loraLock.Unlock()
+
+	running := make([]string, 0, len(s.runningLoras))
+	for lora := range s.runningLoras {
+		running = append(running, lora)
+	}
+	waiting := make([]string, 0, len(s.waitingLoras))
+	for lora := range s.waitingLoras {
+		waiting = append(waiting, lora)
+	}
+	slices.Sort(running)
+	slices.Sort(waiting)
+	return running, waiting
 }
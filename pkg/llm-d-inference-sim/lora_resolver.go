@@ -0,0 +1,98 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// LoRA resolvers, used to load unknown LoRA adapters at request time
+package llmdinferencesim
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/valyala/fasthttp"
+)
+
+// peftTypeLora is the PEFT type of LoRA adapters in adapter_config.json files
+const peftTypeLora = "LORA"
+
+// loraResolver finds LoRA adapters that are not loaded, by their names
+type loraResolver interface {
+	// resolveLora returns the path of the adapter with the given name, trained for the given
+	// base model, returns false if the adapter was not found
+	resolveLora(baseModel string, lora string) (string, bool)
+}
+
+// filesystemLoraResolver resolves LoRA adapters stored in a local cache directory, like vLLM's
+// filesystem resolver, the adapter named <lora> is stored in <cacheDir>/<lora>
+type filesystemLoraResolver struct {
+	cacheDir string
+}
+
+// newFilesystemLoraResolver creates a filesystem resolver for the given cache directory
+func newFilesystemLoraResolver(cacheDir string) (*filesystemLoraResolver, error) {
+	info, err := os.Stat(cacheDir)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LoRA resolver cache directory: %s", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("LoRA resolver cache directory %s is not a directory", cacheDir)
+	}
+	return &filesystemLoraResolver{cacheDir: cacheDir}, nil
+}
+
+func (r *filesystemLoraResolver) resolveLora(baseModel string, lora string) (string, bool) {
+	// the adapter's name must be a single path element
+	if lora == "" || lora != filepath.Base(lora) || lora == ".." {
+		return "", false
+	}
+	path := filepath.Join(r.cacheDir, lora)
+	data, err := os.ReadFile(filepath.Join(path, adapterConfigFile))
+	if err != nil {
+		return "", false
+	}
+
+	var config struct {
+		PeftType  string `json:"peft_type"`
+		BaseModel string `json:"base_model_name_or_path"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return "", false
+	}
+	if config.PeftType != peftTypeLora || config.BaseModel != baseModel {
+		return "", false
+	}
+	return path, true
+}
+
+// resolveLora tries to find an unknown model with the LoRA resolver, and to load it as a LoRA adapter.
+// If the model is not found, or cannot be loaded, returns the error message, type and code.
+func (s *VllmSimulator) resolveLora(model string) (string, string, int) {
+	if s.loraResolver == nil {
+		return fmt.Sprintf("The model `%s` does not exist.", model), "NotFoundError", fasthttp.StatusNotFound
+	}
+	path, ok := s.loraResolver.resolveLora(s.config.Model, model)
+	if !ok {
+		return fmt.Sprintf("The model `%s` does not exist.", model), "NotFoundError", fasthttp.StatusNotFound
+	}
+
+	s.logger.Info("Load resolved LoRA", "lora", model, "path", path)
+	if errMsg, _, _ := s.addLoraToEngines(loraModule{Name: model, Path: path}); errMsg != "" && !s.isLora(model) {
+		// the adapter could have been loaded by another request in the meantime
+		return fmt.Sprintf("Failed to load LoRA '%s': %s", model, errMsg), "BadRequestError", fasthttp.StatusBadRequest
+	}
+	return "", "", fasthttp.StatusOK
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+// createResolverCacheDir creates a LoRA resolver cache directory with the given adapters, key is
+// the adapter's name, value is the content of its adapter_config.json file
+func createResolverCacheDir(adapters map[string]string) string {
+	cacheDir := GinkgoT().TempDir()
+	for name, adapterConfig := range adapters {
+		err := os.Mkdir(filepath.Join(cacheDir, name), 0755)
+		Expect(err).NotTo(HaveOccurred())
+		err = os.WriteFile(filepath.Join(cacheDir, name, adapterConfigFile), []byte(adapterConfig), 0644)
+		Expect(err).NotTo(HaveOccurred())
+	}
+	return cacheDir
+}
+
+var _ = Describe("LoRA resolver", func() {
+	adapters := map[string]string{
+		"lora1":  fmt.Sprintf(`{"peft_type": "LORA", "r": 8, "base_model_name_or_path": "%s"}`, model),
+		"lora2":  fmt.Sprintf(`{"peft_type": "LORA", "r": 8, "base_model_name_or_path": "%s"}`, model),
+		"other":  `{"peft_type": "LORA", "r": 8, "base_model_name_or_path": "other-model"}`,
+		"prefix": fmt.Sprintf(`{"peft_type": "PREFIX_TUNING", "base_model_name_or_path": "%s"}`, model),
+	}
+
+	DescribeTable("filesystem resolver",
+		func(lora string, expectedFound bool) {
+			cacheDir := createResolverCacheDir(adapters)
+			resolver, err := newFilesystemLoraResolver(cacheDir)
+			Expect(err).NotTo(HaveOccurred())
+
+			path, found := resolver.resolveLora(model, lora)
+			Expect(found).To(Equal(expectedFound))
+			if expectedFound {
+				Expect(path).To(Equal(filepath.Join(cacheDir, lora)))
+			}
+		},
+		Entry("adapter", "lora1", true),
+		Entry("unknown adapter", "lora3", false),
+		Entry("other base model", "other", false),
+		Entry("not a LoRA adapter", "prefix", false),
+		Entry("path outside of the cache directory", "../lora1", false),
+	)
+
+	It("Should fail to create a resolver with a missing directory", func() {
+		_, err := newFilesystemLoraResolver("/no/such/dir")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Should load resolved adapters at request time", func() {
+		ctx := context.TODO()
+		cacheDir := createResolverCacheDir(adapters)
+		client, err := startServerWithArgs(ctx, "",
+			[]string{"cmd", "--model", model, "--mode", modeEcho, "--max-cpu-loras", "1",
+				"--lora-resolver-cache-dir", cacheDir})
+		Expect(err).NotTo(HaveOccurred())
+
+		openaiclient := openai.NewClient(
+			option.WithBaseURL(baseURL),
+			option.WithHTTPClient(client))
+
+		params := openai.ChatCompletionNewParams{
+			Messages: []openai.ChatCompletionMessageParamUnion{openai.UserMessage(userMessage)},
+			Model:    "lora1",
+		}
+		resp, err := openaiclient.Chat.Completions.New(ctx, params)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Model).To(Equal("lora1"))
+		Expect(resp.Choices[0].Message.Content).To(Equal(userMessage))
+
+		// the resolved adapter is listed in models
+		models, err := openaiclient.Models.List(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(models.Data).To(HaveLen(2))
+		Expect(models.Data[1].ID).To(Equal("lora1"))
+
+		// unknown adapter
+		var openaiError *openai.Error
+		params.Model = "lora3"
+		_, err = openaiclient.Chat.Completions.New(ctx, params)
+		Expect(errors.As(err, &openaiError)).To(BeTrue())
+		Expect(openaiError.StatusCode).To(Equal(404))
+
+		// no room for another adapter
+		params.Model = "lora2"
+		_, err = openaiclient.Chat.Completions.New(ctx, params)
+		Expect(errors.As(err, &openaiError)).To(BeTrue())
+		Expect(openaiError.StatusCode).To(Equal(400))
+		Expect(openaiError.DumpResponse(true)).To(ContainSubstring("Failed to load LoRA 'lora2'"))
+	})
+})
@@ -20,20 +20,60 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
 	vllmapi "github.com/llm-d/llm-d-inference-sim/pkg/vllm-api"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"github.com/openai/openai-go"
 	"github.com/openai/openai-go/option"
+	"k8s.io/klog/v2"
 )
 
+// postLoraRequest sends a LoRA load or unload request with the given body, and returns the status code
+// and the response body
+func postLoraRequest(client *http.Client, path string, body string) (int, string) {
+	resp, err := client.Post("http://localhost/v1/"+path, "application/json", strings.NewReader(body))
+	Expect(err).NotTo(HaveOccurred())
+	defer func() {
+		err := resp.Body.Close()
+		Expect(err).NotTo(HaveOccurred())
+	}()
+	respBody, err := io.ReadAll(resp.Body)
+	Expect(err).NotTo(HaveOccurred())
+	return resp.StatusCode, string(respBody)
+}
+
+// createLoraAdapterDir creates a directory with an adapter_config.json file with the given content
+func createLoraAdapterDir(adapterConfig string) string {
+	dir := GinkgoT().TempDir()
+	err := os.WriteFile(filepath.Join(dir, adapterConfigFile), []byte(adapterConfig), 0644)
+	Expect(err).NotTo(HaveOccurred())
+	return dir
+}
+
+// acquireLora tries to acquire a GPU slot for a request of the given lora, and returns
+// whether it succeeded and the lora load latency
+func acquireLora(s *VllmSimulator, lora string) (bool, int) {
+	s.addWaitingLora(lora)
+	reqCtx := &completionReqCtx{completionReq: &chatCompletionRequest{baseCompletionRequest: baseCompletionRequest{Model: lora}}}
+	ok := s.acquireLoraSlot(reqCtx)
+	return ok, reqCtx.loraLoadLatency
+}
+
 var _ = Describe("LoRAs", func() {
 	Context("LoRAs config and load", func() {
 		It("Should config, load and load LoRAs correctly", func() {
 			ctx := context.TODO()
 			client, err := startServerWithArgs(ctx, "",
-				[]string{"cmd", "--model", model, "--mode", modeEcho,
+				[]string{"cmd", "--model", model, "--mode", modeEcho, "--max-cpu-loras", "3",
 					"--lora-modules", "{\"name\":\"lora3\",\"path\":\"/path/to/lora3\"}",
 					"{\"name\":\"lora4\",\"path\":\"/path/to/lora4\"}"})
 			Expect(err).NotTo(HaveOccurred())
@@ -124,4 +164,374 @@ var _ = Describe("LoRAs", func() {
 			Expect(modelsResp.Data).To(HaveLen(3))
 		})
 	})
+
+	Context("LoRA slots", func() {
+		var s *VllmSimulator
+
+		BeforeEach(func() {
+			var err error
+			s, err = New(klog.Background())
+			Expect(err).NotTo(HaveOccurred())
+			s.config = NewConfig()
+			s.config.MaxLoras = 2
+			s.config.MaxCPULoras = 3
+			s.config.LoraLoadLatency = 100
+			s.config.LoraSwapLatency = 10
+			for _, lora := range []string{"lora1", "lora2", "lora3", "lora4"} {
+				s.loraAdaptors.Store(lora, loraModule{Name: lora})
+			}
+		})
+
+		It("Should load, evict and drop LoRAs by least recent use", func() {
+			ok, latency := acquireLora(s, "lora1")
+			Expect(ok).To(BeTrue())
+			Expect(latency).To(Equal(100))
+			ok, latency = acquireLora(s, "lora2")
+			Expect(ok).To(BeTrue())
+			Expect(latency).To(Equal(100))
+			// lora1 is already in a GPU slot
+			ok, latency = acquireLora(s, "lora1")
+			Expect(ok).To(BeTrue())
+			Expect(latency).To(Equal(0))
+
+			// both GPU slots are used by running requests
+			s.addWaitingLora("lora3")
+			reqCtx := &completionReqCtx{completionReq: &chatCompletionRequest{baseCompletionRequest: baseCompletionRequest{Model: "lora3"}}}
+			Expect(s.acquireLoraSlot(reqCtx)).To(BeFalse())
+			running, waiting := s.getRunningAndWaitingLoras()
+			Expect(running).To(Equal([]string{"lora1", "lora2"}))
+			Expect(waiting).To(Equal([]string{"lora3"}))
+
+			// lora2 is evicted to CPU
+			s.releaseLora("lora2")
+			Expect(s.acquireLoraSlot(reqCtx)).To(BeTrue())
+			Expect(reqCtx.loraLoadLatency).To(Equal(100))
+			Expect(s.gpuLoras).To(Equal([]string{"lora1", "lora3"}))
+			running, waiting = s.getRunningAndWaitingLoras()
+			Expect(running).To(Equal([]string{"lora1", "lora3"}))
+			Expect(waiting).To(BeEmpty())
+
+			// lora2 is swapped from CPU, lora1 is the least recently used lora
+			s.releaseLora("lora1")
+			s.releaseLora("lora1")
+			ok, latency = acquireLora(s, "lora2")
+			Expect(ok).To(BeTrue())
+			Expect(latency).To(Equal(10))
+			Expect(s.gpuLoras).To(Equal([]string{"lora3", "lora2"}))
+
+			// lora3 is evicted, lora1 is dropped from CPU
+			s.releaseLora("lora3")
+			ok, latency = acquireLora(s, "lora4")
+			Expect(ok).To(BeTrue())
+			Expect(latency).To(Equal(100))
+			Expect(s.gpuLoras).To(Equal([]string{"lora2", "lora4"}))
+			Expect(s.cpuLoras).To(Equal([]string{"lora3", "lora2", "lora4"}))
+		})
+
+		It("Should remove unloaded LoRAs from the slots", func() {
+			ok, _ := acquireLora(s, "lora1")
+			Expect(ok).To(BeTrue())
+			s.removeLoraFromSlots("lora1")
+			Expect(s.gpuLoras).To(BeEmpty())
+			Expect(s.cpuLoras).To(BeEmpty())
+		})
+	})
+
+	DescribeTable("requests wait for a free LoRA slot",
+		func(maxLoras string, minDuration time.Duration, maxDuration time.Duration) {
+			ctx := context.TODO()
+			client, err := startServerWithArgs(ctx, "",
+				[]string{"cmd", "--model", model, "--mode", modeEcho, "--time-to-first-token", "300",
+					"--max-loras", maxLoras, "--max-cpu-loras", "2",
+					"--lora-modules", "{\"name\":\"lora1\"}", "{\"name\":\"lora2\"}"})
+			Expect(err).NotTo(HaveOccurred())
+
+			openaiclient := openai.NewClient(
+				option.WithBaseURL(baseURL),
+				option.WithHTTPClient(client))
+
+			start := time.Now()
+			var wg sync.WaitGroup
+			for _, lora := range []string{"lora1", "lora2"} {
+				wg.Add(1)
+				go func() {
+					defer GinkgoRecover()
+					defer wg.Done()
+					params := openai.ChatCompletionNewParams{
+						Messages: []openai.ChatCompletionMessageParamUnion{openai.UserMessage(userMessage)},
+						Model:    lora,
+					}
+					_, err := openaiclient.Chat.Completions.New(ctx, params)
+					Expect(err).NotTo(HaveOccurred())
+				}()
+			}
+			wg.Wait()
+			elapsed := time.Since(start)
+			Expect(elapsed).To(BeNumerically(">=", minDuration))
+			Expect(elapsed).To(BeNumerically("<", maxDuration))
+		},
+		Entry("one GPU slot", "1", 600*time.Millisecond, 2*time.Second),
+		Entry("two GPU slots", "2", 300*time.Millisecond, 600*time.Millisecond),
+	)
+
+	DescribeTable("load and unload validations",
+		func(path string, body string, expectedStatus int, expectedMessage string) {
+			ctx := context.TODO()
+			client, err := startServerWithArgs(ctx, "",
+				[]string{"cmd", "--model", model, "--served-model-name", model, "alias", "--max-loras", "2",
+					"--lora-modules", "{\"name\":\"lora1\"}", "{\"name\":\"lora2\"}"})
+			Expect(err).NotTo(HaveOccurred())
+
+			status, respBody := postLoraRequest(client, path, body)
+			Expect(status).To(Equal(expectedStatus))
+			Expect(respBody).To(ContainSubstring(expectedMessage))
+		},
+		Entry("empty name", "load_lora_adapter", `{"lora_path": "/path"}`, http.StatusBadRequest,
+			"Both 'lora_name' and 'lora_path' must be provided."),
+		Entry("empty path", "load_lora_adapter", `{"lora_name": "lora3"}`, http.StatusBadRequest,
+			"Both 'lora_name' and 'lora_path' must be provided."),
+		Entry("duplicate", "load_lora_adapter", `{"lora_name": "lora1", "lora_path": "/path"}`, http.StatusBadRequest,
+			"The lora adapter 'lora1' has already been loaded."),
+		Entry("past capacity", "load_lora_adapter", `{"lora_name": "lora3", "lora_path": "/path"}`, http.StatusBadRequest,
+			"the maximum number of LoRA adapters (2) is already loaded"),
+		Entry("unknown base model", "load_lora_adapter", `{"lora_name": "lora3", "lora_path": "/path", "base_model_name": "other"}`,
+			http.StatusBadRequest, "The base model 'other' of LoRA adapter 'lora3' is not served."),
+		Entry("unload", "unload_lora_adapter", `{"lora_name": "lora1"}`, http.StatusOK,
+			"Success: LoRA adapter 'lora1' removed successfully."),
+		Entry("unload empty name", "unload_lora_adapter", `{}`, http.StatusBadRequest,
+			"'lora_name' needs to be provided to unload a LoRA adapter."),
+		Entry("unload unknown", "unload_lora_adapter", `{"lora_name": "lora3"}`, http.StatusNotFound,
+			"The lora adapter 'lora3' cannot be found."),
+	)
+
+	It("Should return vLLM's error when unloading a LoRA without a name", func() {
+		client, err := startServerWithArgs(context.TODO(), "", []string{"cmd", "--model", model})
+		Expect(err).NotTo(HaveOccurred())
+
+		status, respBody := postLoraRequest(client, "unload_lora_adapter", `{}`)
+		Expect(status).To(Equal(http.StatusBadRequest))
+		Expect(respBody).To(MatchJSON(`{"object": "error", "message": "'lora_name' needs to be provided to unload a LoRA adapter.",
+			"type": "InvalidUserInput", "param": null, "code": 400}`))
+	})
+
+	It("Should load a LoRA of a served model name and list it with its base model", func() {
+		ctx := context.TODO()
+		client, err := startServerWithArgs(ctx, "",
+			[]string{"cmd", "--model", model, "--served-model-name", "alias", "--max-cpu-loras", "2"})
+		Expect(err).NotTo(HaveOccurred())
+
+		status, respBody := postLoraRequest(client, "load_lora_adapter",
+			`{"lora_name": "lora1", "lora_path": "/path", "base_model_name": "alias"}`)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(respBody).To(Equal("Success: LoRA adapter 'lora1' added successfully."))
+
+		resp, err := client.Get("http://localhost/v1/models")
+		Expect(err).NotTo(HaveOccurred())
+		defer func() {
+			err := resp.Body.Close()
+			Expect(err).NotTo(HaveOccurred())
+		}()
+		var modelsResp vllmapi.ModelsResponse
+		err = json.NewDecoder(resp.Body).Decode(&modelsResp)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(modelsResp.Data).To(HaveLen(2))
+		Expect(modelsResp.Data[1].ID).To(Equal("lora1"))
+		Expect(*modelsResp.Data[1].Parent).To(Equal("alias"))
+	})
+
+	It("Should disable the load and unload endpoints", func() {
+		ctx := context.TODO()
+		client, err := startServerWithArgs(ctx, "",
+			[]string{"cmd", "--model", model, "--allow-runtime-lora-updating=false"})
+		Expect(err).NotTo(HaveOccurred())
+
+		status, _ := postLoraRequest(client, "load_lora_adapter", `{"lora_name": "lora1", "lora_path": "/path"}`)
+		Expect(status).To(Equal(http.StatusNotFound))
+		status, _ = postLoraRequest(client, "unload_lora_adapter", `{"lora_name": "lora1"}`)
+		Expect(status).To(Equal(http.StatusNotFound))
+	})
+
+	Context("LoRA adapters in local paths", func() {
+		It("Should read the adapter's metadata and list it in models", func() {
+			ctx := context.TODO()
+			client, err := startServerWithArgs(ctx, "",
+				[]string{"cmd", "--model", model, "--validate-lora-path", "--max-cpu-loras", "2"})
+			Expect(err).NotTo(HaveOccurred())
+
+			dir := createLoraAdapterDir(fmt.Sprintf(`{"r": 32, "target_modules": ["q_proj", "v_proj"],
+				"base_model_name_or_path": "%s"}`, model))
+			status, _ := postLoraRequest(client, "load_lora_adapter",
+				fmt.Sprintf(`{"lora_name": "lora1", "lora_path": "%s"}`, dir))
+			Expect(status).To(Equal(http.StatusOK))
+
+			resp, err := client.Get("http://localhost/v1/models")
+			Expect(err).NotTo(HaveOccurred())
+			defer func() {
+				err := resp.Body.Close()
+				Expect(err).NotTo(HaveOccurred())
+			}()
+			var modelsResp vllmapi.ModelsResponse
+			err = json.NewDecoder(resp.Body).Decode(&modelsResp)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(modelsResp.Data).To(HaveLen(2))
+			Expect(modelsResp.Data[1].Root).To(Equal(dir))
+			Expect(modelsResp.Data[1].LoraInfo).To(Equal(&vllmapi.LoraAdapterInfo{
+				Rank: 32, TargetModules: []string{"q_proj", "v_proj"}, BaseModel: model}))
+		})
+
+		DescribeTable("load validations",
+			func(validate bool, adapterConfig string, expectedStatus int, expectedMessage string) {
+				ctx := context.TODO()
+				client, err := startServerWithArgs(ctx, "",
+					[]string{"cmd", "--model", model, fmt.Sprintf("--validate-lora-path=%t", validate)})
+				Expect(err).NotTo(HaveOccurred())
+
+				path := "/no/such/path"
+				if adapterConfig != "" {
+					path = createLoraAdapterDir(adapterConfig)
+				}
+				status, respBody := postLoraRequest(client, "load_lora_adapter",
+					fmt.Sprintf(`{"lora_name": "lora1", "lora_path": "%s"}`, path))
+				Expect(status).To(Equal(expectedStatus))
+				Expect(respBody).To(ContainSubstring(expectedMessage))
+			},
+			Entry("missing path", true, "", http.StatusNotFound, "Loading lora lora1 failed: No adapter found for /no/such/path"),
+			Entry("missing path without validation", false, "", http.StatusOK, "added successfully"),
+			Entry("invalid adapter config", false, "{", http.StatusBadRequest, "invalid adapter_config.json"),
+			Entry("other base model", false, `{"r": 8, "base_model_name_or_path": "other-model"}`, http.StatusBadRequest,
+				"the adapter was trained for base model 'other-model'"),
+			Entry("regular expression target modules", true, `{"r": 8, "target_modules": ".*proj"}`, http.StatusOK,
+				"added successfully"),
+		)
+
+		DescribeTable("load from a path that is not a directory",
+			func(validate bool, expectedStatus int, expectedMessage string) {
+				ctx := context.TODO()
+				client, err := startServerWithArgs(ctx, "",
+					[]string{"cmd", "--model", model, fmt.Sprintf("--validate-lora-path=%t", validate)})
+				Expect(err).NotTo(HaveOccurred())
+
+				path := filepath.Join(createLoraAdapterDir(`{"r": 8}`), "adapter_config.json")
+				status, respBody := postLoraRequest(client, "load_lora_adapter",
+					fmt.Sprintf(`{"lora_name": "lora1", "lora_path": "%s"}`, path))
+				Expect(status).To(Equal(expectedStatus))
+				Expect(respBody).To(ContainSubstring(expectedMessage))
+			},
+			Entry("with validation", true, http.StatusNotFound, "No adapter found"),
+			Entry("without validation", false, http.StatusOK, "added successfully"),
+		)
+
+		It("Should fail to start with a missing LoRA path", func() {
+			ctx := context.TODO()
+			_, err := startServerWithArgs(ctx, "",
+				[]string{"cmd", "--model", model, "--validate-lora-path",
+					"--lora-modules", "{\"name\":\"lora1\",\"path\":\"/no/such/path\"}"})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("Should scale LoRA latencies by rank", func() {
+			s, err := New(klog.Background())
+			Expect(err).NotTo(HaveOccurred())
+			s.config = NewConfig()
+			s.config.InterTokenLatency = 10
+			s.config.LoraTokenOverhead = 4
+			s.loraAdaptors.Store("lora1", loraModule{Name: "lora1", info: &vllmapi.LoraAdapterInfo{Rank: 64}})
+			s.loraAdaptors.Store("lora2", loraModule{Name: "lora2"})
+
+			Expect(s.scaleByLoraRank(100, "lora1")).To(Equal(400))
+			Expect(s.scaleByLoraRank(100, "lora2")).To(Equal(100))
+			Expect(s.getInterTokenLatency("lora1")).To(Equal(26))
+			Expect(s.getInterTokenLatency("lora2")).To(Equal(14))
+			Expect(s.getInterTokenLatency(model)).To(Equal(10))
+		})
+	})
+
+	Context("LoRA profiles", func() {
+		It("Should use the LoRA's profile", func() {
+			ctx := context.TODO()
+			client, err := startServerWithArgs(ctx, "",
+				[]string{"cmd", "--model", model, "--mode", modeEcho, "--max-loras", "3",
+					"--lora-modules",
+					`{"name": "lora1", "mode": "random", "responses": ["Custom response."]}`,
+					`{"name": "lora2", "time_to_first_token": 500}`,
+					`{"name": "lora3", "error_rate": 1}`})
+			Expect(err).NotTo(HaveOccurred())
+
+			openaiclient := openai.NewClient(
+				option.WithBaseURL(baseURL),
+				option.WithHTTPClient(client))
+			params := openai.ChatCompletionNewParams{
+				Messages: []openai.ChatCompletionMessageParamUnion{openai.UserMessage(userMessage)},
+				Model:    "lora1",
+			}
+
+			// random mode with the LoRA's responses
+			resp, err := openaiclient.Chat.Completions.New(ctx, params)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Choices[0].Message.Content).To(Equal("Custom response."))
+
+			// the base model's mode with the LoRA's time to first token
+			params.Model = "lora2"
+			start := time.Now()
+			resp, err = openaiclient.Chat.Completions.New(ctx, params)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(time.Since(start)).To(BeNumerically(">=", 500*time.Millisecond))
+			Expect(resp.Choices[0].Message.Content).To(Equal(userMessage))
+
+			// the base model is not affected
+			params.Model = model
+			start = time.Now()
+			resp, err = openaiclient.Chat.Completions.New(ctx, params)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(time.Since(start)).To(BeNumerically("<", 500*time.Millisecond))
+			Expect(resp.Choices[0].Message.Content).To(Equal(userMessage))
+
+			// all the requests fail
+			params.Model = "lora3"
+			_, err = openaiclient.Chat.Completions.New(ctx, params, option.WithMaxRetries(0))
+			var openaiError *openai.Error
+			Expect(errors.As(err, &openaiError)).To(BeTrue())
+			Expect(openaiError.StatusCode).To(Equal(http.StatusInternalServerError))
+		})
+
+		It("Should use the base model's error rate unless the LoRA overrides it", func() {
+			ctx := context.TODO()
+			client, err := startServerWithArgs(ctx, "",
+				[]string{"cmd", "--model", model, "--mode", modeEcho, "--max-loras", "2", "--error-rate", "1",
+					"--lora-modules", `{"name": "lora1"}`, `{"name": "lora2", "error_rate": 0}`})
+			Expect(err).NotTo(HaveOccurred())
+
+			openaiclient := openai.NewClient(
+				option.WithBaseURL(baseURL),
+				option.WithHTTPClient(client))
+			params := openai.ChatCompletionNewParams{
+				Messages: []openai.ChatCompletionMessageParamUnion{openai.UserMessage(userMessage)},
+			}
+
+			for _, failingModel := range []string{model, "lora1"} {
+				params.Model = failingModel
+				_, err = openaiclient.Chat.Completions.New(ctx, params, option.WithMaxRetries(0))
+				var openaiError *openai.Error
+				Expect(errors.As(err, &openaiError)).To(BeTrue())
+				Expect(openaiError.StatusCode).To(Equal(http.StatusInternalServerError))
+			}
+
+			params.Model = "lora2"
+			resp, err := openaiclient.Chat.Completions.New(ctx, params)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Choices[0].Message.Content).To(Equal(userMessage))
+		})
+
+		DescribeTable("invalid profiles",
+			func(loraModule string) {
+				_, err := startServerWithArgs(context.TODO(), "",
+					[]string{"cmd", "--model", model, "--lora-modules", loraModule})
+				Expect(err).To(HaveOccurred())
+			},
+			Entry("negative time to first token", `{"name": "lora1", "time_to_first_token": -1}`),
+			Entry("negative inter token latency", `{"name": "lora1", "inter_token_latency": -1}`),
+			Entry("invalid mode", `{"name": "lora1", "mode": "other"}`),
+			Entry("invalid error rate", `{"name": "lora1", "error_rate": 1.5}`),
+		)
+	})
 })
@@ -21,17 +21,100 @@ package llmdinferencesim
 import (
 	"strconv"
 	"strings"
-	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	vllmapi "github.com/llm-d/llm-d-inference-sim/pkg/vllm-api"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttpadaptor"
 )
 
-// createAndRegisterPrometheus creates and registers prometheus metrics used by vLLM simulator
+// exemplarLabelRequestID is the label of the request ID in the exemplars of the latency histograms
+const exemplarLabelRequestID = "request_id"
+
+// bucket boundaries of the latency histograms, same as in vLLM
+var (
+	timeToFirstTokenBuckets = []float64{0.001, 0.005, 0.01, 0.02, 0.04, 0.06, 0.08, 0.1, 0.25, 0.5,
+		0.75, 1.0, 2.5, 5.0, 7.5, 10.0, 20.0, 40.0, 80.0, 160.0, 640.0, 2560.0}
+	timePerOutputTokenBuckets = []float64{0.01, 0.025, 0.05, 0.075, 0.1, 0.15, 0.2, 0.3, 0.4, 0.5,
+		0.75, 1.0, 2.5, 5.0, 7.5, 10.0, 20.0, 40.0, 80.0}
+	requestLatencyBuckets = []float64{0.3, 0.5, 0.8, 1.0, 1.5, 2.0, 2.5, 5.0, 10.0, 15.0, 20.0,
+		30.0, 40.0, 50.0, 60.0, 120.0, 240.0, 480.0, 960.0, 1920.0, 7680.0}
+)
+
+// requestParamsNBuckets are the bucket boundaries of the histogram of the n request parameter, same as in vLLM
+var requestParamsNBuckets = []float64{1, 2, 5, 10, 20}
+
+// build125Buckets returns bucket boundaries of 1, 2 and 5 times powers of 10, up to the given maximum,
+// i.e., 1, 2, 5, 10, 20, 50, 100 ..., like in vLLM
+func build125Buckets(maxValue int) []float64 {
+	buckets := []float64{}
+	for exponent := 1; ; exponent *= 10 {
+		for _, mantissa := range []int{1, 2, 5} {
+			value := mantissa * exponent
+			if value > maxValue {
+				return buckets
+			}
+			buckets = append(buckets, float64(value))
+		}
+	}
+}
+
+// requestTiming contains the times of the stages of a request's lifecycle, used to report
+// the latency metrics
+type requestTiming struct {
+	// arrival is the time the request was received
+	arrival time.Time
+	// scheduled is the time the request left the waiting queue
+	scheduled time.Time
+	// firstToken is the time the first output token was generated
+	firstToken time.Time
+	// lastToken is the time the last output token so far was generated
+	lastToken time.Time
+	// requestID is the ID of the request, added as an exemplar to the latency histograms
+	requestID string
+}
+
+// createAndRegisterPrometheus creates and registers prometheus metrics used by vLLM simulator in the
+// simulator's registry
 // Metrics reported:
 // - lora_requests_info
+// - num_requests_running
+// - num_requests_waiting
+// - gpu_cache_usage_perc
+// - time_to_first_token_seconds
+// - time_per_output_token_seconds
+// - e2e_request_latency_seconds
+// - request_queue_time_seconds
+// - request_prefill_time_seconds
+// - request_decode_time_seconds
+// - prompt_tokens_total
+// - generation_tokens_total
+// - request_success_total
+// - request_prompt_tokens
+// - request_generation_tokens
+// - request_params_n
+// - request_params_max_tokens
 func (s *VllmSimulator) createAndRegisterPrometheus() error {
+	s.registry = prometheus.NewRegistry()
+	var registerer prometheus.Registerer = s.registry
+	if s.config.DataParallelSize > 1 {
+		// like in vLLM, the metrics of each data parallel rank are labeled by the rank
+		registerer = prometheus.WrapRegistererWith(prometheus.Labels{vllmapi.PromLabelEngine: strconv.Itoa(s.rank)},
+			s.registry)
+	}
+	if err := registerer.Register(collectors.NewGoCollector()); err != nil {
+		s.logger.Error(err, "Prometheus go collector register failed")
+		return err
+	}
+	if err := registerer.Register(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{})); err != nil {
+		s.logger.Error(err, "Prometheus process collector register failed")
+		return err
+	}
+
 	s.loraInfo = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Subsystem: "",
@@ -41,7 +124,7 @@ func (s *VllmSimulator) createAndRegisterPrometheus() error {
 		[]string{vllmapi.PromLabelMaxLora, vllmapi.PromLabelRunningLoraAdapters, vllmapi.PromLabelWaitingLoraAdapters},
 	)
 
-	if err := prometheus.Register(s.loraInfo); err != nil {
+	if err := registerer.Register(s.loraInfo); err != nil {
 		s.logger.Error(err, "Prometheus lora info gauge register failed")
 		return err
 	}
@@ -55,12 +138,11 @@ func (s *VllmSimulator) createAndRegisterPrometheus() error {
 		[]string{vllmapi.PromLabelModelName},
 	)
 
-	if err := prometheus.Register(s.runningRequests); err != nil {
+	if err := registerer.Register(s.runningRequests); err != nil {
 		s.logger.Error(err, "Prometheus number of running requests gauge register failed")
 		return err
 	}
 
-	// not supported for now, reports constant value
 	s.waitingRequests = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Subsystem: "",
@@ -70,7 +152,7 @@ func (s *VllmSimulator) createAndRegisterPrometheus() error {
 		[]string{vllmapi.PromLabelModelName},
 	)
 
-	if err := prometheus.Register(s.waitingRequests); err != nil {
+	if err := registerer.Register(s.waitingRequests); err != nil {
 		s.logger.Error(err, "Prometheus number of requests in queue gauge register failed")
 		return err
 	}
@@ -85,11 +167,203 @@ func (s *VllmSimulator) createAndRegisterPrometheus() error {
 		[]string{vllmapi.PromLabelModelName},
 	)
 
-	if err := prometheus.Register(s.kvCacheUsagePercentage); err != nil {
+	if err := registerer.Register(s.kvCacheUsagePercentage); err != nil {
 		s.logger.Error(err, "Prometheus kv cache usage percentage gauge register failed")
 		return err
 	}
 
+	s.timeToFirstToken = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Subsystem: "",
+			Name:      "vllm:time_to_first_token_seconds",
+			Help:      "Histogram of time to first token in seconds.",
+			Buckets:   timeToFirstTokenBuckets,
+		},
+		[]string{vllmapi.PromLabelModelName},
+	)
+
+	if err := registerer.Register(s.timeToFirstToken); err != nil {
+		s.logger.Error(err, "Prometheus time to first token histogram register failed")
+		return err
+	}
+
+	s.timePerOutputToken = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Subsystem: "",
+			Name:      "vllm:time_per_output_token_seconds",
+			Help:      "Histogram of time per output token in seconds.",
+			Buckets:   timePerOutputTokenBuckets,
+		},
+		[]string{vllmapi.PromLabelModelName},
+	)
+
+	if err := registerer.Register(s.timePerOutputToken); err != nil {
+		s.logger.Error(err, "Prometheus time per output token histogram register failed")
+		return err
+	}
+
+	s.e2eRequestLatency = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Subsystem: "",
+			Name:      "vllm:e2e_request_latency_seconds",
+			Help:      "Histogram of e2e request latency in seconds.",
+			Buckets:   requestLatencyBuckets,
+		},
+		[]string{vllmapi.PromLabelModelName},
+	)
+
+	if err := registerer.Register(s.e2eRequestLatency); err != nil {
+		s.logger.Error(err, "Prometheus e2e request latency histogram register failed")
+		return err
+	}
+
+	s.requestQueueTime = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Subsystem: "",
+			Name:      "vllm:request_queue_time_seconds",
+			Help:      "Histogram of time spent in WAITING phase for request.",
+			Buckets:   requestLatencyBuckets,
+		},
+		[]string{vllmapi.PromLabelModelName},
+	)
+
+	if err := registerer.Register(s.requestQueueTime); err != nil {
+		s.logger.Error(err, "Prometheus request queue time histogram register failed")
+		return err
+	}
+
+	s.requestPrefillTime = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Subsystem: "",
+			Name:      "vllm:request_prefill_time_seconds",
+			Help:      "Histogram of time spent in PREFILL phase for request.",
+			Buckets:   requestLatencyBuckets,
+		},
+		[]string{vllmapi.PromLabelModelName},
+	)
+
+	if err := registerer.Register(s.requestPrefillTime); err != nil {
+		s.logger.Error(err, "Prometheus request prefill time histogram register failed")
+		return err
+	}
+
+	s.requestDecodeTime = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Subsystem: "",
+			Name:      "vllm:request_decode_time_seconds",
+			Help:      "Histogram of time spent in DECODE phase for request.",
+			Buckets:   requestLatencyBuckets,
+		},
+		[]string{vllmapi.PromLabelModelName},
+	)
+
+	if err := registerer.Register(s.requestDecodeTime); err != nil {
+		s.logger.Error(err, "Prometheus request decode time histogram register failed")
+		return err
+	}
+
+	s.promptTokensTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: "",
+			Name:      "vllm:prompt_tokens_total",
+			Help:      "Number of prefill tokens processed.",
+		},
+		[]string{vllmapi.PromLabelModelName},
+	)
+
+	if err := registerer.Register(s.promptTokensTotal); err != nil {
+		s.logger.Error(err, "Prometheus prompt tokens counter register failed")
+		return err
+	}
+
+	s.generationTokensTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: "",
+			Name:      "vllm:generation_tokens_total",
+			Help:      "Number of generation tokens processed.",
+		},
+		[]string{vllmapi.PromLabelModelName},
+	)
+
+	if err := registerer.Register(s.generationTokensTotal); err != nil {
+		s.logger.Error(err, "Prometheus generation tokens counter register failed")
+		return err
+	}
+
+	s.requestSuccessTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: "",
+			Name:      "vllm:request_success_total",
+			Help:      "Count of successfully processed requests.",
+		},
+		[]string{vllmapi.PromLabelModelName, vllmapi.PromLabelFinishedReason},
+	)
+
+	if err := registerer.Register(s.requestSuccessTotal); err != nil {
+		s.logger.Error(err, "Prometheus request success counter register failed")
+		return err
+	}
+
+	s.requestPromptTokens = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Subsystem: "",
+			Name:      "vllm:request_prompt_tokens",
+			Help:      "Number of prefill tokens processed.",
+			Buckets:   build125Buckets(s.config.MaxModelLen),
+		},
+		[]string{vllmapi.PromLabelModelName},
+	)
+
+	if err := registerer.Register(s.requestPromptTokens); err != nil {
+		s.logger.Error(err, "Prometheus request prompt tokens histogram register failed")
+		return err
+	}
+
+	s.requestGenerationTokens = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Subsystem: "",
+			Name:      "vllm:request_generation_tokens",
+			Help:      "Number of generation tokens processed.",
+			Buckets:   build125Buckets(s.config.MaxModelLen),
+		},
+		[]string{vllmapi.PromLabelModelName},
+	)
+
+	if err := registerer.Register(s.requestGenerationTokens); err != nil {
+		s.logger.Error(err, "Prometheus request generation tokens histogram register failed")
+		return err
+	}
+
+	s.requestParamsN = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Subsystem: "",
+			Name:      "vllm:request_params_n",
+			Help:      "Histogram of the n request parameter.",
+			Buckets:   requestParamsNBuckets,
+		},
+		[]string{vllmapi.PromLabelModelName},
+	)
+
+	if err := registerer.Register(s.requestParamsN); err != nil {
+		s.logger.Error(err, "Prometheus request params n histogram register failed")
+		return err
+	}
+
+	s.requestParamsMaxTokens = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Subsystem: "",
+			Name:      "vllm:request_params_max_tokens",
+			Help:      "Histogram of the max_tokens request parameter.",
+			Buckets:   build125Buckets(s.config.MaxModelLen),
+		},
+		[]string{vllmapi.PromLabelModelName},
+	)
+
+	if err := registerer.Register(s.requestParamsMaxTokens); err != nil {
+		s.logger.Error(err, "Prometheus request params max tokens histogram register failed")
+		return err
+	}
+
 	s.setInitialPrometheusMetrics()
 
 	return nil
@@ -103,9 +377,8 @@ func (s *VllmSimulator) setInitialPrometheusMetrics() {
 		"",
 		"").Set(float64(time.Now().Unix()))
 
-	s.nRunningReqs = 0
 	s.runningRequests.WithLabelValues(
-		modelName).Set(float64(s.nRunningReqs))
+		modelName).Set(float64(0))
 	s.waitingRequests.WithLabelValues(
 		modelName).Set(float64(0))
 	s.kvCacheUsagePercentage.WithLabelValues(
@@ -119,36 +392,160 @@ func (s *VllmSimulator) reportLoras() {
 		return
 	}
 
-	var loras []string
-	s.runningLoras.Range(func(key interface{}, _ interface{}) bool {
-		if lora, ok := key.(string); ok {
-			loras = append(loras, lora)
-		}
-		return true
-	})
-
-	allLoras := strings.Join(loras, ",")
+	running, waiting := s.getRunningAndWaitingLoras()
 	s.loraInfo.WithLabelValues(
 		strconv.Itoa(s.config.MaxLoras),
-		allLoras,
-		// TODO - add names of loras in queue
-		"").Set(float64(time.Now().Unix()))
+		strings.Join(running, ","),
+		strings.Join(waiting, ",")).Set(float64(time.Now().Unix()))
+}
+
+// updateModelRequests adds delta to the number of requests of the given model in the given counts,
+// and reports the model's number of requests in the given gauge
+func (s *VllmSimulator) updateModelRequests(gauge *prometheus.GaugeVec, counts map[string]int, model string, delta int) {
+	s.metricsLock.Lock()
+	defer s.metricsLock.Unlock()
+
+	counts[model] += delta
+	if gauge != nil {
+		gauge.WithLabelValues(model).Set(float64(counts[model]))
+	}
+}
+
+// observeValue adds an observation of the given value to the given histogram, with the given
+// model label
+func (s *VllmSimulator) observeValue(histogram *prometheus.HistogramVec, model string, value float64) {
+	if histogram == nil {
+		// Happens in the tests
+		return
+	}
+	histogram.WithLabelValues(model).Observe(value)
+}
+
+// recordRequestSuccess reports the tokens, the parameters and the finish reason of a successfully
+// processed request
+func (s *VllmSimulator) recordRequestSuccess(modelName string, req completionRequest, usageData *usage, finishReason string) {
+	if s.requestSuccessTotal == nil {
+		// Happens in the tests
+		return
+	}
+	s.promptTokensTotal.WithLabelValues(modelName).Add(float64(usageData.PromptTokens))
+	s.generationTokensTotal.WithLabelValues(modelName).Add(float64(usageData.CompletionTokens))
+	s.requestSuccessTotal.WithLabelValues(modelName, getMetricsFinishReason(req, finishReason)).Inc()
+	s.observeValue(s.requestPromptTokens, modelName, float64(usageData.PromptTokens))
+	s.observeValue(s.requestGenerationTokens, modelName, float64(usageData.CompletionTokens))
+	// the n parameter is not supported, each request generates a single sequence per prompt
+	s.observeValue(s.requestParamsN, modelName, 1)
+	// like in vLLM, if max tokens is not set, the maximum is the rest of the context window
+	maxTokens := s.config.MaxModelLen - usageData.PromptTokens
+	if maxCompletionTokens := req.getMaxCompletionTokens(); maxCompletionTokens != nil {
+		maxTokens = int(*maxCompletionTokens)
+	}
+	s.observeValue(s.requestParamsMaxTokens, modelName, float64(maxTokens))
+}
+
+// recordChoicesSuccess reports the choices of a successfully processed request, each choice of a text
+// completion request with several prompts is reported as a separate request, like in vLLM
+func (s *VllmSimulator) recordChoicesSuccess(modelName string, req completionRequest, usageData *usage,
+	choicesTokens [][]string, finishReasons []string) {
+	promptTokens := req.getNumberOfTokensPerPrompt()
+	if len(finishReasons) == 1 || len(promptTokens) != len(finishReasons) || len(choicesTokens) != len(finishReasons) {
+		s.recordRequestSuccess(modelName, req, usageData, finishReasons[0])
+		return
+	}
+	for i := range finishReasons {
+		s.recordRequestSuccess(modelName, req,
+			&usage{PromptTokens: promptTokens[i], CompletionTokens: len(choicesTokens[i])}, finishReasons[i])
+	}
+}
+
+// getMetricsFinishReason returns the finish reason of a request as reported by vLLM's metrics,
+// which is either stop or length
+func getMetricsFinishReason(req completionRequest, finishReason string) string {
+	if req.doRemoteDecode() {
+		// prefill requests generate a single token, and finish because of their length
+		return lengthFinishReason
+	}
+	if finishReason == toolsFinishReason {
+		return stopFinishReason
+	}
+	return finishReason
 }
 
-// reportRunningRequests sets information about running completion requests
-func (s *VllmSimulator) reportRunningRequests() {
-	if s.runningRequests != nil {
-		nRunningReqs := atomic.LoadInt64(&(s.nRunningReqs))
-		s.runningRequests.WithLabelValues(
-			s.getDisplayedModelName(s.config.Model)).Set(float64(nRunningReqs))
+// observeHistogram adds an observation of the given duration in seconds to the given histogram,
+// with the request's ID as an exemplar
+func (s *VllmSimulator) observeHistogram(histogram *prometheus.HistogramVec, model string, duration time.Duration,
+	requestID string) {
+	if histogram == nil {
+		// Happens in the tests
+		return
 	}
+	observer := histogram.WithLabelValues(model)
+	requestID = getExemplarRequestID(requestID)
+	if exemplarObserver, ok := observer.(prometheus.ExemplarObserver); ok && requestID != "" {
+		exemplarObserver.ObserveWithExemplar(duration.Seconds(), prometheus.Labels{exemplarLabelRequestID: requestID})
+		return
+	}
+	observer.Observe(duration.Seconds())
+}
+
+// getExemplarRequestID returns the given request ID in a form that can be used as an exemplar
+// label. The request ID may come from the client's X-Request-Id header, and prometheus rejects
+// exemplar labels that are not valid UTF-8 or are longer than ExemplarMaxRunes, so invalid IDs
+// are dropped and long IDs are truncated.
+func getExemplarRequestID(requestID string) string {
+	if !utf8.ValidString(requestID) {
+		return ""
+	}
+	maxRunes := prometheus.ExemplarMaxRunes - utf8.RuneCountInString(exemplarLabelRequestID)
+	if utf8.RuneCountInString(requestID) <= maxRunes {
+		return requestID
+	}
+	return string([]rune(requestID)[:maxRunes])
+}
+
+// metricsHandler returns the handler of the /metrics endpoint that exposes the metrics of the given
+// gatherer, the metrics are exposed in the OpenMetrics format, which includes the exemplars, if
+// requested by the Accept header
+func (s *VllmSimulator) metricsHandler(gatherer prometheus.Gatherer) fasthttp.RequestHandler {
+	return fasthttpadaptor.NewFastHTTPHandler(promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{
+		EnableOpenMetrics: true,
+	}))
 }
 
-// reportWaitingRequests sets information about waiting completion requests
-func (s *VllmSimulator) reportWaitingRequests() {
-	if s.waitingRequests != nil {
-		nWaitingReqs := atomic.LoadInt64(&(s.nWaitingReqs))
-		s.waitingRequests.WithLabelValues(
-			s.getDisplayedModelName(s.config.Model)).Set(float64(nWaitingReqs))
+// recordFirstToken records the generation of the first output token of a request, and reports
+// the time to first token, queue time and prefill time of the request
+func (s *VllmSimulator) recordFirstToken(model string, timing *requestTiming) {
+	if timing == nil {
+		return
+	}
+	timing.firstToken = time.Now()
+	timing.lastToken = timing.firstToken
+	s.observeHistogram(s.timeToFirstToken, model, timing.firstToken.Sub(timing.arrival), timing.requestID)
+	s.observeHistogram(s.requestQueueTime, model, timing.scheduled.Sub(timing.arrival), timing.requestID)
+	s.observeHistogram(s.requestPrefillTime, model, timing.firstToken.Sub(timing.scheduled), timing.requestID)
+}
+
+// recordOutputTokens records the generation of the given number of output tokens after the first
+// token, and reports the time per output token of each one of them
+func (s *VllmSimulator) recordOutputTokens(model string, timing *requestTiming, numOfTokens int) {
+	if timing == nil || numOfTokens < 1 {
+		return
+	}
+	now := time.Now()
+	timePerToken := now.Sub(timing.lastToken) / time.Duration(numOfTokens)
+	for range numOfTokens {
+		s.observeHistogram(s.timePerOutputToken, model, timePerToken, timing.requestID)
+	}
+	timing.lastToken = now
+}
+
+// recordRequestEnd reports the end to end latency and the decode time of a completed request,
+// requests that failed before generating tokens are not reported
+func (s *VllmSimulator) recordRequestEnd(model string, timing *requestTiming) {
+	if timing == nil || timing.firstToken.IsZero() {
+		return
 	}
+	now := time.Now()
+	s.observeHistogram(s.e2eRequestLatency, model, now.Sub(timing.arrival), timing.requestID)
+	s.observeHistogram(s.requestDecodeTime, model, now.Sub(timing.firstToken), timing.requestID)
 }
@@ -0,0 +1,339 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	vllmapi "github.com/llm-d/llm-d-inference-sim/pkg/vllm-api"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"k8s.io/klog/v2"
+)
+
+// findHistogram returns the histogram with the given name from the given registry, nil if the
+// histogram has no observations
+func findHistogram(registry *prometheus.Registry, name string) *dto.Histogram {
+	families, err := registry.Gather()
+	Expect(err).NotTo(HaveOccurred())
+	for _, family := range families {
+		if family.GetName() == name {
+			Expect(family.GetMetric()).To(HaveLen(1))
+			return family.GetMetric()[0].GetHistogram()
+		}
+	}
+	return nil
+}
+
+// findCounterValue returns the value of the counter with the given name and label value from the
+// given registry, zero if the counter was not found
+func findCounterValue(registry *prometheus.Registry, name string, labelValue string) float64 {
+	families, err := registry.Gather()
+	Expect(err).NotTo(HaveOccurred())
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetValue() == labelValue {
+					return metric.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	return 0
+}
+
+// findGaugeValue returns the value of the gauge with the given name and label value from the
+// given registry, zero if the gauge was not found
+func findGaugeValue(registry *prometheus.Registry, name string, labelValue string) float64 {
+	families, err := registry.Gather()
+	Expect(err).NotTo(HaveOccurred())
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetValue() == labelValue {
+					return metric.GetGauge().GetValue()
+				}
+			}
+		}
+	}
+	return 0
+}
+
+// findModelHistogram returns the histogram with the given name and model label from the given
+// registry, nil if the histogram has no observations for this model
+func findModelHistogram(registry *prometheus.Registry, name string, modelName string) *dto.Histogram {
+	families, err := registry.Gather()
+	Expect(err).NotTo(HaveOccurred())
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == vllmapi.PromLabelModelName && label.GetValue() == modelName {
+					return metric.GetHistogram()
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// createSimulatorWithMetrics creates a simulator with registered metrics, and returns it with its registry
+func createSimulatorWithMetrics() (*VllmSimulator, *prometheus.Registry) {
+	s, err := New(klog.Background())
+	Expect(err).NotTo(HaveOccurred())
+	s.config = NewConfig()
+	s.config.Model = model
+	s.config.ServedModelNames = []string{model}
+	err = s.createAndRegisterPrometheus()
+	Expect(err).NotTo(HaveOccurred())
+	return s, s.registry
+}
+
+var _ = Describe("Metrics", func() {
+	It("Should report the latency histograms", func() {
+		s, registry := createSimulatorWithMetrics()
+
+		now := time.Now()
+		timing := &requestTiming{arrival: now.Add(-300 * time.Millisecond), scheduled: now.Add(-100 * time.Millisecond)}
+		s.recordFirstToken(model, timing)
+		s.recordOutputTokens(model, timing, 4)
+		s.recordRequestEnd(model, timing)
+
+		ttft := findHistogram(registry, "vllm:time_to_first_token_seconds")
+		Expect(ttft.GetSampleCount()).To(Equal(uint64(1)))
+		Expect(ttft.GetSampleSum()).To(BeNumerically("~", 0.3, 0.05))
+		Expect(ttft.GetBucket()).To(HaveLen(len(timeToFirstTokenBuckets)))
+
+		queue := findHistogram(registry, "vllm:request_queue_time_seconds")
+		Expect(queue.GetSampleCount()).To(Equal(uint64(1)))
+		Expect(queue.GetSampleSum()).To(BeNumerically("~", 0.2, 0.001))
+
+		prefill := findHistogram(registry, "vllm:request_prefill_time_seconds")
+		Expect(prefill.GetSampleSum()).To(BeNumerically("~", 0.1, 0.05))
+
+		Expect(findHistogram(registry, "vllm:time_per_output_token_seconds").GetSampleCount()).To(Equal(uint64(4)))
+		Expect(findHistogram(registry, "vllm:e2e_request_latency_seconds").GetSampleCount()).To(Equal(uint64(1)))
+		Expect(findHistogram(registry, "vllm:request_decode_time_seconds").GetSampleCount()).To(Equal(uint64(1)))
+	})
+
+	It("Should not report the latency of requests without tokens", func() {
+		s, registry := createSimulatorWithMetrics()
+
+		s.recordRequestEnd(model, &requestTiming{arrival: time.Now()})
+		Expect(findHistogram(registry, "vllm:e2e_request_latency_seconds")).To(BeNil())
+	})
+
+	It("Should measure the timing of non-streaming responses", func() {
+		s, registry := createSimulatorWithMetrics()
+		s.config.InterTokenLatency = 20
+
+		timing := &requestTiming{arrival: time.Now(), scheduled: time.Now()}
+		s.waitForResponseTokens(model, timing, 50, 3)
+		s.recordRequestEnd(model, timing)
+
+		Expect(findHistogram(registry, "vllm:time_to_first_token_seconds").GetSampleSum()).To(BeNumerically(">=", 0.05))
+		tpot := findHistogram(registry, "vllm:time_per_output_token_seconds")
+		Expect(tpot.GetSampleCount()).To(Equal(uint64(2)))
+		Expect(tpot.GetSampleSum()).To(BeNumerically(">=", 0.04))
+		Expect(findHistogram(registry, "vllm:request_decode_time_seconds").GetSampleSum()).To(BeNumerically(">=", 0.04))
+	})
+
+	It("Should report the token and request counters", func() {
+		s, registry := createSimulatorWithMetrics()
+
+		maxTokens := int64(10)
+		req := &chatCompletionRequest{MaxTokens: &maxTokens}
+		s.recordRequestSuccess(model, req, &usage{PromptTokens: 5, CompletionTokens: 10}, lengthFinishReason)
+		req = &chatCompletionRequest{}
+		s.recordRequestSuccess(model, req, &usage{PromptTokens: 7, CompletionTokens: 3}, toolsFinishReason)
+		req = &chatCompletionRequest{baseCompletionRequest: baseCompletionRequest{DoRemoteDecode: true}}
+		s.recordRequestSuccess(model, req, &usage{PromptTokens: 8, CompletionTokens: 1}, stopFinishReason)
+
+		Expect(findCounterValue(registry, "vllm:prompt_tokens_total", model)).To(Equal(20.0))
+		Expect(findCounterValue(registry, "vllm:generation_tokens_total", model)).To(Equal(14.0))
+		Expect(findCounterValue(registry, "vllm:request_success_total", lengthFinishReason)).To(Equal(2.0))
+		Expect(findCounterValue(registry, "vllm:request_success_total", stopFinishReason)).To(Equal(1.0))
+
+		promptTokens := findHistogram(registry, "vllm:request_prompt_tokens")
+		Expect(promptTokens.GetSampleCount()).To(Equal(uint64(3)))
+		Expect(promptTokens.GetSampleSum()).To(Equal(20.0))
+		Expect(findHistogram(registry, "vllm:request_generation_tokens").GetSampleSum()).To(Equal(14.0))
+		Expect(findHistogram(registry, "vllm:request_params_n").GetSampleSum()).To(Equal(3.0))
+		// max tokens of requests without max tokens is the rest of the context window
+		Expect(findHistogram(registry, "vllm:request_params_max_tokens").GetSampleSum()).
+			To(Equal(float64(10 + (s.config.MaxModelLen - 7) + (s.config.MaxModelLen - 8))))
+	})
+
+	It("Should report each prompt of a text completion as a separate request", func() {
+		s, registry := createSimulatorWithMetrics()
+
+		req := &textCompletionRequest{Prompt: prompts{{Text: "one two three"}, {Text: "four five"}}}
+		choicesTokens := [][]string{{"one", " two", " three"}, {"four"}}
+		s.recordChoicesSuccess(model, req, &usage{PromptTokens: 5, CompletionTokens: 4}, choicesTokens,
+			[]string{stopFinishReason, lengthFinishReason})
+
+		Expect(findCounterValue(registry, "vllm:request_success_total", stopFinishReason)).To(Equal(1.0))
+		Expect(findCounterValue(registry, "vllm:request_success_total", lengthFinishReason)).To(Equal(1.0))
+		Expect(findCounterValue(registry, "vllm:prompt_tokens_total", model)).To(Equal(5.0))
+		Expect(findCounterValue(registry, "vllm:generation_tokens_total", model)).To(Equal(4.0))
+		promptTokens := findHistogram(registry, "vllm:request_prompt_tokens")
+		Expect(promptTokens.GetSampleCount()).To(Equal(uint64(2)))
+		Expect(promptTokens.GetSampleSum()).To(Equal(5.0))
+		generationTokens := findHistogram(registry, "vllm:request_generation_tokens")
+		Expect(generationTokens.GetSampleCount()).To(Equal(uint64(2)))
+		Expect(generationTokens.GetSampleSum()).To(Equal(4.0))
+	})
+
+	It("Should report the metrics per model", func() {
+		s, registry := createSimulatorWithMetrics()
+		s.config.ServedModelNames = []string{"alias1", "alias2"}
+		s.loraAdaptors.Store("lora1", loraModule{Name: "lora1"})
+
+		for _, reqModel := range []string{"alias1", "alias2", "lora1"} {
+			modelName := s.getDisplayedModelName(reqModel)
+			timing := &requestTiming{arrival: time.Now(), scheduled: time.Now()}
+			s.recordFirstToken(modelName, timing)
+			s.recordRequestEnd(modelName, timing)
+			req := &chatCompletionRequest{}
+			s.recordRequestSuccess(modelName, req, &usage{PromptTokens: 5, CompletionTokens: 2}, stopFinishReason)
+		}
+
+		Expect(findModelHistogram(registry, "vllm:e2e_request_latency_seconds", "alias1").GetSampleCount()).To(Equal(uint64(2)))
+		Expect(findModelHistogram(registry, "vllm:e2e_request_latency_seconds", "lora1").GetSampleCount()).To(Equal(uint64(1)))
+		Expect(findModelHistogram(registry, "vllm:e2e_request_latency_seconds", "alias2")).To(BeNil())
+		Expect(findCounterValue(registry, "vllm:prompt_tokens_total", "alias1")).To(Equal(10.0))
+		Expect(findCounterValue(registry, "vllm:generation_tokens_total", "lora1")).To(Equal(2.0))
+	})
+
+	It("Should report the running and waiting requests per model", func() {
+		s, registry := createSimulatorWithMetrics()
+
+		s.updateModelRequests(s.waitingRequests, s.waitingReqsPerModel, model, 1)
+		s.updateModelRequests(s.waitingRequests, s.waitingReqsPerModel, "lora1", 1)
+		s.updateModelRequests(s.waitingRequests, s.waitingReqsPerModel, "lora1", 1)
+		Expect(findGaugeValue(registry, "vllm:num_requests_waiting", model)).To(Equal(1.0))
+		Expect(findGaugeValue(registry, "vllm:num_requests_waiting", "lora1")).To(Equal(2.0))
+
+		reqCtx := &completionReqCtx{model: "lora1", completionReq: &chatCompletionRequest{}}
+		s.addRunningRequest(reqCtx)
+		Expect(findGaugeValue(registry, "vllm:num_requests_waiting", "lora1")).To(Equal(1.0))
+		Expect(findGaugeValue(registry, "vllm:num_requests_running", "lora1")).To(Equal(1.0))
+		Expect(findGaugeValue(registry, "vllm:num_requests_running", model)).To(Equal(0.0))
+
+		s.removeRunningRequest(reqCtx)
+		Expect(findGaugeValue(registry, "vllm:num_requests_running", "lora1")).To(Equal(0.0))
+	})
+
+	It("Should expose the metrics of each simulator in OpenMetrics format with exemplars", func() {
+		ctx := context.TODO()
+		args := []string{"cmd", "--model", model, "--mode", modeEcho}
+		client, err := startServerWithArgs(ctx, modeEcho, args)
+		Expect(err).NotTo(HaveOccurred())
+		// a second simulator in the same process has its own metrics
+		otherClient, err := startServerWithArgs(ctx, modeEcho, args)
+		Expect(err).NotTo(HaveOccurred())
+
+		body := `{"model": "` + model + `", "messages": [{"role": "user", "content": "Hello"}]}`
+		req, err := http.NewRequest(http.MethodPost, "http://localhost/v1/chat/completions", strings.NewReader(body))
+		Expect(err).NotTo(HaveOccurred())
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(requestIDHeader, "test-request-1")
+		resp, err := client.Do(req)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(resp.Header.Get(requestIDHeader)).To(Equal("test-request-1"))
+		Expect(resp.Body.Close()).To(Succeed())
+
+		req, err = http.NewRequest(http.MethodGet, "http://localhost/metrics", nil)
+		Expect(err).NotTo(HaveOccurred())
+		req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+		resp, err = client.Do(req)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Header.Get("Content-Type")).To(ContainSubstring("application/openmetrics-text"))
+		metrics, err := io.ReadAll(resp.Body)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Body.Close()).To(Succeed())
+		Expect(string(metrics)).To(MatchRegexp(`vllm:e2e_request_latency_seconds_bucket\{.*\} 1 # \{request_id="test-request-1"\}`))
+
+		resp, err = otherClient.Get("http://localhost/metrics")
+		Expect(err).NotTo(HaveOccurred())
+		metrics, err = io.ReadAll(resp.Body)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Body.Close()).To(Succeed())
+		Expect(string(metrics)).To(ContainSubstring("vllm:num_requests_running"))
+		Expect(string(metrics)).NotTo(ContainSubstring("vllm:e2e_request_latency_seconds_bucket"))
+	})
+
+	It("Should truncate long request IDs in the exemplars", func() {
+		client, err := startServerWithArgs(context.TODO(), modeEcho, []string{"cmd", "--model", model, "--mode", modeEcho})
+		Expect(err).NotTo(HaveOccurred())
+
+		requestID := strings.Repeat("x", 200)
+		body := `{"model": "` + model + `", "messages": [{"role": "user", "content": "Hello"}], "stream": true}`
+		req, err := http.NewRequest(http.MethodPost, "http://localhost/v1/chat/completions", strings.NewReader(body))
+		Expect(err).NotTo(HaveOccurred())
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(requestIDHeader, requestID)
+		resp, err := client.Do(req)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(resp.Header.Get(requestIDHeader)).To(Equal(requestID))
+		_, err = io.ReadAll(resp.Body)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Body.Close()).To(Succeed())
+
+		req, err = http.NewRequest(http.MethodGet, "http://localhost/metrics", nil)
+		Expect(err).NotTo(HaveOccurred())
+		req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+		resp, err = client.Do(req)
+		Expect(err).NotTo(HaveOccurred())
+		metrics, err := io.ReadAll(resp.Body)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Body.Close()).To(Succeed())
+		truncatedID := requestID[:prometheus.ExemplarMaxRunes-len(exemplarLabelRequestID)]
+		Expect(string(metrics)).To(ContainSubstring(`# {request_id="` + truncatedID + `"}`))
+	})
+
+	DescribeTable("exemplar request IDs",
+		func(requestID string, expectedID string) {
+			Expect(getExemplarRequestID(requestID)).To(Equal(expectedID))
+		},
+		Entry("short ID", "test-request-1", "test-request-1"),
+		Entry("long ID", strings.Repeat("x", 200), strings.Repeat("x", 118)),
+		Entry("long multi-byte ID", strings.Repeat("é", 200), strings.Repeat("é", 118)),
+		Entry("invalid UTF-8", "test-\xff", ""),
+	)
+
+	It("Should build 1-2-5 buckets", func() {
+		Expect(build125Buckets(1024)).To(Equal([]float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000}))
+		Expect(build125Buckets(5)).To(Equal([]float64{1, 2, 5}))
+	})
+})
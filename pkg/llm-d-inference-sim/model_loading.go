@@ -0,0 +1,58 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Simulation of the model loading phase on startup
+package llmdinferencesim
+
+import (
+	"time"
+)
+
+// startLoading starts the simulated loading of the model, the simulator is not ready until the
+// loading time passes
+func (s *VllmSimulator) startLoading() {
+	loadingTime := s.getLoadingTime()
+	if loadingTime == 0 {
+		return
+	}
+
+	s.loading.Store(true)
+	s.logger.Info("Loading model", "loading time", loadingTime)
+	time.AfterFunc(loadingTime, func() {
+		s.loading.Store(false)
+		s.logger.Info("Model loaded")
+	})
+}
+
+// isLoading returns true if the model is still loading
+func (s *VllmSimulator) isLoading() bool {
+	return s.loading.Load()
+}
+
+// getLoadingTime returns the time to load the model, sampled between startup-delay and
+// startup-delay-max if the maximum is set. If the model's size is set, the delay is the
+// time to load one GB of the model.
+func (s *VllmSimulator) getLoadingTime() time.Duration {
+	delay := s.config.StartupDelay
+	if s.config.StartupDelayMax > delay {
+		delay = s.random.randomInt(delay, s.config.StartupDelayMax)
+	}
+	loadingTime := time.Duration(delay) * time.Millisecond
+	if s.config.ModelSize > 0 {
+		loadingTime = time.Duration(float64(loadingTime) * s.config.ModelSize)
+	}
+	return loadingTime
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	vllmapi "github.com/llm-d/llm-d-inference-sim/pkg/vllm-api"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/klog/v2"
+)
+
+var _ = Describe("Model loading", func() {
+	It("Should not be ready while the model is loading", func() {
+		client, err := startServerWithArgs(context.TODO(), modeEcho, []string{"cmd", "--model", model, "--mode", modeEcho,
+			"--startup-delay", "500"})
+		Expect(err).NotTo(HaveOccurred())
+		completionBody := `{"model": "` + model + `", "prompt": "Hello world", "max_tokens": 2}`
+
+		status, _ := sendToRank(client, http.MethodGet, "/health", "", "")
+		Expect(status).To(Equal(http.StatusOK))
+		status, _ = sendToRank(client, http.MethodGet, "/ready", "", "")
+		Expect(status).To(Equal(http.StatusServiceUnavailable))
+		status, body := sendToRank(client, http.MethodPost, "/v1/completions", "", completionBody)
+		Expect(status).To(Equal(http.StatusServiceUnavailable))
+		Expect(body).To(ContainSubstring("The model is loading"))
+
+		var modelsResp vllmapi.ModelsResponse
+		_, body = sendToRank(client, http.MethodGet, "/v1/models", "", "")
+		Expect(json.Unmarshal([]byte(body), &modelsResp)).To(Succeed())
+		Expect(modelsResp.Data).To(BeEmpty())
+
+		Eventually(func() int {
+			status, _ := sendToRank(client, http.MethodGet, "/ready", "", "")
+			return status
+		}, time.Second).Should(Equal(http.StatusOK))
+		status, body = sendToRank(client, http.MethodPost, "/v1/completions", "", completionBody)
+		Expect(status).To(Equal(http.StatusOK), body)
+		_, body = sendToRank(client, http.MethodGet, "/v1/models", "", "")
+		Expect(json.Unmarshal([]byte(body), &modelsResp)).To(Succeed())
+		Expect(modelsResp.Data).To(HaveLen(1))
+	})
+
+	DescribeTable("loading time",
+		func(startupDelay int, startupDelayMax int, modelSize float64, minTime time.Duration, maxTime time.Duration) {
+			s, err := New(klog.Background())
+			Expect(err).NotTo(HaveOccurred())
+			s.config = NewConfig()
+			s.config.StartupDelay = startupDelay
+			s.config.StartupDelayMax = startupDelayMax
+			s.config.ModelSize = modelSize
+			s.random = newRandom(time.Now().UnixNano())
+
+			for range 10 {
+				loadingTime := s.getLoadingTime()
+				Expect(loadingTime).To(BeNumerically(">=", minTime))
+				Expect(loadingTime).To(BeNumerically("<=", maxTime))
+			}
+		},
+		Entry("no delay", 0, 0, 0.0, time.Duration(0), time.Duration(0)),
+		Entry("fixed delay", 100, 0, 0.0, 100*time.Millisecond, 100*time.Millisecond),
+		Entry("sampled delay", 100, 200, 0.0, 100*time.Millisecond, 200*time.Millisecond),
+		Entry("delay per GB", 100, 0, 2.5, 250*time.Millisecond, 250*time.Millisecond),
+		Entry("sampled delay per GB", 100, 200, 2.0, 200*time.Millisecond, 400*time.Millisecond),
+	)
+})
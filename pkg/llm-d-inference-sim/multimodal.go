@@ -0,0 +1,126 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Contains functions related to multimodal (images, audios and videos) input
+package llmdinferencesim
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"strings"
+)
+
+const (
+	modalityImage = "image"
+	modalityAudio = "audio"
+	modalityVideo = "video"
+
+	contentTypeImageURL   = "image_url"
+	contentTypeInputAudio = "input_audio"
+	contentTypeAudioURL   = "audio_url"
+	contentTypeVideoURL   = "video_url"
+
+	defaultImageTokens = 256
+	defaultAudioTokens = 200
+	defaultVideoTokens = 1024
+)
+
+// getModality returns the modality of the given content block: image, audio or video,
+// for text and unknown blocks returns an empty string
+func getModality(block contentBlock) string {
+	switch block.Type {
+	case contentTypeImageURL:
+		return modalityImage
+	case contentTypeInputAudio, contentTypeAudioURL:
+		return modalityAudio
+	case contentTypeVideoURL:
+		return modalityVideo
+	}
+	return ""
+}
+
+// countMultimodalTokens returns the number of prompt tokens of the given multimodal items
+// according to the configuration
+func (s *VllmSimulator) countMultimodalTokens(items []contentBlock) int {
+	tokens := 0
+	for _, item := range items {
+		switch getModality(item) {
+		case modalityImage:
+			tokens += s.getImageTokens(item.ImageURL.Url)
+		case modalityAudio:
+			tokens += s.config.MMAudioTokens
+		case modalityVideo:
+			tokens += s.config.MMVideoTokens
+		}
+	}
+	return tokens
+}
+
+// getImageTokens returns the number of tokens of the image with the given URL. If image patch size
+// is configured and the URL is a data URL of an image with decodable dimensions, the number of tokens
+// is the number of patches in the image, otherwise it is the configured number of tokens per image
+func (s *VllmSimulator) getImageTokens(url string) int {
+	if s.config.MMImagePatchSize > 0 {
+		if width, height, ok := getImageDimensions(url); ok {
+			patch := s.config.MMImagePatchSize
+			return ((width + patch - 1) / patch) * ((height + patch - 1) / patch)
+		}
+	}
+	return s.config.MMImageTokens
+}
+
+// getImageDimensions returns the width and the height of an image defined by a base64 encoded
+// data URL, e.g. data:image/png;base64,<data>. Returns false if the dimensions cannot be decoded.
+func getImageDimensions(url string) (int, int, bool) {
+	dataURL, found := strings.CutPrefix(url, "data:")
+	if !found {
+		return 0, 0, false
+	}
+	mediaType, data, found := strings.Cut(dataURL, ",")
+	if !found || !strings.HasSuffix(mediaType, ";base64") {
+		return 0, 0, false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return 0, 0, false
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(decoded))
+	if err != nil {
+		return 0, 0, false
+	}
+	return config.Width, config.Height, true
+}
+
+// validateMultimodalLimits checks that the number of the multimodal items of each modality
+// doesn't exceed the limit defined in limit-mm-per-prompt, returns an error message if it does
+func (s *VllmSimulator) validateMultimodalLimits(items []contentBlock) string {
+	counts := make(map[string]int)
+	for _, item := range items {
+		counts[getModality(item)]++
+	}
+	for _, modality := range []string{modalityImage, modalityAudio, modalityVideo} {
+		limit, ok := s.config.LimitMMPerPrompt[modality]
+		if ok && counts[modality] > limit {
+			return fmt.Sprintf("At most %d %s(s) may be provided in one prompt.", limit, modality)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// createPNGDataURL returns a base64 data URL of a PNG image with the given dimensions
+func createPNGDataURL(width int, height int) string {
+	var buf bytes.Buffer
+	err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height)))
+	Expect(err).NotTo(HaveOccurred())
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+// sendMultimodalRequest sends a chat completion request with the given content blocks
+func sendMultimodalRequest(client *http.Client, blocks string) *http.Response {
+	reqBody := fmt.Sprintf(`{
+		"messages": [{"role": "user", "content": [{"type": "text", "text": "%s"}, %s]}],
+		"model": "%s"
+	}`, userMessage, blocks, model)
+	resp, err := client.Post("http://localhost/v1/chat/completions", "application/json", strings.NewReader(reqBody))
+	Expect(err).NotTo(HaveOccurred())
+	return resp
+}
+
+var _ = Describe("Multimodal input", func() {
+	imageBlock := `{"type": "image_url", "image_url": {"url": "https://example.com/image.png"}}`
+	audioBlock := `{"type": "input_audio", "input_audio": {"data": "AAAA", "format": "wav"}}`
+	videoBlock := `{"type": "video_url", "video_url": {"url": "https://example.com/video.mp4"}}`
+
+	DescribeTable("prompt tokens",
+		func(blocks string, expectedMultimodalTokens int) {
+			ctx := context.TODO()
+			args := []string{"cmd", "--model", model, "--mode", modeEcho, "--mm-image-tokens", "100",
+				"--mm-audio-tokens", "50", "--mm-video-tokens", "300", "--mm-image-patch-size", "28"}
+			client, err := startServerWithArgs(ctx, modeEcho, args)
+			Expect(err).NotTo(HaveOccurred())
+
+			resp := sendMultimodalRequest(client, blocks)
+			defer func() {
+				err := resp.Body.Close()
+				Expect(err).NotTo(HaveOccurred())
+			}()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+			var chatResp chatCompletionResponse
+			err = json.NewDecoder(resp.Body).Decode(&chatResp)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(strings.TrimSpace(chatResp.Choices[0].Message.Content.PlainText())).To(Equal(userMessage))
+			expectedPromptTokens := len(strings.Fields(userMessage)) + expectedMultimodalTokens
+			Expect(chatResp.Usage.PromptTokens).To(Equal(expectedPromptTokens))
+		},
+		Entry("image", imageBlock, 100),
+		Entry("audio", audioBlock, 50),
+		Entry("video", videoBlock, 300),
+		Entry("image, audio and video", strings.Join([]string{imageBlock, audioBlock, videoBlock}, ","), 450),
+		Entry("image data URL", fmt.Sprintf(`{"type": "image_url", "image_url": {"url": "%s"}}`,
+			createPNGDataURL(56, 30)), 4),
+		Entry("invalid image data URL", `{"type": "image_url", "image_url": {"url": "data:image/png;base64,AAAA"}}`, 100),
+	)
+
+	DescribeTable("limit items per prompt",
+		func(blocks string, expectedStatus int, expectedMessage string) {
+			ctx := context.TODO()
+			args := []string{"cmd", "--model", model, "--mode", modeEcho,
+				"--limit-mm-per-prompt", `{"image": 1, "video": 0}`}
+			client, err := startServerWithArgs(ctx, modeEcho, args)
+			Expect(err).NotTo(HaveOccurred())
+
+			resp := sendMultimodalRequest(client, blocks)
+			defer func() {
+				err := resp.Body.Close()
+				Expect(err).NotTo(HaveOccurred())
+			}()
+			Expect(resp.StatusCode).To(Equal(expectedStatus))
+			if expectedMessage != "" {
+				body, err := io.ReadAll(resp.Body)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(body)).To(ContainSubstring(expectedMessage))
+			}
+		},
+		Entry("one image", imageBlock, http.StatusOK, ""),
+		Entry("two images", imageBlock+","+imageBlock, http.StatusBadRequest,
+			"At most 1 image(s) may be provided in one prompt."),
+		Entry("video", videoBlock, http.StatusBadRequest, "At most 0 video(s) may be provided in one prompt."),
+		Entry("unlimited audios", audioBlock+","+audioBlock, http.StatusOK, ""),
+	)
+
+	DescribeTable("item latency",
+		func(stream bool) {
+			ctx := context.TODO()
+			args := []string{"cmd", "--model", model, "--mode", modeEcho, "--mm-item-latency", "200"}
+			client, err := startServerWithArgs(ctx, modeEcho, args)
+			Expect(err).NotTo(HaveOccurred())
+
+			reqBody := fmt.Sprintf(`{
+				"messages": [{"role": "user", "content": [{"type": "text", "text": "%s"}, %s, %s]}],
+				"model": "%s",
+				"stream": %t
+			}`, userMessage, imageBlock, audioBlock, model, stream)
+			start := time.Now()
+			resp, err := client.Post("http://localhost/v1/chat/completions", "application/json", strings.NewReader(reqBody))
+			Expect(err).NotTo(HaveOccurred())
+			defer func() {
+				err := resp.Body.Close()
+				Expect(err).NotTo(HaveOccurred())
+			}()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			_, err = io.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			// each item adds to the time to first token
+			Expect(time.Since(start)).To(BeNumerically(">=", 400*time.Millisecond))
+		},
+		Entry("non-streaming", false),
+		Entry("streaming", true),
+	)
+})
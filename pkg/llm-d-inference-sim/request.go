@@ -18,18 +18,26 @@ limitations under the License.
 package llmdinferencesim
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
 	"strings"
 	"sync"
 
+	"github.com/santhosh-tekuri/jsonschema/v5"
 	"github.com/valyala/fasthttp"
 )
 
 // completionRequest interface representing both completion request types (text and chat)
 type completionRequest interface {
 	// createResponseText creates and returns response payload based on this request,
-	// i.e., an array of generated tokens, the finish reason, and the number of created
-	// tokens
-	createResponseText(mode string) ([]string, string, int, error)
+	// i.e., an array of generated tokens and a finish reason for each choice of the response,
+	// and the total number of created tokens. reservedTokens is the number of tokens out of
+	// the max completion tokens that were already generated (e.g., reasoning tokens).
+	// In random mode, the text is taken from the given responses, or from the pre-defined
+	// responses if they are empty.
+	createResponseText(rnd *random, mode string, responses []string, reservedTokens int) ([][]string, []string, int, error)
 	// isStream returns boolean that defines is response should be streamed
 	isStream() bool
 	// getModel returns model name as defined in the request
@@ -38,16 +46,28 @@ type completionRequest interface {
 	includeUsage() bool
 	// getNumberOfPromptTokens returns the number of tokens in the prompt
 	getNumberOfPromptTokens() int
+	// getNumberOfTokensPerPrompt returns the number of tokens of each prompt, each prompt is
+	// processed as a separate request (in text completion)
+	getNumberOfTokensPerPrompt() []int
+	// getMultimodalItems returns the images, audios and videos in the prompt (in chat completion)
+	getMultimodalItems() []contentBlock
 	// getTools() returns tools to use (in chat completion)
 	getTools() []tool
 	// getToolChoice() returns tool choice (in chat completion)
 	getToolChoice() string
+	// endsWithToolResults() returns true if the last message is a result of a tool call (in chat completion)
+	endsWithToolResults() bool
 	// getMaxCompletionTokens returns the maximum completion tokens requested
 	getMaxCompletionTokens() *int64
 	// doRemoteDecode() returns true if do_remote_decode field is true in the request, this means that this is prefill request
 	doRemoteDecode() bool
 	// doRemotePrefill() returns true if do_remote_prefill field is true in the request, this means that this is decode request
 	doRemotePrefill() bool
+	// getKVTransferParams returns the P/D parameters of the request, either from kv_transfer_params
+	// or from the legacy flat fields
+	getKVTransferParams() *kvTransferParams
+	// validateKVTransferParams returns an error if the P/D parameters of the request are invalid
+	validateKVTransferParams() error
 }
 
 // baseCompletionRequest contains base completion request related information
@@ -70,6 +90,9 @@ type baseCompletionRequest struct {
 	RemoteHost string `json:"remote_host"`
 	// RemotePort is a port of the remote server handling prefill
 	RemotePort int `json:"remote_port"`
+	// KVTransferParams contains the P/D parameters in vLLM's kv_transfer_params format, used instead
+	// of the flat fields above
+	KVTransferParams *kvTransferParams `json:"kv_transfer_params,omitempty"`
 }
 
 // StreamOptions defines streaming options for streaming requests
@@ -91,11 +114,46 @@ func (b *baseCompletionRequest) includeUsage() bool {
 }
 
 func (b *baseCompletionRequest) doRemoteDecode() bool {
-	return b.DoRemoteDecode
+	return b.DoRemoteDecode || (b.KVTransferParams != nil && b.KVTransferParams.DoRemoteDecode)
 }
 
 func (b *baseCompletionRequest) doRemotePrefill() bool {
-	return b.DoRemotePrefill
+	return b.DoRemotePrefill || (b.KVTransferParams != nil && b.KVTransferParams.DoRemotePrefill)
+}
+
+func (b *baseCompletionRequest) getKVTransferParams() *kvTransferParams {
+	if b.KVTransferParams != nil {
+		return b.KVTransferParams
+	}
+	params := &kvTransferParams{
+		DoRemoteDecode:  b.DoRemoteDecode,
+		DoRemotePrefill: b.DoRemotePrefill,
+		RemoteEngineId:  b.RemoteEngineId,
+		RemoteHost:      b.RemoteHost,
+		RemotePort:      b.RemotePort,
+		legacy:          true,
+	}
+	// the block IDs are validated in validateKVTransferParams
+	for _, id := range b.RemoteBlockIds {
+		if blockID, err := strconv.Atoi(id); err == nil {
+			params.RemoteBlockIds = append(params.RemoteBlockIds, blockID)
+		}
+	}
+	return params
+}
+
+func (b *baseCompletionRequest) validateKVTransferParams() error {
+	if b.KVTransferParams != nil || !b.DoRemotePrefill || b.RemoteHost == "" {
+		// only the legacy block IDs of requests that are validated by the prefill instance are
+		// checked, for backward compatibility
+		return nil
+	}
+	for _, id := range b.RemoteBlockIds {
+		if _, err := strconv.Atoi(id); err != nil {
+			return fmt.Errorf("invalid remote block ID '%s'", id)
+		}
+	}
+	return nil
 }
 
 // completionReqCtx is a context passed in the simulator's flow, it contains the request data needed
@@ -106,6 +164,13 @@ type completionReqCtx struct {
 	isChatCompletion bool
 	wg               *sync.WaitGroup
 	processingTokens int
+	// model is the displayed name of the request's model, used as the model label of the metrics
+	model string
+	// timing contains the times of the stages of the request's lifecycle
+	timing *requestTiming
+	// loraLoadLatency is the time to load the request's LoRA to a GPU slot, in milliseconds,
+	// zero if the LoRA is already loaded or the request is for the base model
+	loraLoadLatency int
 }
 
 // chatCompletionRequest defines structure of /chat/completion request
@@ -133,6 +198,9 @@ type chatCompletionRequest struct {
 	// possible values: none, auto, required.
 	// Sending an object with a specific tool, is currently not supported.
 	ToolChoice string `json:"tool_choice,omitempty"`
+
+	// multimodalTokens is the number of prompt tokens of the images, audios and videos in the messages
+	multimodalTokens int
 }
 
 // function defines a tool
@@ -143,6 +211,8 @@ type function struct {
 	Parameters map[string]any `json:"parameters,omitempty"`
 	// Description is the function's description
 	Description string `json:"description"`
+	// parametersSchema is the compiled Parameters schema, used to validate generated arguments
+	parametersSchema *jsonschema.Schema
 }
 
 // tool defines a tool to use in chat completion
@@ -156,10 +226,28 @@ type tool struct {
 
 func (c *chatCompletionRequest) getNumberOfPromptTokens() int {
 	var messages string
+	var toolCalls []toolCall
 	for _, message := range c.Messages {
 		messages += message.Content.PlainText() + " "
+		// tool calls of previous assistant messages are part of the prompt
+		for _, tc := range message.ToolCalls {
+			tc.Function.tokenizedArguments = tokenize(tc.Function.Arguments)
+			toolCalls = append(toolCalls, tc)
+		}
+	}
+	return len(strings.Fields(messages)) + countTokensForToolCalls(toolCalls) + c.multimodalTokens
+}
+
+func (c *chatCompletionRequest) getNumberOfTokensPerPrompt() []int {
+	return []int{c.getNumberOfPromptTokens()}
+}
+
+func (c *chatCompletionRequest) getMultimodalItems() []contentBlock {
+	items := make([]contentBlock, 0)
+	for _, message := range c.Messages {
+		items = append(items, message.Content.multimodalItems()...)
 	}
-	return len(strings.Fields(messages))
+	return items
 }
 
 func (c *chatCompletionRequest) getTools() []tool {
@@ -170,6 +258,10 @@ func (c *chatCompletionRequest) getToolChoice() string {
 	return c.ToolChoice
 }
 
+func (c *chatCompletionRequest) endsWithToolResults() bool {
+	return len(c.Messages) > 0 && c.Messages[len(c.Messages)-1].Role == roleTool
+}
+
 func (c *chatCompletionRequest) getMaxCompletionTokens() *int64 {
 	if c.MaxCompletionTokens != nil {
 		return c.MaxCompletionTokens
@@ -189,32 +281,53 @@ func (req *chatCompletionRequest) getLastUserMsg() string {
 	return ""
 }
 
+// getLastToolResults returns the contents of the tool messages at the end of this request's
+// messages, separated by spaces, if the last message is not a tool message - returns an empty string
+func (req *chatCompletionRequest) getLastToolResults() string {
+	results := make([]string, 0)
+	for i := len(req.Messages) - 1; i >= 0 && req.Messages[i].Role == roleTool; i-- {
+		results = append([]string{strings.TrimSpace(req.Messages[i].Content.PlainText())}, results...)
+	}
+	return strings.Join(results, " ")
+}
+
 // createResponseText creates and returns response payload based on this request,
 // i.e., an array of generated tokens, the finish reason, and the number of created
-// tokens
-func (req chatCompletionRequest) createResponseText(mode string) ([]string, string, int, error) {
+// tokens, chat completion responses contain a single choice
+func (req chatCompletionRequest) createResponseText(rnd *random, mode string, responses []string, reservedTokens int) ([][]string, []string, int, error) {
 	maxTokens, err := getMaxTokens(req.MaxCompletionTokens, req.MaxTokens)
 	if err != nil {
-		return nil, "", 0, err
+		return nil, nil, 0, err
+	}
+	maxTokens = reduceMaxTokens(maxTokens, reservedTokens)
+	if maxTokens != nil && *maxTokens == 0 {
+		// all the tokens were already generated
+		return [][]string{nil}, []string{lengthFinishReason}, 0, nil
 	}
 
 	var text, finishReason string
 	if mode == modeEcho {
-		text, finishReason = getResponseText(maxTokens, req.getLastUserMsg())
+		// in case the request ends with tool results, the response is based on them
+		msg := req.getLastUserMsg()
+		if req.endsWithToolResults() {
+			msg = req.getLastToolResults()
+		}
+		text, finishReason = getResponseText(maxTokens, msg)
 	} else {
-		text, finishReason = getRandomResponseText(maxTokens)
+		text, finishReason = getRandomResponseTextFrom(rnd, responses, maxTokens)
 	}
 
 	tokens := tokenize(text)
-	return tokens, finishReason, len(tokens), nil
+	return [][]string{tokens}, []string{finishReason}, len(tokens), nil
 }
 
 // v1/completion
 // textCompletionRequest defines structure of /completion request
 type textCompletionRequest struct {
 	baseCompletionRequest
-	// Prompt defines request's content
-	Prompt string `json:"prompt"`
+	// Prompt defines request's content, a text, a list of texts, a list of token IDs,
+	// or a list of lists of token IDs
+	Prompt prompts `json:"prompt"`
 
 	// The maximum number of [tokens](/tokenizer) that can be generated in the
 	// completion.
@@ -224,8 +337,84 @@ type textCompletionRequest struct {
 	MaxTokens *int64 `json:"max_tokens"`
 }
 
+// prompt is a single prompt of a text completion request, either a text or a list of token IDs
+type prompt struct {
+	// Text is the prompt's text, for prompts of token IDs it is set to the detokenized token IDs
+	Text string
+	// TokenIDs are the prompt's token IDs, nil for text prompts
+	TokenIDs []int
+}
+
+// prompts are the prompts of a text completion request, each prompt gets its own choice in the response
+type prompts []prompt
+
+// UnmarshalJSON allow use all the formats
+func (p *prompts) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*p = prompts{{Text: text}}
+		return nil
+	}
+
+	var tokenIDs []int
+	if err := json.Unmarshal(data, &tokenIDs); err == nil {
+		if len(tokenIDs) == 0 {
+			return errors.New("prompt cannot be empty")
+		}
+		*p = prompts{{TokenIDs: tokenIDs}}
+		return nil
+	}
+
+	var texts []string
+	if err := json.Unmarshal(data, &texts); err == nil {
+		*p = make(prompts, 0, len(texts))
+		for _, text := range texts {
+			*p = append(*p, prompt{Text: text})
+		}
+		return nil
+	}
+
+	var tokenIDsLists [][]int
+	if err := json.Unmarshal(data, &tokenIDsLists); err == nil {
+		*p = make(prompts, 0, len(tokenIDsLists))
+		for _, tokenIDs := range tokenIDsLists {
+			if len(tokenIDs) == 0 {
+				return errors.New("prompt cannot be empty")
+			}
+			*p = append(*p, prompt{TokenIDs: tokenIDs})
+		}
+		return nil
+	}
+
+	return errors.New("prompt format not supported")
+}
+
+// getNumberOfTokens returns the number of tokens in the prompt
+func (p *prompt) getNumberOfTokens() int {
+	if p.TokenIDs != nil {
+		return len(p.TokenIDs)
+	}
+	return len(strings.Fields(p.Text))
+}
+
 func (t *textCompletionRequest) getNumberOfPromptTokens() int {
-	return len(strings.Fields(t.Prompt))
+	numberOfTokens := 0
+	for i := range t.Prompt {
+		numberOfTokens += t.Prompt[i].getNumberOfTokens()
+	}
+	return numberOfTokens
+}
+
+func (t *textCompletionRequest) getNumberOfTokensPerPrompt() []int {
+	numberOfTokens := make([]int, 0, len(t.Prompt))
+	for i := range t.Prompt {
+		numberOfTokens = append(numberOfTokens, t.Prompt[i].getNumberOfTokens())
+	}
+	return numberOfTokens
+}
+
+func (c *textCompletionRequest) getMultimodalItems() []contentBlock {
+	return nil
 }
 
 func (c *textCompletionRequest) getTools() []tool {
@@ -236,26 +425,46 @@ func (c *textCompletionRequest) getToolChoice() string {
 	return ""
 }
 
+func (c *textCompletionRequest) endsWithToolResults() bool {
+	return false
+}
+
 func (c *textCompletionRequest) getMaxCompletionTokens() *int64 {
 	return c.MaxTokens
 }
 
 // createResponseText creates and returns response payload based on this request,
-// i.e., an array of generated tokens, the finish reason, and the number of created
-// tokens
-func (req textCompletionRequest) createResponseText(mode string) ([]string, string, int, error) {
+// i.e., an array of generated tokens and the finish reason for each one of the prompts,
+// and the total number of created tokens
+func (req textCompletionRequest) createResponseText(rnd *random, mode string, responses []string, reservedTokens int) ([][]string, []string, int, error) {
 	maxTokens, err := getMaxTokens(nil, req.MaxTokens)
 	if err != nil {
-		return nil, "", 0, err
+		return nil, nil, 0, err
 	}
+	maxTokens = reduceMaxTokens(maxTokens, reservedTokens)
+
+	choicesTokens := make([][]string, 0, len(req.Prompt))
+	finishReasons := make([]string, 0, len(req.Prompt))
+	numberOfTokens := 0
+	for _, p := range req.Prompt {
+		if maxTokens != nil && *maxTokens == 0 {
+			// all the tokens were already generated
+			choicesTokens = append(choicesTokens, nil)
+			finishReasons = append(finishReasons, lengthFinishReason)
+			continue
+		}
 
-	var text, finishReason string
-	if mode == modeEcho {
-		text, finishReason = getResponseText(maxTokens, req.Prompt)
-	} else {
-		text, finishReason = getRandomResponseText(maxTokens)
-	}
+		var text, finishReason string
+		if mode == modeEcho {
+			text, finishReason = getResponseText(maxTokens, p.Text)
+		} else {
+			text, finishReason = getRandomResponseTextFrom(rnd, responses, maxTokens)
+		}
 
-	tokens := tokenize(text)
-	return tokens, finishReason, len(tokens), nil
+		tokens := tokenize(text)
+		choicesTokens = append(choicesTokens, tokens)
+		finishReasons = append(finishReasons, finishReason)
+		numberOfTokens += len(tokens)
+	}
+	return choicesTokens, finishReasons, numberOfTokens, nil
 }
@@ -50,6 +50,9 @@ type baseCompletionResponse struct {
 	RemoteHost string `json:"remote_host"`
 	// RemotePort is a port of the remote server handling prefill
 	RemotePort int `json:"remote_port"`
+	// KVTransferParams contains the parameters of the KV cache transfer of a prefill request, in
+	// vLLM's kv_transfer_params format
+	KVTransferParams *kvTransferParams `json:"kv_transfer_params,omitempty"`
 }
 
 // usage contains token usage statistics
@@ -60,6 +63,14 @@ type usage struct {
 	CompletionTokens int `json:"completion_tokens"`
 	// TotalTokens is the total number of tokens processed for the request (the sum of the two values above)
 	TotalTokens int `json:"total_tokens"`
+	// CompletionTokensDetails is the breakdown of the completion tokens, set in reasoning mode only
+	CompletionTokensDetails *completionTokensDetails `json:"completion_tokens_details,omitempty"`
+}
+
+// completionTokensDetails contains the breakdown of the tokens generated by the model
+type completionTokensDetails struct {
+	// ReasoningTokens is the number of tokens generated by the model for reasoning
+	ReasoningTokens int `json:"reasoning_tokens"`
 }
 
 // chatCompletionResponse defines structure of /chat/completion response
@@ -80,12 +91,18 @@ type baseResponseChoice struct {
 // v1/chat/completion
 // message defines vLLM chat completion message
 type message struct {
-	// Role is the message Role, optional values are 'user', 'assistant', ...
+	// Role is the message Role, optional values are 'system', 'user', 'assistant', 'tool', ...
 	Role string `json:"role,omitempty"`
 	// Content defines text of this message
 	Content content `json:"content,omitempty"`
+	// ReasoningContent is the reasoning text generated by the model before the content
+	ReasoningContent string `json:"reasoning_content,omitempty"`
 	// ToolCalls are the tool calls created by the model
 	ToolCalls []toolCall `json:"tool_calls,omitempty"`
+	// ToolCallID is the ID of the tool call this message is the result of, in messages with role 'tool'
+	ToolCallID string `json:"tool_call_id,omitempty"`
+	// Name is an optional name of the participant
+	Name string `json:"name,omitempty"`
 }
 
 type content struct {
@@ -94,15 +111,31 @@ type content struct {
 }
 
 type contentBlock struct {
-	Type     string     `json:"type"`
-	Text     string     `json:"text,omitempty"`
-	ImageURL ImageBlock `json:"image_url,omitempty"`
+	Type       string     `json:"type"`
+	Text       string     `json:"text,omitempty"`
+	ImageURL   ImageBlock `json:"image_url,omitempty"`
+	InputAudio AudioBlock `json:"input_audio,omitempty"`
+	AudioURL   AudioBlock `json:"audio_url,omitempty"`
+	VideoURL   VideoBlock `json:"video_url,omitempty"`
 }
 
 type ImageBlock struct {
 	Url string `json:"url,omitempty"`
 }
 
+// AudioBlock is an audio in a content block, either base64 encoded data with its format (input_audio)
+// or a URL (audio_url)
+type AudioBlock struct {
+	Data   string `json:"data,omitempty"`
+	Format string `json:"format,omitempty"`
+	Url    string `json:"url,omitempty"`
+}
+
+// VideoBlock is a video URL in a content block
+type VideoBlock struct {
+	Url string `json:"url,omitempty"`
+}
+
 // UnmarshalJSON allow use both format
 func (mc *content) UnmarshalJSON(data []byte) error {
 	// Raw format
@@ -146,6 +179,17 @@ func (mc content) PlainText() string {
 	return sb.String()
 }
 
+// multimodalItems returns the non-text blocks of the content (images, audios and videos)
+func (mc content) multimodalItems() []contentBlock {
+	items := make([]contentBlock, 0)
+	for _, block := range mc.Structured {
+		if getModality(block) != "" {
+			items = append(items, block)
+		}
+	}
+	return items
+}
+
 // functionCall defines a tool call generated by the model including its arguments
 type functionCall struct {
 	// Name is the function's name, can be null in streaming in not the first chunk
@@ -0,0 +1,820 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Contains structures and functions related to the responses API (/v1/responses)
+package llmdinferencesim
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	responseObject         = "response"
+	responseIDPrefix       = "resp_"
+	responseMessageID      = "msg_"
+	responseFunctionCallID = "fc_"
+	responseReasoningID    = "rs_"
+
+	responseStatusCompleted  = "completed"
+	responseStatusIncomplete = "incomplete"
+	responseStatusInProgress = "in_progress"
+
+	responsesItemMessage            = "message"
+	responsesItemFunctionCall       = "function_call"
+	responsesItemFunctionCallOutput = "function_call_output"
+	responsesItemReasoning          = "reasoning"
+
+	responsesPartInputText     = "input_text"
+	responsesPartInputImage    = "input_image"
+	responsesPartOutputText    = "output_text"
+	responsesPartReasoningText = "reasoning_text"
+
+	responsesEventCreated            = "response.created"
+	responsesEventInProgress         = "response.in_progress"
+	responsesEventCompleted          = "response.completed"
+	responsesEventIncomplete         = "response.incomplete"
+	responsesEventOutputItemAdded    = "response.output_item.added"
+	responsesEventOutputItemDone     = "response.output_item.done"
+	responsesEventContentPartAdded   = "response.content_part.added"
+	responsesEventContentPartDone    = "response.content_part.done"
+	responsesEventOutputTextDelta    = "response.output_text.delta"
+	responsesEventOutputTextDone     = "response.output_text.done"
+	responsesEventReasoningTextDelta = "response.reasoning_text.delta"
+	responsesEventReasoningTextDone  = "response.reasoning_text.done"
+	responsesEventArgumentsDelta     = "response.function_call_arguments.delta"
+	responsesEventArgumentsDone      = "response.function_call_arguments.done"
+
+	maxOutputTokensIncompleteReason = "max_output_tokens"
+
+	// defaultMaxStoredResponses is the default maximum number of stored responses
+	defaultMaxStoredResponses = 1000
+)
+
+// responsesRequest defines structure of /v1/responses request
+type responsesRequest struct {
+	// chatCompletionRequest is the chat completion request equivalent to this request,
+	// the response is generated based on it
+	chatCompletionRequest `json:"-"`
+	// Model defines Model name to use for "inference", could be base Model name or one of available LoRA adapters
+	Model string `json:"model"`
+	// Input is the request's input, a text or a list of input items
+	Input responsesInput `json:"input"`
+	// Instructions is a system message inserted before the input
+	Instructions *string `json:"instructions,omitempty"`
+	// MaxOutputTokens is an upper bound for the number of tokens that can be generated,
+	// including reasoning tokens
+	MaxOutputTokens *int64 `json:"max_output_tokens,omitempty"`
+	// Stream is a boolean value, defines whether response should be sent as a Stream of events
+	Stream bool `json:"stream"`
+	// Tools is a list of tools the model may call, only function tools are supported
+	Tools []responsesTool `json:"tools,omitempty"`
+	// ToolChoice controls which (if any) tool is called by the model,
+	// possible values: none, auto, required.
+	ToolChoice string `json:"tool_choice,omitempty"`
+	// PreviousResponseID is the ID of the previous response in the conversation,
+	// its input and output are prepended to the input of this request
+	PreviousResponseID *string `json:"previous_response_id,omitempty"`
+	// Store defines whether the response should be stored for later retrieval, true by default
+	Store *bool `json:"store,omitempty"`
+
+	// inputMessages are the conversation's messages, including the ones of the previous responses,
+	// without the instructions
+	inputMessages []message
+}
+
+// responsesInput is the input of a responses request, either a text or a list of items
+type responsesInput struct {
+	Text  string
+	Items []responsesInputItem
+}
+
+// responsesInputItem is a single item in the input of a responses request
+type responsesInputItem struct {
+	// Type is the item's type: message (default), function_call, function_call_output or reasoning
+	Type string `json:"type,omitempty"`
+	// Role is the role of a message item: user, system, developer or assistant
+	Role string `json:"role,omitempty"`
+	// Content is the content of a message item
+	Content responsesContent `json:"content,omitempty"`
+	// CallID is the ID of the tool call, in function_call and function_call_output items
+	CallID string `json:"call_id,omitempty"`
+	// Name is the function's name in function_call items
+	Name string `json:"name,omitempty"`
+	// Arguments are the function's arguments in function_call items
+	Arguments string `json:"arguments,omitempty"`
+	// Output is the function call's result in function_call_output items
+	Output string `json:"output,omitempty"`
+}
+
+// responsesContent is the content of a message, either a text or a list of content parts
+type responsesContent struct {
+	Raw   string
+	Parts []responsesContentPart
+}
+
+// responsesContentPart is a single part of a message's content in the input or in the output
+type responsesContentPart struct {
+	// Type is the part's type: input_text, input_image, output_text or reasoning_text
+	Type string `json:"type"`
+	// Text is the text of text parts
+	Text string `json:"text"`
+	// ImageURL is the image's URL of input_image parts
+	ImageURL string `json:"image_url,omitempty"`
+	// Annotations are the annotations of output_text parts
+	Annotations *[]any `json:"annotations,omitempty"`
+}
+
+// responsesTool defines a tool in a responses request
+type responsesTool struct {
+	// Type is the tool's type, only function is supported
+	Type string `json:"type"`
+	// Name is the function's name
+	Name string `json:"name"`
+	// Description is the function's description
+	Description string `json:"description,omitempty"`
+	// Parameters are the parameters the function accepts
+	Parameters map[string]any `json:"parameters,omitempty"`
+	// Strict defines whether to enforce strict parameter validation
+	Strict *bool `json:"strict,omitempty"`
+}
+
+// responsesResponse defines structure of /v1/responses response
+type responsesResponse struct {
+	// ID defines the response ID
+	ID string `json:"id"`
+	// Object is the Object type, always "response"
+	Object string `json:"object"`
+	// CreatedAt defines the response creation timestamp
+	CreatedAt int64 `json:"created_at"`
+	// Status is the response's status: completed, incomplete or in_progress
+	Status string `json:"status"`
+	// Model defines the Model name for current request
+	Model string `json:"model"`
+	// Output is the list of items generated by the model
+	Output []responsesOutputItem `json:"output"`
+	// Usage contains the token usage statistics for the request
+	Usage *responsesUsage `json:"usage"`
+	// IncompleteDetails contains the reason the response is incomplete
+	IncompleteDetails *incompleteDetails `json:"incomplete_details"`
+	// Instructions are the request's instructions
+	Instructions *string `json:"instructions"`
+	// MaxOutputTokens is the request's max output tokens
+	MaxOutputTokens *int64 `json:"max_output_tokens"`
+	// PreviousResponseID is the ID of the previous response in the conversation
+	PreviousResponseID *string `json:"previous_response_id"`
+	// Tools are the request's tools
+	Tools []responsesTool `json:"tools"`
+	// ToolChoice is the request's tool choice
+	ToolChoice string `json:"tool_choice"`
+	// ParallelToolCalls defines whether the model can call tools in parallel
+	ParallelToolCalls bool `json:"parallel_tool_calls"`
+	// Store defines whether the response is stored
+	Store bool `json:"store"`
+}
+
+// responsesOutputItem is a single item in the output of a responses response
+type responsesOutputItem struct {
+	// Type is the item's type: message, function_call or reasoning
+	Type string `json:"type"`
+	// ID is the item's ID
+	ID string `json:"id"`
+	// Status is the item's status: completed, incomplete or in_progress
+	Status string `json:"status,omitempty"`
+	// Role is the role of message items, always assistant
+	Role string `json:"role,omitempty"`
+	// Content is the content of message and reasoning items
+	Content []responsesContentPart `json:"content,omitempty"`
+	// CallID is the ID of the tool call in function_call items
+	CallID string `json:"call_id,omitempty"`
+	// Name is the function's name in function_call items
+	Name string `json:"name,omitempty"`
+	// Arguments are the function's arguments in function_call items
+	Arguments *string `json:"arguments,omitempty"`
+}
+
+// responsesUsage contains token usage statistics of a responses response
+type responsesUsage struct {
+	// InputTokens is the number of tokens in the input
+	InputTokens int `json:"input_tokens"`
+	// InputTokensDetails is the breakdown of the input tokens
+	InputTokensDetails inputTokensDetails `json:"input_tokens_details"`
+	// OutputTokens is the number of tokens generated by the model
+	OutputTokens int `json:"output_tokens"`
+	// OutputTokensDetails is the breakdown of the output tokens
+	OutputTokensDetails completionTokensDetails `json:"output_tokens_details"`
+	// TotalTokens is the total number of tokens processed for the request
+	TotalTokens int `json:"total_tokens"`
+}
+
+// inputTokensDetails contains the breakdown of the input tokens
+type inputTokensDetails struct {
+	// CachedTokens is the number of input tokens that were found in the cache
+	CachedTokens int `json:"cached_tokens"`
+}
+
+// incompleteDetails contains the reason a response is incomplete
+type incompleteDetails struct {
+	// Reason is the reason the response is incomplete, e.g. max_output_tokens
+	Reason string `json:"reason"`
+}
+
+// responsesStreamEvent is a single event of a streamed responses response
+type responsesStreamEvent struct {
+	// Type is the event's type, e.g. response.output_text.delta
+	Type string `json:"type"`
+	// SequenceNumber is the event's sequence number in the stream
+	SequenceNumber int `json:"sequence_number"`
+	// Response is the response's state, in response lifecycle events
+	Response *responsesResponse `json:"response,omitempty"`
+	// OutputIndex is the index of the output item the event relates to
+	OutputIndex *int `json:"output_index,omitempty"`
+	// ItemID is the ID of the output item the event relates to
+	ItemID string `json:"item_id,omitempty"`
+	// ContentIndex is the index of the content part the event relates to
+	ContentIndex *int `json:"content_index,omitempty"`
+	// Item is the output item, in output item events
+	Item *responsesOutputItem `json:"item,omitempty"`
+	// Part is the content part, in content part events
+	Part *responsesContentPart `json:"part,omitempty"`
+	// Delta is the generated text, in delta events
+	Delta *string `json:"delta,omitempty"`
+	// Text is the full text, in text done events
+	Text *string `json:"text,omitempty"`
+	// Arguments are the full function's arguments, in function call arguments done events
+	Arguments *string `json:"arguments,omitempty"`
+}
+
+// storedResponse is a response stored for retrieval and for chaining by previous_response_id
+type storedResponse struct {
+	// response is the stored response
+	response *responsesResponse
+	// messages are the conversation's messages, including the response's input and output
+	messages []message
+}
+
+// responsesStore is the in-memory store of /v1/responses API responses
+type responsesStore struct {
+	// lock protects responses and ids
+	lock sync.Mutex
+	// responses are the stored responses, key is the response's ID
+	responses map[string]*storedResponse
+	// ids are the IDs of the stored responses, ordered from the oldest
+	ids []string
+}
+
+// UnmarshalJSON allow use both format
+func (ri *responsesInput) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err == nil {
+		ri.Text = str
+		return nil
+	}
+
+	var items []responsesInputItem
+	if err := json.Unmarshal(data, &items); err == nil {
+		ri.Items = items
+		return nil
+	}
+
+	return errors.New("input format not supported")
+}
+
+// UnmarshalJSON allow use both format
+func (rc *responsesContent) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err == nil {
+		rc.Raw = str
+		return nil
+	}
+
+	var parts []responsesContentPart
+	if err := json.Unmarshal(data, &parts); err == nil {
+		rc.Parts = parts
+		return nil
+	}
+
+	return errors.New("content format not supported")
+}
+
+// toContent converts the responses content to a chat message content
+func (rc responsesContent) toContent() content {
+	if rc.Parts == nil {
+		return content{Raw: rc.Raw}
+	}
+	blocks := make([]contentBlock, 0)
+	for _, part := range rc.Parts {
+		switch part.Type {
+		case responsesPartInputText, responsesPartOutputText:
+			blocks = append(blocks, contentBlock{Type: "text", Text: part.Text})
+		case responsesPartInputImage:
+			blocks = append(blocks, contentBlock{Type: contentTypeImageURL, ImageURL: ImageBlock{Url: part.ImageURL}})
+		}
+	}
+	return content{Structured: blocks}
+}
+
+// toMessages converts the responses input to a list of chat messages
+func (ri *responsesInput) toMessages() ([]message, error) {
+	if ri.Items == nil {
+		return []message{{Role: roleUser, Content: content{Raw: ri.Text}}}, nil
+	}
+
+	messages := make([]message, 0)
+	for _, item := range ri.Items {
+		switch item.Type {
+		case "", responsesItemMessage:
+			messages = append(messages, message{Role: item.Role, Content: item.Content.toContent()})
+		case responsesItemFunctionCall:
+			name := item.Name
+			messages = append(messages, message{
+				Role: roleAssistant,
+				ToolCalls: []toolCall{{
+					ID:       item.CallID,
+					Type:     "function",
+					Function: functionCall{Name: &name, Arguments: item.Arguments},
+				}},
+			})
+		case responsesItemFunctionCallOutput:
+			messages = append(messages, message{Role: roleTool, ToolCallID: item.CallID, Content: content{Raw: item.Output}})
+		case responsesItemReasoning:
+			// reasoning of previous responses is not part of the prompt
+		default:
+			return nil, fmt.Errorf("input item type '%s' is not supported", item.Type)
+		}
+	}
+	return messages, nil
+}
+
+// toTools converts the responses tools to chat completion tools
+func toTools(responsesTools []responsesTool) ([]tool, error) {
+	if responsesTools == nil {
+		return nil, nil
+	}
+	tools := make([]tool, 0, len(responsesTools))
+	for _, rt := range responsesTools {
+		if rt.Type != "function" {
+			return nil, fmt.Errorf("tool type '%s' is not supported", rt.Type)
+		}
+		tools = append(tools, tool{
+			Type:     rt.Type,
+			Function: function{Name: rt.Name, Description: rt.Description, Parameters: rt.Parameters},
+		})
+	}
+	return tools, nil
+}
+
+// isStored returns true if the response to this request should be stored
+func (r *responsesRequest) isStored() bool {
+	return r.Store == nil || *r.Store
+}
+
+// setMessages sets the messages of the equivalent chat completion request: the instructions,
+// if defined, followed by the input messages
+func (r *responsesRequest) setMessages() {
+	r.Messages = make([]message, 0, len(r.inputMessages)+1)
+	if r.Instructions != nil {
+		r.Messages = append(r.Messages, message{Role: "system", Content: content{Raw: *r.Instructions}})
+	}
+	r.Messages = append(r.Messages, r.inputMessages...)
+}
+
+// readResponsesRequest reads and parses data from the body of the given responses request,
+// and creates the equivalent chat completion request
+func (s *VllmSimulator) readResponsesRequest(ctx *fasthttp.RequestCtx) (*responsesRequest, error) {
+	var req responsesRequest
+	if err := json.Unmarshal(ctx.Request.Body(), &req); err != nil {
+		s.logger.Error(err, "failed to unmarshal request body")
+		return nil, err
+	}
+
+	messages, err := req.Input.toMessages()
+	if err != nil {
+		return nil, err
+	}
+	tools, err := toTools(req.Tools)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.validateTools(tools); err != nil {
+		return nil, err
+	}
+
+	req.inputMessages = messages
+	req.chatCompletionRequest = chatCompletionRequest{
+		baseCompletionRequest: baseCompletionRequest{Model: req.Model, Stream: req.Stream},
+		MaxCompletionTokens:   req.MaxOutputTokens,
+		Tools:                 tools,
+		ToolChoice:            req.ToolChoice,
+	}
+	return &req, nil
+}
+
+// HandleResponses http handler for /v1/responses
+func (s *VllmSimulator) HandleResponses(ctx *fasthttp.RequestCtx) {
+	s.logger.Info("responses request received")
+
+	req, err := s.readResponsesRequest(ctx)
+	if err != nil {
+		s.logger.Error(err, "failed to read and parse request body")
+		ctx.Error("Failed to read and parse request body, "+err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+
+	if req.PreviousResponseID != nil {
+		previous, ok := s.loadResponse(*req.PreviousResponseID)
+		if !ok {
+			s.sendCompletionError(ctx, fmt.Sprintf("Response with id '%s' not found.", *req.PreviousResponseID),
+				"NotFoundError", fasthttp.StatusNotFound)
+			return
+		}
+		req.inputMessages = slices.Concat(previous.messages, req.inputMessages)
+	}
+	req.setMessages()
+	req.multimodalTokens = s.countMultimodalTokens(req.getMultimodalItems())
+
+	s.processRequest(ctx, req, true)
+}
+
+// HandleGetResponse http handler for /v1/responses/{response_id}, returns a stored response
+func (s *VllmSimulator) HandleGetResponse(ctx *fasthttp.RequestCtx) {
+	s.logger.Info("get response request received")
+
+	id, _ := ctx.UserValue("response_id").(string)
+	stored, ok := s.loadResponse(id)
+	if !ok {
+		s.sendCompletionError(ctx, fmt.Sprintf("Response with id '%s' not found.", id), "NotFoundError", fasthttp.StatusNotFound)
+		return
+	}
+
+	data, err := json.Marshal(stored.response)
+	if err != nil {
+		ctx.Error("Response body creation failed, "+err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	ctx.Response.Header.SetContentType("application/json")
+	ctx.Response.Header.SetStatusCode(fasthttp.StatusOK)
+	ctx.Response.SetBody(data)
+}
+
+// createResponsesResponse creates the response for a responses request
+// respTokens - tokenized content to be sent in the response
+// reasoningTokens - tokenized reasoning content to be sent in the response
+// toolCalls - tool calls to be sent in the response
+// finishReason - the finish reason of the generation: stop, length or tool_calls
+// usageData - usage (tokens statistics) for this response
+func (s *VllmSimulator) createResponsesResponse(req *responsesRequest, modelName string, respTokens []string,
+	reasoningTokens []string, toolCalls []toolCall, finishReason string, usageData *usage) *responsesResponse {
+	resp := responsesResponse{
+		ID:                 responseIDPrefix + uuid.NewString(),
+		Object:             responseObject,
+		CreatedAt:          time.Now().Unix(),
+		Status:             responseStatusCompleted,
+		Model:              modelName,
+		Output:             []responsesOutputItem{},
+		Instructions:       req.Instructions,
+		MaxOutputTokens:    req.MaxOutputTokens,
+		PreviousResponseID: req.PreviousResponseID,
+		Tools:              req.Tools,
+		ToolChoice:         req.ToolChoice,
+		ParallelToolCalls:  true,
+		Store:              req.isStored(),
+		Usage: &responsesUsage{
+			InputTokens:  usageData.PromptTokens,
+			OutputTokens: usageData.CompletionTokens,
+			TotalTokens:  usageData.TotalTokens,
+		},
+	}
+	if resp.Tools == nil {
+		resp.Tools = []responsesTool{}
+	}
+	if resp.ToolChoice == "" {
+		resp.ToolChoice = toolChoiceAuto
+	}
+	if usageData.CompletionTokensDetails != nil {
+		resp.Usage.OutputTokensDetails = *usageData.CompletionTokensDetails
+	}
+
+	itemStatus := responseStatusCompleted
+	if finishReason == lengthFinishReason {
+		resp.Status = responseStatusIncomplete
+		resp.IncompleteDetails = &incompleteDetails{Reason: maxOutputTokensIncompleteReason}
+		itemStatus = responseStatusIncomplete
+	}
+
+	if len(reasoningTokens) > 0 {
+		resp.Output = append(resp.Output, responsesOutputItem{
+			Type:    responsesItemReasoning,
+			ID:      responseReasoningID + uuid.NewString(),
+			Content: []responsesContentPart{{Type: responsesPartReasoningText, Text: strings.Join(reasoningTokens, "")}},
+		})
+	}
+	if toolCalls != nil {
+		for _, tc := range toolCalls {
+			arguments := tc.Function.Arguments
+			resp.Output = append(resp.Output, responsesOutputItem{
+				Type:      responsesItemFunctionCall,
+				ID:        responseFunctionCallID + uuid.NewString(),
+				Status:    responseStatusCompleted,
+				CallID:    tc.ID,
+				Name:      *tc.Function.Name,
+				Arguments: &arguments,
+			})
+		}
+	} else {
+		resp.Output = append(resp.Output, responsesOutputItem{
+			Type:   responsesItemMessage,
+			ID:     responseMessageID + uuid.NewString(),
+			Status: itemStatus,
+			Role:   roleAssistant,
+			Content: []responsesContentPart{{
+				Type:        responsesPartOutputText,
+				Text:        strings.Join(respTokens, ""),
+				Annotations: &[]any{},
+			}},
+		})
+	}
+
+	return &resp
+}
+
+// inProgress returns a copy of the response with in progress status, before its output was generated
+func (r *responsesResponse) inProgress() *responsesResponse {
+	inProgress := *r
+	inProgress.Status = responseStatusInProgress
+	inProgress.Output = []responsesOutputItem{}
+	inProgress.Usage = nil
+	inProgress.IncompleteDetails = nil
+	return &inProgress
+}
+
+// storeResponse stores the given response with the conversation's messages, including the response's output,
+// to allow its retrieval and chaining by previous_response_id. The response is stored as in progress until
+// completeStoredResponse is called. If max-stored-responses responses are stored, the oldest response is evicted.
+func (s *VllmSimulator) storeResponse(req *responsesRequest, resp *responsesResponse, respTokens []string, toolCalls []toolCall) {
+	outputMessage := message{Role: roleAssistant}
+	if toolCalls != nil {
+		outputMessage.ToolCalls = toolCalls
+	} else {
+		outputMessage.Content = content{Raw: strings.Join(respTokens, "")}
+	}
+
+	store := s.responsesStore
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	store.responses[resp.ID] = &storedResponse{
+		response: resp.inProgress(),
+		messages: append(slices.Clone(req.inputMessages), outputMessage),
+	}
+	store.ids = append(store.ids, resp.ID)
+	for len(store.ids) > s.config.MaxStoredResponses {
+		delete(store.responses, store.ids[0])
+		store.ids = slices.Delete(store.ids, 0, 1)
+	}
+}
+
+// completeStoredResponse replaces the in progress version of the given stored response with the
+// final one, after all of it was sent. Does nothing if the response was evicted in the meantime.
+func (s *VllmSimulator) completeStoredResponse(resp *responsesResponse) {
+	store := s.responsesStore
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	if stored, ok := store.responses[resp.ID]; ok {
+		stored.response = resp
+	}
+}
+
+// loadResponse returns the stored response with the given ID, and false if it is not stored
+func (s *VllmSimulator) loadResponse(id string) (*storedResponse, bool) {
+	store := s.responsesStore
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	stored, ok := store.responses[id]
+	if !ok {
+		return nil, false
+	}
+	// the response of the stored copy is replaced when it is completed
+	loaded := *stored
+	return &loaded, true
+}
+
+// sendResponsesAPIResponse creates and sends the response for a responses request, either as a
+// single response or as a stream of events, and stores it if needed
+// respTokens - tokenized content to be sent in the response
+// reasoningTokens - tokenized reasoning content to be sent in the response
+// toolCalls - tool calls to be sent in the response
+// finishReason - the finish reason of the generation: stop, length or tool_calls
+// usageData - usage (tokens statistics) for this response
+func (s *VllmSimulator) sendResponsesAPIResponse(context *streamingContext, req *responsesRequest, respTokens []string,
+	reasoningTokens []string, toolCalls []toolCall, finishReason string, usageData *usage) {
+	resp := s.createResponsesResponse(req, context.model, respTokens, reasoningTokens, toolCalls, finishReason, usageData)
+	if req.isStored() {
+		s.storeResponse(req, resp, respTokens, toolCalls)
+	}
+
+	if req.Stream {
+		s.sendResponsesStreamingResponse(context, resp, respTokens, reasoningTokens, toolCalls)
+		return
+	}
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		context.ctx.Error("Response body creation failed, "+err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+
+	// calculate how long to wait before returning the response, time is based on number of tokens
+	s.waitForResponseTokens(context.model, context.timing, s.getTimeToFirstToken(context.model, false, context.nMultimodalItems),
+		usageData.CompletionTokens)
+	if req.isStored() {
+		s.completeStoredResponse(resp)
+	}
+
+	context.ctx.Response.Header.SetContentType("application/json")
+	context.ctx.Response.Header.SetStatusCode(fasthttp.StatusOK)
+	context.ctx.Response.SetBody(data)
+
+	s.responseSentCallback(context.model, context.timing)
+}
+
+// responsesStream sends the events of a streamed responses response
+type responsesStream struct {
+	w *bufio.Writer
+	// sequenceNumber is the sequence number of the next event
+	sequenceNumber int
+}
+
+// send sends a single event in SSE format
+func (rs *responsesStream) send(event responsesStreamEvent) error {
+	event.SequenceNumber = rs.sequenceNumber
+	rs.sequenceNumber++
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(rs.w, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+		return err
+	}
+	return rs.w.Flush()
+}
+
+// sendResponsesStreamingResponse sends the given response as a stream of events: the response's creation,
+// the output items with their content deltas, and the response's completion. The deltas are sent
+// according to time to first token and inter token latency
+func (s *VllmSimulator) sendResponsesStreamingResponse(context *streamingContext, resp *responsesResponse, respTokens []string,
+	reasoningTokens []string, toolCalls []toolCall) {
+	context.ctx.SetContentType("text/event-stream")
+	context.ctx.SetStatusCode(fasthttp.StatusOK)
+
+	context.ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+		stream := &responsesStream{w: w}
+
+		inProgress := resp.inProgress()
+		for _, eventType := range []string{responsesEventCreated, responsesEventInProgress} {
+			if err := stream.send(responsesStreamEvent{Type: eventType, Response: inProgress}); err != nil {
+				context.ctx.Error("Sending stream event failed, "+err.Error(), fasthttp.StatusInternalServerError)
+				return
+			}
+		}
+
+		nToolCalls := 0
+		for i := range resp.Output {
+			var err error
+			item := &resp.Output[i]
+			switch item.Type {
+			case responsesItemReasoning:
+				err = s.sendResponsesTextItem(context, stream, i, item, reasoningTokens)
+			case responsesItemMessage:
+				err = s.sendResponsesTextItem(context, stream, i, item, respTokens)
+			case responsesItemFunctionCall:
+				err = s.sendResponsesFunctionCallItem(context, stream, i, item, toolCalls[nToolCalls].Function.tokenizedArguments)
+				nToolCalls++
+			}
+			if err != nil {
+				context.ctx.Error("Sending stream event failed, "+err.Error(), fasthttp.StatusInternalServerError)
+				return
+			}
+		}
+
+		eventType := responsesEventCompleted
+		if resp.Status == responseStatusIncomplete {
+			eventType = responsesEventIncomplete
+		}
+		// the response is completed before the last event, so that a client that got the event finds it completed
+		if resp.Store {
+			s.completeStoredResponse(resp)
+		}
+		if err := stream.send(responsesStreamEvent{Type: eventType, Response: resp}); err != nil {
+			context.ctx.Error("Sending last stream event failed, "+err.Error(), fasthttp.StatusInternalServerError)
+			return
+		}
+		s.responseSentCallback(context.model, context.timing)
+	})
+}
+
+// sendResponsesTextItem sends the events of a message or a reasoning output item, the text is sent
+// as a sequence of delta events, one per token
+func (s *VllmSimulator) sendResponsesTextItem(context *streamingContext, stream *responsesStream, outputIndex int,
+	item *responsesOutputItem, tokens []string) error {
+	isReasoning := item.Type == responsesItemReasoning
+	contentIndex := 0
+	part := item.Content[contentIndex]
+
+	addedItem := *item
+	addedItem.Content = []responsesContentPart{}
+	if !isReasoning {
+		addedItem.Status = responseStatusInProgress
+	}
+	if err := stream.send(responsesStreamEvent{Type: responsesEventOutputItemAdded, OutputIndex: &outputIndex,
+		Item: &addedItem}); err != nil {
+		return err
+	}
+
+	if !isReasoning {
+		addedPart := part
+		addedPart.Text = ""
+		if err := stream.send(responsesStreamEvent{Type: responsesEventContentPartAdded, OutputIndex: &outputIndex,
+			ItemID: item.ID, ContentIndex: &contentIndex, Part: &addedPart}); err != nil {
+			return err
+		}
+	}
+
+	deltaEvent, doneEvent := responsesEventOutputTextDelta, responsesEventOutputTextDone
+	if isReasoning {
+		deltaEvent, doneEvent = responsesEventReasoningTextDelta, responsesEventReasoningTextDone
+	}
+	for _, token := range tokens {
+		s.waitForNextToken(context)
+		if err := stream.send(responsesStreamEvent{Type: deltaEvent, OutputIndex: &outputIndex,
+			ItemID: item.ID, ContentIndex: &contentIndex, Delta: &token}); err != nil {
+			return err
+		}
+	}
+	if err := stream.send(responsesStreamEvent{Type: doneEvent, OutputIndex: &outputIndex,
+		ItemID: item.ID, ContentIndex: &contentIndex, Text: &part.Text}); err != nil {
+		return err
+	}
+
+	if !isReasoning {
+		if err := stream.send(responsesStreamEvent{Type: responsesEventContentPartDone, OutputIndex: &outputIndex,
+			ItemID: item.ID, ContentIndex: &contentIndex, Part: &part}); err != nil {
+			return err
+		}
+	}
+
+	return stream.send(responsesStreamEvent{Type: responsesEventOutputItemDone, OutputIndex: &outputIndex, Item: item})
+}
+
+// sendResponsesFunctionCallItem sends the events of a function call output item, the arguments are sent
+// as a sequence of delta events, one per token
+func (s *VllmSimulator) sendResponsesFunctionCallItem(context *streamingContext, stream *responsesStream, outputIndex int,
+	item *responsesOutputItem, tokens []string) error {
+	noArguments := ""
+	addedItem := *item
+	addedItem.Status = responseStatusInProgress
+	addedItem.Arguments = &noArguments
+	if err := stream.send(responsesStreamEvent{Type: responsesEventOutputItemAdded, OutputIndex: &outputIndex,
+		Item: &addedItem}); err != nil {
+		return err
+	}
+
+	for _, token := range tokens {
+		s.waitForNextToken(context)
+		if err := stream.send(responsesStreamEvent{Type: responsesEventArgumentsDelta, OutputIndex: &outputIndex,
+			ItemID: item.ID, Delta: &token}); err != nil {
+			return err
+		}
+	}
+	if err := stream.send(responsesStreamEvent{Type: responsesEventArgumentsDone, OutputIndex: &outputIndex,
+		ItemID: item.ID, Arguments: item.Arguments}); err != nil {
+		return err
+	}
+
+	return stream.send(responsesStreamEvent{Type: responsesEventOutputItemDone, OutputIndex: &outputIndex, Item: item})
+}
@@ -0,0 +1,309 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+	"github.com/openai/openai-go/packages/param"
+	"github.com/openai/openai-go/responses"
+)
+
+// sendResponsesRequest sends the given responses request and returns the status code and the parsed response
+func sendResponsesRequest(client *http.Client, reqBody string) (int, *responsesResponse) {
+	resp, err := client.Post("http://localhost/v1/responses", "application/json", strings.NewReader(reqBody))
+	Expect(err).NotTo(HaveOccurred())
+	defer func() {
+		err := resp.Body.Close()
+		Expect(err).NotTo(HaveOccurred())
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, nil
+	}
+
+	var responsesResp responsesResponse
+	err = json.NewDecoder(resp.Body).Decode(&responsesResp)
+	Expect(err).NotTo(HaveOccurred())
+	return resp.StatusCode, &responsesResp
+}
+
+var _ = Describe("Responses API", func() {
+	It("Should respond to text input", func() {
+		ctx := context.TODO()
+		client, err := startServer(ctx, modeEcho)
+		Expect(err).NotTo(HaveOccurred())
+
+		openaiclient := openai.NewClient(
+			option.WithBaseURL(baseURL),
+			option.WithHTTPClient(client))
+
+		params := responses.ResponseNewParams{
+			Input: responses.ResponseNewParamsInputUnion{OfString: param.NewOpt(userMessage)},
+			Model: model,
+		}
+		resp, err := openaiclient.Responses.New(ctx, params)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.ID).To(HavePrefix(responseIDPrefix))
+		Expect(string(resp.Status)).To(Equal(responseStatusCompleted))
+		Expect(resp.Model).To(Equal(model))
+		Expect(resp.Output).To(HaveLen(1))
+		Expect(resp.Output[0].Type).To(Equal(responsesItemMessage))
+		Expect(resp.OutputText()).To(Equal(userMessage))
+		Expect(resp.Usage.InputTokens).To(Equal(int64(4)))
+		Expect(resp.Usage.OutputTokens).To(Equal(int64(len(tokenize(userMessage)))))
+		Expect(resp.Usage.TotalTokens).To(Equal(resp.Usage.InputTokens + resp.Usage.OutputTokens))
+	})
+
+	It("Should return an incomplete response when reaching max output tokens", func() {
+		ctx := context.TODO()
+		client, err := startServer(ctx, modeEcho)
+		Expect(err).NotTo(HaveOccurred())
+
+		reqBody := fmt.Sprintf(`{"model": "%s", "input": "%s", "max_output_tokens": 2}`, model, userMessage)
+		status, resp := sendResponsesRequest(client, reqBody)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(resp.Status).To(Equal(responseStatusIncomplete))
+		Expect(resp.IncompleteDetails).NotTo(BeNil())
+		Expect(resp.IncompleteDetails.Reason).To(Equal(maxOutputTokensIncompleteReason))
+		Expect(resp.Usage.OutputTokens).To(Equal(2))
+	})
+
+	It("Should stream text output events", func() {
+		ctx := context.TODO()
+		client, err := startServer(ctx, modeEcho)
+		Expect(err).NotTo(HaveOccurred())
+
+		openaiclient := openai.NewClient(
+			option.WithBaseURL(baseURL),
+			option.WithHTTPClient(client))
+
+		params := responses.ResponseNewParams{
+			Input: responses.ResponseNewParamsInputUnion{OfString: param.NewOpt(userMessage)},
+			Model: model,
+		}
+		stream := openaiclient.Responses.NewStreaming(ctx, params)
+		defer func() {
+			err := stream.Close()
+			Expect(err).NotTo(HaveOccurred())
+		}()
+
+		events := []string{}
+		text := ""
+		var completed responses.Response
+		for stream.Next() {
+			event := stream.Current()
+			events = append(events, event.Type)
+			switch event.Type {
+			case responsesEventOutputTextDelta:
+				text += event.Delta
+			case responsesEventCompleted:
+				completed = event.Response
+			}
+		}
+		Expect(stream.Err()).NotTo(HaveOccurred())
+		Expect(events[0]).To(Equal(responsesEventCreated))
+		Expect(events).To(ContainElement(responsesEventOutputTextDone))
+		Expect(events[len(events)-1]).To(Equal(responsesEventCompleted))
+		Expect(text).To(Equal(userMessage))
+		Expect(completed.OutputText()).To(Equal(userMessage))
+		Expect(completed.Usage.InputTokens).To(Equal(int64(4)))
+	})
+
+	It("Should chain responses by previous response id", func() {
+		ctx := context.TODO()
+		client, err := startServer(ctx, modeEcho)
+		Expect(err).NotTo(HaveOccurred())
+
+		reqBody := fmt.Sprintf(`{"model": "%s", "input": "%s"}`, model, userMessage)
+		status, first := sendResponsesRequest(client, reqBody)
+		Expect(status).To(Equal(http.StatusOK))
+
+		secondMessage := "Second message"
+		reqBody = fmt.Sprintf(`{"model": "%s", "previous_response_id": "%s",
+			"input": [{"role": "user", "content": [{"type": "input_text", "text": "%s"}]}]}`, model, first.ID, secondMessage)
+		status, second := sendResponsesRequest(client, reqBody)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(*second.PreviousResponseID).To(Equal(first.ID))
+		Expect(strings.TrimSpace(second.Output[0].Content[0].Text)).To(Equal(secondMessage))
+		// the input contains the previous input and output
+		Expect(second.Usage.InputTokens).To(Equal(4 + 4 + 2))
+
+		// the stored response can be retrieved
+		resp, err := client.Get("http://localhost/v1/responses/" + second.ID)
+		Expect(err).NotTo(HaveOccurred())
+		defer func() {
+			err := resp.Body.Close()
+			Expect(err).NotTo(HaveOccurred())
+		}()
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		var retrieved responsesResponse
+		err = json.NewDecoder(resp.Body).Decode(&retrieved)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(retrieved.ID).To(Equal(second.ID))
+
+		reqBody = fmt.Sprintf(`{"model": "%s", "input": "%s", "previous_response_id": "resp_unknown"}`, model, userMessage)
+		status, _ = sendResponsesRequest(client, reqBody)
+		Expect(status).To(Equal(http.StatusNotFound))
+	})
+
+	It("Should not store responses when store is false", func() {
+		ctx := context.TODO()
+		client, err := startServer(ctx, modeEcho)
+		Expect(err).NotTo(HaveOccurred())
+
+		reqBody := fmt.Sprintf(`{"model": "%s", "input": "%s", "store": false}`, model, userMessage)
+		status, resp := sendResponsesRequest(client, reqBody)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(resp.Store).To(BeFalse())
+
+		reqBody = fmt.Sprintf(`{"model": "%s", "input": "%s", "previous_response_id": "%s"}`, model, userMessage, resp.ID)
+		status, _ = sendResponsesRequest(client, reqBody)
+		Expect(status).To(Equal(http.StatusNotFound))
+	})
+
+	It("Should evict the oldest responses when the limit is reached", func() {
+		ctx := context.TODO()
+		client, err := startServerWithArgs(ctx, modeEcho, []string{"cmd", "--model", model, "--mode", modeEcho,
+			"--max-stored-responses", "2"})
+		Expect(err).NotTo(HaveOccurred())
+
+		ids := make([]string, 0, 3)
+		for range 3 {
+			reqBody := fmt.Sprintf(`{"model": "%s", "input": "%s"}`, model, userMessage)
+			status, resp := sendResponsesRequest(client, reqBody)
+			Expect(status).To(Equal(http.StatusOK))
+			ids = append(ids, resp.ID)
+		}
+
+		status, _ := sendToRank(client, http.MethodGet, "/v1/responses/"+ids[0], "", "")
+		Expect(status).To(Equal(http.StatusNotFound))
+		for _, id := range ids[1:] {
+			status, _ := sendToRank(client, http.MethodGet, "/v1/responses/"+id, "", "")
+			Expect(status).To(Equal(http.StatusOK))
+		}
+	})
+
+	It("Should store streamed responses as in progress until they are sent", func() {
+		ctx := context.TODO()
+		client, err := startServerWithArgs(ctx, modeEcho, []string{"cmd", "--model", model, "--mode", modeEcho,
+			"--time-to-first-token", "300"})
+		Expect(err).NotTo(HaveOccurred())
+
+		reqBody := fmt.Sprintf(`{"model": "%s", "input": "%s", "stream": true}`, model, userMessage)
+		resp, err := client.Post("http://localhost/v1/responses", "application/json", strings.NewReader(reqBody))
+		Expect(err).NotTo(HaveOccurred())
+		defer func() {
+			err := resp.Body.Close()
+			Expect(err).NotTo(HaveOccurred())
+		}()
+
+		var id string
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			data, found := strings.CutPrefix(scanner.Text(), "data: ")
+			if !found {
+				continue
+			}
+			var event responsesStreamEvent
+			Expect(json.Unmarshal([]byte(data), &event)).To(Succeed())
+			if id == "" {
+				// the first event is sent before the time to first token
+				id = event.Response.ID
+				_, body := sendToRank(client, http.MethodGet, "/v1/responses/"+id, "", "")
+				var stored responsesResponse
+				Expect(json.Unmarshal([]byte(body), &stored)).To(Succeed())
+				Expect(stored.Status).To(Equal(responseStatusInProgress))
+			}
+		}
+		Expect(scanner.Err()).NotTo(HaveOccurred())
+
+		_, body := sendToRank(client, http.MethodGet, "/v1/responses/"+id, "", "")
+		var stored responsesResponse
+		Expect(json.Unmarshal([]byte(body), &stored)).To(Succeed())
+		Expect(stored.Status).To(Equal(responseStatusCompleted))
+		Expect(stored.Output).NotTo(BeEmpty())
+	})
+
+	It("Should share the stored responses between the data parallel ranks", func() {
+		ctx := context.TODO()
+		client, err := startServerWithArgs(ctx, modeEcho, []string{"cmd", "--model", model, "--mode", modeEcho,
+			"--data-parallel-size", "2"})
+		Expect(err).NotTo(HaveOccurred())
+
+		reqBody := fmt.Sprintf(`{"model": "%s", "input": "%s"}`, model, userMessage)
+		status, body := sendToRank(client, http.MethodPost, "/v1/responses", "0", reqBody)
+		Expect(status).To(Equal(http.StatusOK), body)
+		var first responsesResponse
+		Expect(json.Unmarshal([]byte(body), &first)).To(Succeed())
+
+		status, _ = sendToRank(client, http.MethodGet, "/v1/responses/"+first.ID, "1", "")
+		Expect(status).To(Equal(http.StatusOK))
+		reqBody = fmt.Sprintf(`{"model": "%s", "input": "%s", "previous_response_id": "%s"}`, model, userMessage, first.ID)
+		status, body = sendToRank(client, http.MethodPost, "/v1/responses", "1", reqBody)
+		Expect(status).To(Equal(http.StatusOK), body)
+	})
+
+	It("Should call functions and answer function call outputs", func() {
+		ctx := context.TODO()
+		client, err := startServer(ctx, modeEcho)
+		Expect(err).NotTo(HaveOccurred())
+
+		reqBody := fmt.Sprintf(`{"model": "%s", "input": "What is the weather in Paris?", "tool_choice": "required",
+			"tools": [{"type": "function", "name": "get_weather", "parameters": {"type": "object",
+			"properties": {"location": {"type": "string"}}, "required": ["location"]}}]}`, model)
+		status, resp := sendResponsesRequest(client, reqBody)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(resp.Output).NotTo(BeEmpty())
+		item := resp.Output[0]
+		Expect(item.Type).To(Equal(responsesItemFunctionCall))
+		Expect(item.Name).To(Equal("get_weather"))
+		Expect(item.CallID).NotTo(BeEmpty())
+		var args map[string]any
+		err = json.Unmarshal([]byte(*item.Arguments), &args)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(args).To(HaveKey("location"))
+
+		reqBody = fmt.Sprintf(`{"model": "%s", "previous_response_id": "%s",
+			"input": [{"type": "function_call_output", "call_id": "%s", "output": "Sunny"}],
+			"tools": [{"type": "function", "name": "get_weather", "parameters": {"type": "object",
+			"properties": {"location": {"type": "string"}}, "required": ["location"]}}]}`, model, resp.ID, item.CallID)
+		status, resp = sendResponsesRequest(client, reqBody)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(resp.Output).To(HaveLen(1))
+		Expect(resp.Output[0].Type).To(Equal(responsesItemMessage))
+		Expect(resp.Output[0].Content[0].Text).To(Equal("Sunny"))
+	})
+
+	It("Should reject unsupported tools", func() {
+		ctx := context.TODO()
+		client, err := startServer(ctx, modeEcho)
+		Expect(err).NotTo(HaveOccurred())
+
+		reqBody := fmt.Sprintf(`{"model": "%s", "input": "%s", "tools": [{"type": "web_search_preview"}]}`, model, userMessage)
+		status, _ := sendResponsesRequest(client, reqBody)
+		Expect(status).To(Equal(http.StatusBadRequest))
+	})
+})
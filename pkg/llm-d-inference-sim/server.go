@@ -0,0 +1,264 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Embedding of the simulator in Go programs and tests
+package llmdinferencesim
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+// Server is a handle of a simulator that serves a listener, created by Serve
+type Server struct {
+	sim *VllmSimulator
+	// listener is the listener of the simulator's http server
+	listener net.Listener
+	// servers are the simulator's http server, and the metrics server if a metrics port is set
+	servers []*fasthttp.Server
+	// cancel stops the simulator's request processing
+	cancel context.CancelFunc
+	// shutdownOnce makes sure that the servers are shut down once, by Shutdown or when the context
+	// given to Serve is done
+	shutdownOnce sync.Once
+	// shutdownErr is the error of shutting down the servers
+	shutdownErr error
+	// wg waits for the servers to stop
+	wg sync.WaitGroup
+	// errLock protects err
+	errLock sync.Mutex
+	// err is the first error returned by one of the servers
+	err error
+}
+
+// Stats is a snapshot of the state of a simulator, summed over its data parallel ranks
+type Stats struct {
+	// RunningRequests is the number of running requests of each model
+	RunningRequests map[string]int
+	// WaitingRequests is the number of waiting requests of each model
+	WaitingRequests map[string]int
+	// FinishedRequests is the number of requests that finished processing, successfully or not
+	FinishedRequests int64
+	// RunningLoras are the LoRA adapters of the running requests
+	RunningLoras []string
+	// WaitingLoras are the LoRA adapters of the waiting requests
+	WaitingLoras []string
+}
+
+// NewWithConfig creates a new simulator with the given configuration, without parsing the command
+// line. The configuration is validated, LoRA adapters are defined in LoraModulesString. The
+// simulator starts serving requests when Serve is called.
+func NewWithConfig(logger logr.Logger, config *Configuration) (*VllmSimulator, error) {
+	if config == nil {
+		return nil, errors.New("configuration is not set")
+	}
+	simConfig := *config
+	if err := simConfig.unmarshalLoras(); err != nil {
+		return nil, err
+	}
+	if err := simConfig.validate(); err != nil {
+		return nil, err
+	}
+
+	s, err := New(logger)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.initWithConfig(&simConfig); err != nil {
+		return nil, err
+	}
+	if err := s.initDataParallelRanks(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Serve starts processing requests, and serves the given listener in the background. All the data
+// parallel ranks are served by the listener, by the data parallel rank header. If a metrics port
+// is set, the metrics of all the ranks are served on this port. Prefill responses advertise the
+// port of a TCP listener, unless an advertised port is set, for other listeners the advertised port
+// should be set. Returns a handle to stop the simulator. When the given context is done, the
+// simulator is shut down gracefully with the configured drain timeout.
+func (s *VllmSimulator) Serve(ctx context.Context, listener net.Listener) (*Server, error) {
+	if s.config == nil || s.dpRanks == nil {
+		return nil, errors.New("the simulator is not configured, use NewWithConfig")
+	}
+
+	var metricsListener net.Listener
+	if s.config.MetricsPort > 0 {
+		var err error
+		if metricsListener, err = s.newMetricsListener(); err != nil {
+			return nil, err
+		}
+	}
+
+	// all the ranks are reachable at the listener's port
+	if tcpAddr, ok := listener.Addr().(*net.TCPAddr); ok && s.config.AdvertisedPort == 0 {
+		for _, rank := range s.dpRanks {
+			rank.config.AdvertisedPort = tcpAddr.Port
+		}
+	}
+
+	// the requests are processed until they are drained, after the context is done
+	processingCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	for _, rank := range s.dpRanks {
+		rank.startProcessing(processingCtx)
+	}
+
+	srv := &Server{sim: s, listener: listener, cancel: cancel}
+	srv.serve(s.newServer(), listener)
+	if metricsListener != nil {
+		gatherers := make(prometheus.Gatherers, 0, len(s.dpRanks))
+		for _, rank := range s.dpRanks {
+			gatherers = append(gatherers, rank.registry)
+		}
+		srv.serve(s.newMetricsServer(gatherers), metricsListener)
+	}
+
+	srv.wg.Add(1)
+	go func() {
+		defer srv.wg.Done()
+		select {
+		case <-ctx.Done():
+			s.logger.Info("Shutting down", "drain timeout", s.config.DrainTimeout)
+			drainCtx, cancel := context.WithTimeout(context.Background(), time.Duration(s.config.DrainTimeout)*time.Second)
+			defer cancel()
+			srv.shutdown(drainCtx)
+		case <-processingCtx.Done():
+		}
+	}()
+	return srv, nil
+}
+
+// serve serves the given listener with the given http server in the background
+func (srv *Server) serve(server *fasthttp.Server, listener net.Listener) {
+	srv.servers = append(srv.servers, server)
+	srv.wg.Add(1)
+	go func() {
+		defer srv.wg.Done()
+		if err := server.Serve(listener); err != nil {
+			srv.sim.logger.Error(err, "server failed")
+			srv.errLock.Lock()
+			if srv.err == nil {
+				srv.err = err
+			}
+			srv.errLock.Unlock()
+		}
+	}()
+}
+
+// URL returns the base URL of the simulator, e.g., http://localhost:8000. Requests to simulators
+// that serve an in-memory listener must be sent by the client returned by Client.
+func (srv *Server) URL() string {
+	addr := srv.listener.Addr()
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok && tcpAddr.IP.IsUnspecified() {
+		return "http://" + net.JoinHostPort("localhost", strconv.Itoa(tcpAddr.Port))
+	}
+	return "http://" + addr.String()
+}
+
+// Client returns an http client that sends requests to the simulator, requests to in-memory
+// listeners are sent without a network connection
+func (srv *Server) Client() *http.Client {
+	inmemoryListener, ok := srv.listener.(*fasthttputil.InmemoryListener)
+	if !ok {
+		return &http.Client{}
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
+				return inmemoryListener.Dial()
+			},
+		},
+	}
+}
+
+// Stats returns a snapshot of the simulator's state
+func (srv *Server) Stats() Stats {
+	return srv.sim.stats()
+}
+
+// Shutdown stops the simulator gracefully, new requests are rejected, and the running and waiting
+// requests are drained until the given context is done. Returns the first error of the servers.
+func (srv *Server) Shutdown(ctx context.Context) error {
+	srv.shutdown(ctx)
+	srv.wg.Wait()
+
+	if srv.shutdownErr != nil {
+		return srv.shutdownErr
+	}
+	srv.errLock.Lock()
+	defer srv.errLock.Unlock()
+	return srv.err
+}
+
+// shutdown drains the requests and stops the servers and the request processing, only the first
+// call has an effect, later calls wait until it is done
+func (srv *Server) shutdown(ctx context.Context) {
+	srv.shutdownOnce.Do(func() {
+		srv.shutdownErr = srv.sim.shutdownServers(ctx, srv.servers)
+		srv.cancel()
+	})
+}
+
+// stats returns a snapshot of the state of the simulator, summed over its data parallel ranks
+func (s *VllmSimulator) stats() Stats {
+	stats := Stats{
+		RunningRequests: make(map[string]int),
+		WaitingRequests: make(map[string]int),
+		RunningLoras:    make([]string, 0),
+		WaitingLoras:    make([]string, 0),
+	}
+	ranks := s.getEngines()
+	for _, rank := range ranks {
+		rank.metricsLock.Lock()
+		addNonZeroCounts(stats.RunningRequests, rank.runningReqsPerModel)
+		addNonZeroCounts(stats.WaitingRequests, rank.waitingReqsPerModel)
+		rank.metricsLock.Unlock()
+
+		stats.FinishedRequests += atomic.LoadInt64(&rank.nFinishedReqs)
+
+		running, waiting := rank.getRunningAndWaitingLoras()
+		stats.RunningLoras = append(stats.RunningLoras, running...)
+		stats.WaitingLoras = append(stats.WaitingLoras, waiting...)
+	}
+	slices.Sort(stats.RunningLoras)
+	stats.RunningLoras = slices.Compact(stats.RunningLoras)
+	slices.Sort(stats.WaitingLoras)
+	stats.WaitingLoras = slices.Compact(stats.WaitingLoras)
+	return stats
+}
+
+// addNonZeroCounts adds the non-zero counts of the given source to the given destination
+func addNonZeroCounts(dest map[string]int, source map[string]int) {
+	for model, count := range source {
+		if count != 0 {
+			dest[model] += count
+		}
+	}
+}
@@ -0,0 +1,239 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+	"github.com/valyala/fasthttp/fasthttputil"
+	"k8s.io/klog/v2"
+)
+
+// serveWithConfig creates a simulator with the given configuration that serves an in-memory
+// listener, and stops it at the end of the test
+func serveWithConfig(config *Configuration) *Server {
+	s, err := NewWithConfig(klog.Background(), config)
+	Expect(err).NotTo(HaveOccurred())
+	srv, err := s.Serve(context.Background(), fasthttputil.NewInmemoryListener())
+	Expect(err).NotTo(HaveOccurred())
+	DeferCleanup(func() {
+		Expect(srv.Shutdown(context.Background())).To(Succeed())
+	})
+	return srv
+}
+
+// newOpenAIClient returns an OpenAI client of the given simulator
+func newOpenAIClient(srv *Server) openai.Client {
+	return openai.NewClient(
+		option.WithBaseURL(srv.URL()+"/v1"),
+		option.WithHTTPClient(srv.Client()),
+		option.WithMaxRetries(0))
+}
+
+var _ = Describe("Embedded server", func() {
+	params := openai.CompletionNewParams{
+		Prompt: openai.CompletionNewParamsPromptUnion{
+			OfString: openai.String(userMessage),
+		},
+		Model: openai.CompletionNewParamsModel(model),
+	}
+
+	It("Should serve requests and report stats", func() {
+		config := NewConfig()
+		config.Model = model
+		config.Mode = modeEcho
+		srv := serveWithConfig(config)
+
+		client := newOpenAIClient(srv)
+		resp, err := client.Completions.New(context.TODO(), params)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Choices[0].Text).To(Equal(userMessage))
+
+		stats := srv.Stats()
+		Expect(stats.FinishedRequests).To(Equal(int64(1)))
+		Expect(stats.RunningRequests).To(BeEmpty())
+		Expect(stats.WaitingRequests).To(BeEmpty())
+	})
+
+	It("Should report running and waiting requests", func() {
+		config := NewConfig()
+		config.Model = model
+		config.MaxNumSeqs = 1
+		config.TimeToFirstToken = 500
+		config.LoraModulesString = []string{`{"name": "lora1"}`}
+		srv := serveWithConfig(config)
+		client := newOpenAIClient(srv)
+
+		for _, reqModel := range []string{model, "lora1"} {
+			go func() {
+				defer GinkgoRecover()
+				reqParams := params
+				reqParams.Model = openai.CompletionNewParamsModel(reqModel)
+				_, err := client.Completions.New(context.TODO(), reqParams)
+				Expect(err).NotTo(HaveOccurred())
+			}()
+			// make sure the first request is running before the second one is sent
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		stats := srv.Stats()
+		Expect(stats.RunningRequests).To(Equal(map[string]int{model: 1}))
+		Expect(stats.WaitingRequests).To(Equal(map[string]int{"lora1": 1}))
+		Expect(stats.WaitingLoras).To(Equal([]string{"lora1"}))
+		Eventually(func() int64 { return srv.Stats().FinishedRequests }, 3*time.Second).Should(Equal(int64(2)))
+	})
+
+	It("Should run independent deterministic simulators", func() {
+		texts := make([]string, 0, 2)
+		for _, name := range []string{"model1", "model2"} {
+			config := NewConfig()
+			config.Model = name
+			config.Seed = 42
+			srv := serveWithConfig(config)
+
+			reqParams := params
+			reqParams.Model = openai.CompletionNewParamsModel(name)
+			client := newOpenAIClient(srv)
+			resp, err := client.Completions.New(context.TODO(), reqParams)
+			Expect(err).NotTo(HaveOccurred())
+			texts = append(texts, resp.Choices[0].Text)
+		}
+		Expect(texts[0]).To(Equal(texts[1]))
+	})
+
+	It("Should serve a TCP listener", func() {
+		config := NewConfig()
+		config.Model = model
+		config.Mode = modeEcho
+		s, err := NewWithConfig(klog.Background(), config)
+		Expect(err).NotTo(HaveOccurred())
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		srv, err := s.Serve(context.Background(), listener)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(srv.URL()).To(HavePrefix("http://127.0.0.1:"))
+
+		body := `{"model": "` + model + `", "prompt": "` + userMessage + `"}`
+		resp, err := srv.Client().Post(srv.URL()+"/v1/completions", "application/json", strings.NewReader(body))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(resp.Body.Close()).To(Succeed())
+
+		Expect(srv.Shutdown(context.Background())).To(Succeed())
+		_, err = srv.Client().Post(srv.URL()+"/v1/completions", "application/json", strings.NewReader(body))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Should serve the metrics of all the data parallel ranks and advertise the listener's port", func() {
+		// find a free port for the metrics
+		metricsListener, err := net.Listen("tcp4", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		metricsPort := metricsListener.Addr().(*net.TCPAddr).Port
+		Expect(metricsListener.Close()).To(Succeed())
+
+		config := NewConfig()
+		config.Model = model
+		config.Mode = modeEcho
+		config.DataParallelSize = 2
+		config.MetricsPort = metricsPort
+		s, err := NewWithConfig(klog.Background(), config)
+		Expect(err).NotTo(HaveOccurred())
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		srv, err := s.Serve(context.Background(), listener)
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(func() {
+			Expect(srv.Shutdown(context.Background())).To(Succeed())
+		})
+
+		for _, rank := range []string{"0", "1"} {
+			body := `{"model": "` + model + `", "prompt": "` + userMessage + `", "max_tokens": 1, "do_remote_decode": true}`
+			req, err := http.NewRequest(http.MethodPost, srv.URL()+"/v1/completions", strings.NewReader(body))
+			Expect(err).NotTo(HaveOccurred())
+			req.Header.Set(dataParallelRankHeader, rank)
+			resp, err := srv.Client().Do(req)
+			Expect(err).NotTo(HaveOccurred())
+			var prefillResp prefillResponse
+			Expect(json.NewDecoder(resp.Body).Decode(&prefillResp)).To(Succeed())
+			Expect(resp.Body.Close()).To(Succeed())
+			Expect(prefillResp.RemotePort).To(Equal(listener.Addr().(*net.TCPAddr).Port))
+		}
+
+		resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/metrics", metricsPort))
+		Expect(err).NotTo(HaveOccurred())
+		metrics, err := io.ReadAll(resp.Body)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Body.Close()).To(Succeed())
+		for _, rank := range []string{"0", "1"} {
+			Expect(string(metrics)).To(ContainSubstring(`vllm:request_success_total{engine="` + rank + `",finished_reason="length",model_name="my_model"} 1`))
+		}
+	})
+
+	It("Should shut down when the context is done", func() {
+		config := NewConfig()
+		config.Model = model
+		config.Mode = modeEcho
+		s, err := NewWithConfig(klog.Background(), config)
+		Expect(err).NotTo(HaveOccurred())
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		ctx, cancel := context.WithCancel(context.Background())
+		srv, err := s.Serve(ctx, listener)
+		Expect(err).NotTo(HaveOccurred())
+
+		cancel()
+		body := `{"model": "` + model + `", "prompt": "` + userMessage + `"}`
+		Eventually(func() error {
+			resp, err := srv.Client().Post(srv.URL()+"/v1/completions", "application/json", strings.NewReader(body))
+			if err == nil {
+				Expect(resp.Body.Close()).To(Succeed())
+			}
+			return err
+		}, time.Second).Should(HaveOccurred())
+		Expect(srv.Shutdown(context.Background())).To(Succeed())
+	})
+
+	It("Should validate the configuration", func() {
+		_, err := NewWithConfig(klog.Background(), nil)
+		Expect(err).To(HaveOccurred())
+
+		config := NewConfig()
+		_, err = NewWithConfig(klog.Background(), config)
+		Expect(err).To(MatchError(ContainSubstring("model parameter is empty")))
+
+		config.Model = model
+		config.LoraModulesString = []string{"not a JSON string"}
+		_, err = NewWithConfig(klog.Background(), config)
+		Expect(err).To(HaveOccurred())
+
+		s, err := New(klog.Background())
+		Expect(err).NotTo(HaveOccurred())
+		_, err = s.Serve(context.Background(), fasthttputil.NewInmemoryListener())
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,117 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Graceful shutdown, draining of the running and waiting requests
+package llmdinferencesim
+
+import (
+	"context"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	// defaultDrainTimeout is the default time in seconds to wait for the requests to finish
+	// on shutdown
+	defaultDrainTimeout = 30
+	// drainCheckInterval is the interval of checking whether all the requests finished
+	drainCheckInterval = 10 * time.Millisecond
+)
+
+// admitRequest returns false if the simulator is draining, otherwise counts the request as an
+// active request until releaseRequest is called
+func (s *VllmSimulator) admitRequest() bool {
+	s.drainLock.Lock()
+	defer s.drainLock.Unlock()
+
+	if s.draining {
+		return false
+	}
+	s.nActiveReqs++
+	return true
+}
+
+// releaseRequest marks an admitted request as finished
+func (s *VllmSimulator) releaseRequest() {
+	s.drainLock.Lock()
+	defer s.drainLock.Unlock()
+
+	s.nActiveReqs--
+}
+
+// isDraining returns true if the simulator is shutting down
+func (s *VllmSimulator) isDraining() bool {
+	s.drainLock.Lock()
+	defer s.drainLock.Unlock()
+
+	return s.draining
+}
+
+// getActiveRequests returns the number of admitted requests that did not finish yet
+func (s *VllmSimulator) getActiveRequests() int {
+	s.drainLock.Lock()
+	defer s.drainLock.Unlock()
+
+	return s.nActiveReqs
+}
+
+// drain stops admitting new requests to all the data parallel ranks, and waits until the running
+// and waiting requests finish or the given context is done. Returns false if some of the requests
+// did not finish.
+func (s *VllmSimulator) drain(ctx context.Context) bool {
+	ranks := s.getEngines()
+	for _, rank := range ranks {
+		rank.drainLock.Lock()
+		rank.draining = true
+		rank.drainLock.Unlock()
+	}
+	s.logger.Info("Draining requests")
+
+	ticker := time.NewTicker(drainCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		activeReqs := 0
+		for _, rank := range ranks {
+			activeReqs += rank.getActiveRequests()
+		}
+		if activeReqs == 0 {
+			s.logger.Info("All the requests finished")
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			s.logger.Info("Drain timeout expired", "unfinished requests", activeReqs)
+			return false
+		case <-ticker.C:
+		}
+	}
+}
+
+// shutdownServers drains the requests, and then stops the given servers. Open connections are
+// closed when the given context is done.
+func (s *VllmSimulator) shutdownServers(ctx context.Context, servers []*fasthttp.Server) error {
+	s.drain(ctx)
+
+	var shutdownErr error
+	for _, server := range servers {
+		if err := server.ShutdownWithContext(ctx); err != nil && shutdownErr == nil {
+			shutdownErr = err
+		}
+	}
+	return shutdownErr
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/valyala/fasthttp/fasthttputil"
+	"k8s.io/klog/v2"
+)
+
+var _ = Describe("Graceful shutdown", func() {
+	completionBody := `{"model": "` + model + `", "prompt": "Hello world", "max_tokens": 2}`
+
+	// startDrainingSimulator starts a simulator with the given drain timeout and time to first
+	// token, and returns its client, a function that stops it, and a channel of the result of
+	// its start
+	startDrainingSimulator := func(drainTimeout int, timeToFirstToken int) (*http.Client, context.CancelFunc, chan error) {
+		config := NewConfig()
+		config.Model = model
+		config.Mode = modeEcho
+		config.DrainTimeout = drainTimeout
+		config.TimeToFirstToken = timeToFirstToken
+		config.MaxNumSeqs = 1
+		s, err := NewWithConfig(klog.Background(), config)
+		Expect(err).NotTo(HaveOccurred())
+
+		listener := fasthttputil.NewInmemoryListener()
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			done <- s.startRanks(ctx, []net.Listener{listener})
+		}()
+
+		client := &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
+					return listener.Dial()
+				},
+			},
+		}
+		return client, cancel, done
+	}
+
+	// sendInBackground sends a completion request in the background, and returns a channel of
+	// its status code
+	sendInBackground := func(client *http.Client) chan int {
+		status := make(chan int, 1)
+		go func() {
+			defer GinkgoRecover()
+			code, body := sendToRank(client, http.MethodPost, "/v1/completions", "", completionBody)
+			Expect(code).To(Equal(http.StatusOK), body)
+			status <- code
+		}()
+		return status
+	}
+
+	It("Should drain the running and waiting requests", func() {
+		client, stop, done := startDrainingSimulator(10, 300)
+
+		status, _ := sendToRank(client, http.MethodGet, "/ready", "", "")
+		Expect(status).To(Equal(http.StatusOK))
+
+		// one running request and one waiting request
+		running := sendInBackground(client)
+		waiting := sendInBackground(client)
+		time.Sleep(100 * time.Millisecond)
+
+		start := time.Now()
+		stop()
+		Eventually(func() int {
+			status, _ := sendToRank(client, http.MethodGet, "/ready", "", "")
+			return status
+		}).Should(Equal(http.StatusServiceUnavailable))
+		status, body := sendToRank(client, http.MethodPost, "/v1/completions", "", completionBody)
+		Expect(status).To(Equal(http.StatusServiceUnavailable))
+		Expect(body).To(ContainSubstring("The server is shutting down"))
+		status, _ = sendToRank(client, http.MethodGet, "/health", "", "")
+		Expect(status).To(Equal(http.StatusOK))
+
+		Eventually(done, 2*time.Second).Should(Receive(BeNil()))
+		Expect(time.Since(start)).To(BeNumerically(">=", 400*time.Millisecond))
+		Expect(running).To(Receive(Equal(http.StatusOK)))
+		Expect(waiting).To(Receive(Equal(http.StatusOK)))
+
+		// the listener is closed
+		_, err := client.Get("http://localhost/health")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Should stop when the drain timeout expires", func() {
+		client, stop, done := startDrainingSimulator(0, 2000)
+
+		go func() {
+			// the request doesn't finish before the simulator stops
+			resp, err := client.Post("http://localhost/v1/completions", "application/json",
+				strings.NewReader(completionBody))
+			if err == nil {
+				_ = resp.Body.Close()
+			}
+		}()
+		time.Sleep(100 * time.Millisecond)
+
+		start := time.Now()
+		stop()
+		Eventually(done).Should(Receive(BeNil()))
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+	})
+
+	It("Should drain the requests of an embedded simulator", func() {
+		config := NewConfig()
+		config.Model = model
+		config.Mode = modeEcho
+		config.TimeToFirstToken = 300
+		s, err := NewWithConfig(klog.Background(), config)
+		Expect(err).NotTo(HaveOccurred())
+		srv, err := s.Serve(context.Background(), fasthttputil.NewInmemoryListener())
+		Expect(err).NotTo(HaveOccurred())
+
+		running := sendInBackground(srv.Client())
+		time.Sleep(100 * time.Millisecond)
+		Expect(srv.Shutdown(context.Background())).To(Succeed())
+		Expect(running).To(Receive(Equal(http.StatusOK)))
+		Expect(srv.Stats().FinishedRequests).To(Equal(int64(1)))
+	})
+})
@@ -20,6 +20,7 @@ package llmdinferencesim
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"net"
@@ -34,10 +35,8 @@ import (
 	"github.com/google/uuid"
 	vllmapi "github.com/llm-d/llm-d-inference-sim/pkg/vllm-api"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/pflag"
 	"github.com/valyala/fasthttp"
-	"github.com/valyala/fasthttp/fasthttpadaptor"
 	"k8s.io/klog/v2"
 )
 
@@ -46,18 +45,23 @@ const (
 	modeRandom                = "random"
 	modeEcho                  = "echo"
 	chatComplIDPrefix         = "chatcmpl-"
+	requestIDHeader           = "X-Request-Id"
 	stopFinishReason          = "stop"
 	lengthFinishReason        = "length"
 	toolsFinishReason         = "tool_calls"
 	remoteDecodeFinishReason  = "remote_decode"
 	roleAssistant             = "assistant"
 	roleUser                  = "user"
+	roleTool                  = "tool"
 	textCompletionObject      = "text_completion"
 	chatCompletionObject      = "chat.completion"
 	chatCompletionChunkObject = "chat.completion.chunk"
 	toolChoiceNone            = "none"
 	toolChoiceAuto            = "auto"
 	toolChoiceRequired        = "required"
+	toolResultsPolicyText     = "text"
+	toolResultsPolicyRandom   = "random"
+	defaultReasoningTokens    = 20
 )
 
 // VllmSimulator simulates vLLM server supporting OpenAI API
@@ -65,19 +69,81 @@ type VllmSimulator struct {
 	// logger is used for information and errors logging
 	logger logr.Logger
 	// config is the simulator's configuration
-	config *configuration
-	// loraAdaptors contains list of LoRA available adaptors
+	config *Configuration
+	// loraAdaptors contains list of LoRA available adaptors, key is the LoRA's name, value is its loraModule
 	loraAdaptors sync.Map
+	// loraResolver finds unknown LoRA adaptors at request time, nil if LoRA resolving is disabled
+	loraResolver loraResolver
+	// loraUpdateLock serializes loading and unloading of LoRA adaptors
+	loraUpdateLock sync.Mutex
+	// loraLock protects runningLoras, waitingLoras, gpuLoras and cpuLoras
+	loraLock sync.Mutex
 	// runningLoras is a collection of running loras, key of lora's name, value is number of requests using this lora
-	runningLoras sync.Map
-	// waitingLoras will represent collection of loras defined in requests in the queue - Not implemented yet
-	waitingLoras sync.Map
+	runningLoras map[string]int
+	// waitingLoras is a collection of loras of requests in the waiting queue, key of lora's name,
+	// value is number of waiting requests using this lora
+	waitingLoras map[string]int
+	// gpuLoras are the loras loaded in GPU slots, ordered from the least recently used
+	gpuLoras []string
+	// cpuLoras are the loras stored in CPU memory, ordered from the least recently used
+	cpuLoras []string
 	// nRunningReqs is the number of inference requests that are currently being processed
 	nRunningReqs int64
-	// nWaitingReqs is the number of inference requests that are waiting to be processed
-	nWaitingReqs int64
+	// nFinishedReqs is the number of inference requests that finished processing
+	nFinishedReqs int64
+	// drainLock protects draining and nActiveReqs
+	drainLock sync.Mutex
+	// draining is true when the simulator is shutting down, new requests are rejected
+	draining bool
+	// nActiveReqs is the number of admitted inference requests that did not finish yet
+	nActiveReqs int
+	// loading is true while the model is loading on startup
+	loading atomic.Bool
+	// sleepLock protects sleepLevel and sleepID
+	sleepLock sync.Mutex
+	// sleepLevel is the sleep level of the engine, zero if the engine is awake
+	sleepLevel int
+	// sleepID identifies the last sleep request, so that a wake up that started before it
+	// doesn't wake the engine up
+	sleepID int
+	// faultLock protects faultMode and faultID
+	faultLock sync.Mutex
+	// faultMode is the simulated fault mode of the engine
+	faultMode string
+	// faultID identifies the last fault mode change, so that a recovery from an older fault
+	// doesn't override a newer one
+	faultID int
+	// metricsLock protects runningReqsPerModel and waitingReqsPerModel
+	metricsLock sync.Mutex
+	// runningReqsPerModel is the number of running requests of each model, key is the model's
+	// name in the metrics
+	runningReqsPerModel map[string]int
+	// waitingReqsPerModel is the number of waiting requests of each model, key is the model's
+	// name in the metrics
+	waitingReqsPerModel map[string]int
 	// processingTokensCount tracks the total number of tokens being processed by running requests
 	processingTokensCount int64
+	// engineID is the ID of this instance's engine, returned in the KV transfer parameters of
+	// prefill requests
+	engineID string
+	// kvBlocksLock protects kvBlocks and nextKVBlockID
+	kvBlocksLock sync.Mutex
+	// kvBlocks are the KV cache blocks of prefill requests that were not pulled yet by a decode
+	// instance, key is the block's ID, value is its allocation time
+	kvBlocks map[int]time.Time
+	// nextKVBlockID is the ID of the next KV cache block to allocate
+	nextKVBlockID int
+	// rank is the data parallel rank of this engine
+	rank int
+	// dpRanks are the engines of all the data parallel ranks, indexed by rank, nil if data
+	// parallelism is not used
+	dpRanks []*VllmSimulator
+	// random is the random numbers generator of the simulator
+	random *random
+	// handler handles the http requests of this engine
+	handler fasthttp.RequestHandler
+	// registry is the prometheus registry of the simulator's metrics
+	registry *prometheus.Registry
 	// loraInfo is prometheus gauge
 	loraInfo *prometheus.GaugeVec
 	// runningRequests is prometheus gauge
@@ -86,12 +152,43 @@ type VllmSimulator struct {
 	waitingRequests *prometheus.GaugeVec
 	// kvCacheUsagePercentage is prometheus gauge
 	kvCacheUsagePercentage *prometheus.GaugeVec
+	// timeToFirstToken is prometheus histogram of the time to first token
+	timeToFirstToken *prometheus.HistogramVec
+	// timePerOutputToken is prometheus histogram of the time between output tokens
+	timePerOutputToken *prometheus.HistogramVec
+	// e2eRequestLatency is prometheus histogram of the end to end request latency
+	e2eRequestLatency *prometheus.HistogramVec
+	// requestQueueTime is prometheus histogram of the time requests spend in the waiting queue
+	requestQueueTime *prometheus.HistogramVec
+	// requestPrefillTime is prometheus histogram of the prefill time of requests
+	requestPrefillTime *prometheus.HistogramVec
+	// requestDecodeTime is prometheus histogram of the decode time of requests
+	requestDecodeTime *prometheus.HistogramVec
+	// promptTokensTotal is prometheus counter of processed prompt tokens
+	promptTokensTotal *prometheus.CounterVec
+	// generationTokensTotal is prometheus counter of generated tokens
+	generationTokensTotal *prometheus.CounterVec
+	// requestSuccessTotal is prometheus counter of successfully processed requests by finish reason
+	requestSuccessTotal *prometheus.CounterVec
+	// requestPromptTokens is prometheus histogram of the number of prompt tokens of requests
+	requestPromptTokens *prometheus.HistogramVec
+	// requestGenerationTokens is prometheus histogram of the number of generated tokens of requests
+	requestGenerationTokens *prometheus.HistogramVec
+	// requestParamsN is prometheus histogram of the n parameter of requests
+	requestParamsN *prometheus.HistogramVec
+	// requestParamsMaxTokens is prometheus histogram of the max tokens parameter of requests
+	requestParamsMaxTokens *prometheus.HistogramVec
 	// channel for requeasts to be passed to workers
 	reqChan chan *completionReqCtx
 	// channel for processing queue, managed by queue manager
 	processingChan chan *completionReqCtx
 	// schema validator for tools parameters
 	toolsValidator *validator
+	// tokenizer converts token IDs to text, nil if a tokenizer is not configured
+	tokenizer *tokenizer
+	// responsesStore is the in-memory store of /v1/responses API responses, shared by the engines of
+	// all the data parallel ranks
+	responsesStore *responsesStore
 }
 
 // New creates a new VllmSimulator instance with the given logger
@@ -101,10 +198,17 @@ func New(logger logr.Logger) (*VllmSimulator, error) {
 		return nil, fmt.Errorf("failed to create tools validator: %s", err)
 	}
 	return &VllmSimulator{
-		logger:         logger,
-		reqChan:        make(chan *completionReqCtx, 1000),
-		processingChan: make(chan *completionReqCtx, 1000),
-		toolsValidator: toolsValidtor,
+		logger:              logger,
+		runningLoras:        make(map[string]int),
+		waitingLoras:        make(map[string]int),
+		runningReqsPerModel: make(map[string]int),
+		waitingReqsPerModel: make(map[string]int),
+		reqChan:             make(chan *completionReqCtx, 1000),
+		processingChan:      make(chan *completionReqCtx, 1000),
+		toolsValidator:      toolsValidtor,
+		engineID:            uuid.NewString(),
+		kvBlocks:            make(map[int]time.Time),
+		responsesStore:      &responsesStore{responses: make(map[string]*storedResponse)},
 	}, nil
 }
 
@@ -116,11 +220,109 @@ func (s *VllmSimulator) Start(ctx context.Context) error {
 		return err
 	}
 
-	// initialize prometheus metrics
-	err = s.createAndRegisterPrometheus()
+	// create the data parallel ranks, and initialize their prometheus metrics and request handlers
+	if err := s.initDataParallelRanks(); err != nil {
+		return err
+	}
+
+	// create the listeners of all the ranks before starting them, so that the start fails if
+	// one of the ports is not available
+	listeners, err := s.newRankListeners()
 	if err != nil {
 		return err
 	}
+	return s.startRanks(ctx, listeners)
+}
+
+// newRankListeners creates the listeners of all the data parallel ranks, if one of the listeners
+// cannot be created, the listeners that were already created are closed
+func (s *VllmSimulator) newRankListeners() ([]net.Listener, error) {
+	listeners := make([]net.Listener, 0, len(s.dpRanks))
+	for _, rank := range s.dpRanks {
+		listener, err := rank.newListener()
+		if err != nil {
+			closeListeners(listeners)
+			return nil, err
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+// closeListeners closes the given listeners, ignoring errors
+func closeListeners(listeners []net.Listener) {
+	for _, listener := range listeners {
+		_ = listener.Close()
+	}
+}
+
+// startRanks starts the engines of all the data parallel ranks, each rank serves the listener
+// in its index. When the given context is done, the requests are drained and the servers are
+// stopped. Blocks until the servers stop.
+func (s *VllmSimulator) startRanks(ctx context.Context, listeners []net.Listener) error {
+	// the metrics listeners are created before starting the ranks, so that the start fails if
+	// one of the ports is not available
+	metricsListeners := make([]net.Listener, len(s.dpRanks))
+	for i, rank := range s.dpRanks {
+		if rank.config.MetricsPort == 0 {
+			continue
+		}
+		listener, err := rank.newMetricsListener()
+		if err != nil {
+			closeListeners(listeners)
+			for _, created := range metricsListeners {
+				if created != nil {
+					_ = created.Close()
+				}
+			}
+			return err
+		}
+		metricsListeners[i] = listener
+	}
+
+	// the requests are processed until they are drained, after the context is done
+	processingCtx, stopProcessing := context.WithCancel(context.WithoutCancel(ctx))
+	defer stopProcessing()
+
+	servers := make([]*fasthttp.Server, 0, 2*len(s.dpRanks))
+	errChan := make(chan error, 2*len(s.dpRanks))
+	serve := func(server *fasthttp.Server, listener net.Listener) {
+		servers = append(servers, server)
+		go func() {
+			errChan <- server.Serve(listener)
+		}()
+	}
+	for i, rank := range s.dpRanks {
+		rank.startProcessing(processingCtx)
+		if metricsListeners[i] != nil {
+			serve(rank.newMetricsServer(rank.registry), metricsListeners[i])
+		}
+		serve(rank.newServer(), listeners[i])
+	}
+
+	select {
+	case err := <-errChan:
+		// one of the servers stopped unexpectedly, stop the others
+		for _, server := range servers {
+			_ = server.Shutdown()
+		}
+		return err
+	case <-ctx.Done():
+	}
+
+	s.logger.Info("Shutting down", "drain timeout", s.config.DrainTimeout)
+	drainCtx, cancel := context.WithTimeout(context.Background(), time.Duration(s.config.DrainTimeout)*time.Second)
+	defer cancel()
+	if err := s.shutdownServers(drainCtx, servers); err != nil {
+		s.logger.Error(err, "failed to close the open connections")
+	}
+	return nil
+}
+
+// startProcessing runs the queue manager and the request processing workers
+func (s *VllmSimulator) startProcessing(ctx context.Context) {
+	s.startLoading()
+	s.startFaultInjection(ctx)
 
 	// run queue manager that handles request constraints
 	go s.queueManager(ctx)
@@ -129,18 +331,11 @@ func (s *VllmSimulator) Start(ctx context.Context) error {
 	for i := 1; i <= s.config.MaxNumSeqs; i++ {
 		go s.reqProcessingWorker(ctx, i)
 	}
-	listener, err := s.newListener()
-	if err != nil {
-		return err
-	}
-
-	// start the http server
-	return s.startServer(listener)
 }
 
 // parseCommandParamsAndLoadConfig parses and validates command line parameters
 func (s *VllmSimulator) parseCommandParamsAndLoadConfig() error {
-	config := newConfig()
+	config := NewConfig()
 
 	configFileValues := getParamValueFromArgs("config")
 	if len(configFileValues) == 1 {
@@ -155,17 +350,54 @@ func (s *VllmSimulator) parseCommandParamsAndLoadConfig() error {
 	f := pflag.NewFlagSet("llm-d-inference-sim flags", pflag.ContinueOnError)
 
 	f.IntVar(&config.Port, "port", config.Port, "Port")
+	f.IntVar(&config.MetricsPort, "metrics-port", config.MetricsPort, "Port of the /metrics endpoint, if not set the metrics are exposed on the simulator's port")
 	f.StringVar(&config.Model, "model", config.Model, "Currently 'loaded' model")
 	f.IntVar(&config.MaxNumSeqs, "max-num-seqs", config.MaxNumSeqs, "Maximum number of inference requests that could be processed at the same time (parameter to simulate requests waiting queue)")
 	f.IntVar(&config.MaxNumBatchedTokens, "max-num-batched-tokens", config.MaxNumBatchedTokens, "Maximum number of batched tokens per iteration")
 	f.IntVar(&config.MaxLoras, "max-loras", config.MaxLoras, "Maximum number of LoRAs in a single batch")
 	f.IntVar(&config.MaxCPULoras, "max-cpu-loras", config.MaxCPULoras, "Maximum number of LoRAs to store in CPU memory")
+	f.BoolVar(&config.AllowRuntimeLoraUpdating, "allow-runtime-lora-updating", config.AllowRuntimeLoraUpdating, "Enable the /v1/load_lora_adapter and /v1/unload_lora_adapter endpoints")
+	f.StringVar(&config.LoraResolverCacheDir, "lora-resolver-cache-dir", config.LoraResolverCacheDir, "Directory of LoRA adapters that are loaded automatically when requested, the adapter <name> is in <dir>/<name>")
+	f.BoolVar(&config.ValidateLoraPath, "validate-lora-path", config.ValidateLoraPath, "Require LoRA adapter paths to exist and contain an adapter_config.json file")
+	f.IntVar(&config.LoraTokenOverhead, "lora-token-overhead", config.LoraTokenOverhead, "Additional time to generate each token of a LoRA request, for an adapter of rank 16, scaled by the adapter's rank (in milliseconds)")
+	f.IntVar(&config.LoraLoadLatency, "lora-load-latency", config.LoraLoadLatency, "Time to load a LoRA adapter that is not in CPU memory to a GPU slot (in milliseconds)")
+	f.IntVar(&config.LoraSwapLatency, "lora-swap-latency", config.LoraSwapLatency, "Time to move a LoRA adapter from CPU memory to a GPU slot (in milliseconds)")
 	f.IntVar(&config.MaxModelLen, "max-model-len", config.MaxModelLen, "Model's context window, maximum number of tokens in a single request including input and output")
 
+	f.BoolVar(&config.EnableReasoning, "enable-reasoning", config.EnableReasoning, "Simulate a reasoning model, chat completion responses contain reasoning content")
+	f.IntVar(&config.ReasoningTokens, "reasoning-tokens", config.ReasoningTokens, "Number of reasoning tokens generated before the response in reasoning mode")
+	f.IntVar(&config.MaxStoredResponses, "max-stored-responses", config.MaxStoredResponses, "Maximum number of stored /v1/responses responses, the oldest response is evicted when the limit is reached")
+	f.StringVar(&config.ToolResultsPolicy, "tool-results-policy", config.ToolResultsPolicy, "Response policy for chat requests that end with tool results, text - respond with text, random - respond with text or with more tool calls")
+	f.IntVar(&config.MMImageTokens, "mm-image-tokens", config.MMImageTokens, "Number of prompt tokens per image")
+	f.IntVar(&config.MMImagePatchSize, "mm-image-patch-size", config.MMImagePatchSize, "Size in pixels of an image patch, if set, the number of tokens of images in base64 data URLs is the number of patches in the image")
+	f.IntVar(&config.MMAudioTokens, "mm-audio-tokens", config.MMAudioTokens, "Number of prompt tokens per audio")
+	f.IntVar(&config.MMVideoTokens, "mm-video-tokens", config.MMVideoTokens, "Number of prompt tokens per video")
+	f.IntVar(&config.MMItemLatency, "mm-item-latency", config.MMItemLatency, "Time to process one image, audio or video in the prompt, added to the time to first token (in milliseconds)")
+	f.Var(&intMap{values: &config.LimitMMPerPrompt}, "limit-mm-per-prompt", "Maximum number of items of each modality per prompt, a JSON string, e.g. '{\"image\": 2, \"video\": 1}'")
+	f.StringVar(&config.Tokenizer, "tokenizer", config.Tokenizer, "Path to a HuggingFace tokenizer.json file, used to detokenize prompts of token IDs")
 	f.StringVar(&config.Mode, "mode", config.Mode, "Simulator mode, echo - returns the same text that was sent in the request, for chat completion returns the last message, random - returns random sentence from a bank of pre-defined sentences")
 	f.IntVar(&config.InterTokenLatency, "inter-token-latency", config.InterTokenLatency, "Time to generate one token (in milliseconds)")
 	f.IntVar(&config.TimeToFirstToken, "time-to-first-token", config.TimeToFirstToken, "Time to first token (in milliseconds)")
+	f.Float64Var(&config.ErrorRate, "error-rate", config.ErrorRate, "Fraction of requests that fail with an internal server error, between 0 and 1")
+	f.IntVar(&config.DataParallelSize, "data-parallel-size", config.DataParallelSize, "Number of data parallel ranks, rank i listens on port + i")
+	f.IntVar(&config.BlockSize, "block-size", config.BlockSize, "Number of tokens in a KV cache block")
+	f.StringVar(&config.AdvertisedHost, "advertised-host", config.AdvertisedHost, "Host other instances use to reach this simulator in P/D disaggregation")
+	f.IntVar(&config.AdvertisedPort, "advertised-port", config.AdvertisedPort, "Port other instances use to reach this simulator in P/D disaggregation, if not set the simulator's port is used")
+	f.IntVar(&config.KVCacheTransferBandwidth, "kv-cache-transfer-bandwidth", config.KVCacheTransferBandwidth, "Number of KV cache blocks transferred per second from a remote vLLM, zero means no per-block transfer time")
+	f.IntVar(&config.KVTransferTimeout, "kv-transfer-timeout", config.KVTransferTimeout, "Time (in seconds) the KV cache blocks of a prefill request are kept for the decode instance")
 	f.IntVar(&config.KVCacheTransferLatency, "kv-cache-transfer-latency", config.KVCacheTransferLatency, "Time for KV-cache transfer from a remote vLLM (in milliseconds)")
+	f.IntVar(&config.StartupDelay, "startup-delay", config.StartupDelay, "Time to load the model on startup (in milliseconds), if model-size is set, the time to load one GB of the model")
+	f.IntVar(&config.StartupDelayMax, "startup-delay-max", config.StartupDelayMax, "If set, the startup delay is sampled between startup-delay and this value (in milliseconds)")
+	f.Float64Var(&config.ModelSize, "model-size", config.ModelSize, "Size of the model's weights in GB, scales the startup delay")
+	f.BoolVar(&config.EnableSleepMode, "enable-sleep-mode", config.EnableSleepMode, "Enable the /sleep, /wake_up and /is_sleeping endpoints")
+	f.IntVar(&config.WakeUpLatency, "wake-up-latency", config.WakeUpLatency, "Time to wake up from sleep mode (in milliseconds)")
+	f.BoolVar(&config.EnableFaultInjection, "enable-fault-injection", config.EnableFaultInjection, "Enable the /fault endpoint that sets the simulated fault mode")
+	f.StringVar(&config.FaultMode, "fault-mode", config.FaultMode, "Fault mode of scheduled and random faults, unhealthy, engine-dead, stuck or slow")
+	f.IntVar(&config.FaultAfter, "fault-after", config.FaultAfter, "Time (in milliseconds) after startup to enter the fault mode, zero means no scheduled fault")
+	f.IntVar(&config.FaultMTBF, "fault-mtbf", config.FaultMTBF, "Mean time (in milliseconds) between random faults, zero means no random faults")
+	f.IntVar(&config.FaultRecoveryTime, "fault-recovery-time", config.FaultRecoveryTime, "Time (in milliseconds) to recover from scheduled and random faults, zero means no recovery")
+	f.IntVar(&config.FaultTokenLatency, "fault-token-latency", config.FaultTokenLatency, "Time (in milliseconds) added to each token in the slow fault mode")
+	f.IntVar(&config.DrainTimeout, "drain-timeout", config.DrainTimeout, "Time (in seconds) to wait for the running and waiting requests to finish on shutdown")
 	f.Int64Var(&config.Seed, "seed", config.Seed, "Random seed for operations (if not set, current Unix time in nanoseconds is used)")
 
 	// These values were manually parsed above in getParamValueFromArgs, we leave this in order to get these flags in --help
@@ -205,16 +437,39 @@ func (s *VllmSimulator) parseCommandParamsAndLoadConfig() error {
 		return err
 	}
 
+	return s.initWithConfig(config)
+}
+
+// initWithConfig initializes the simulator with the given validated configuration
+func (s *VllmSimulator) initWithConfig(config *Configuration) error {
 	s.config = config
 
 	for _, lora := range config.LoraModules {
-		s.loraAdaptors.Store(lora.Name, "")
+		info, errMsg, _, _ := s.readLoraAdapter(lora.Name, lora.Path)
+		if errMsg != "" {
+			return errors.New(errMsg)
+		}
+		lora.info = info
+		s.loraAdaptors.Store(lora.Name, lora)
+	}
+
+	if config.LoraResolverCacheDir != "" {
+		resolver, err := newFilesystemLoraResolver(config.LoraResolverCacheDir)
+		if err != nil {
+			return err
+		}
+		s.loraResolver = resolver
 	}
 
-	initRandom(s.config.Seed)
+	if config.Tokenizer != "" {
+		tokenizer, err := loadTokenizer(config.Tokenizer)
+		if err != nil {
+			return err
+		}
+		s.tokenizer = tokenizer
+	}
 
-	// just to suppress not used lint error for now
-	_ = &s.waitingLoras
+	s.random = newRandom(s.config.Seed)
 	return nil
 }
 
@@ -252,37 +507,94 @@ func (s *VllmSimulator) newListener() (net.Listener, error) {
 	return listener, nil
 }
 
+func (s *VllmSimulator) newMetricsListener() (net.Listener, error) {
+	s.logger.Info("Metrics server starting", "port", s.config.MetricsPort)
+	listener, err := net.Listen("tcp4", fmt.Sprintf(":%d", s.config.MetricsPort))
+	if err != nil {
+		return nil, err
+	}
+	return listener, nil
+}
+
 // startServer starts http server on port defined in command line
 func (s *VllmSimulator) startServer(listener net.Listener) error {
+	server := s.newServer()
+
+	defer func() {
+		if err := listener.Close(); err != nil {
+			s.logger.Error(err, "server listener close failed")
+		}
+	}()
+
+	return server.Serve(listener)
+}
+
+// newMetricsServer creates the http server of the /metrics endpoint, that exposes the metrics of
+// the given gatherer
+func (s *VllmSimulator) newMetricsServer(gatherer prometheus.Gatherer) *fasthttp.Server {
+	r := fasthttprouter.New()
+	r.GET("/metrics", s.metricsHandler(gatherer))
+
+	return &fasthttp.Server{
+		ErrorHandler: s.HandleError,
+		Handler:      r.Handler,
+		Logger:       s,
+	}
+}
+
+// newServer creates the simulator's http server, requests with the data parallel rank header
+// are passed to the rank's engine
+func (s *VllmSimulator) newServer() *fasthttp.Server {
+	if s.handler == nil {
+		s.handler = s.createRouter().Handler
+	}
+
+	return &fasthttp.Server{
+		ErrorHandler: s.HandleError,
+		Handler:      s.handleDataParallelRequest,
+		Logger:       s,
+	}
+}
+
+// createRouter creates the router of the simulator's http server
+func (s *VllmSimulator) createRouter() *fasthttprouter.Router {
 	r := fasthttprouter.New()
 
 	// support completion APIs
 	r.POST("/v1/chat/completions", s.HandleChatCompletions)
 	r.POST("/v1/completions", s.HandleTextCompletions)
+	// support responses API
+	r.POST("/v1/responses", s.HandleResponses)
+	r.GET("/v1/responses/:response_id", s.HandleGetResponse)
 	// supports /models API
 	r.GET("/v1/models", s.HandleModels)
 	// support load/unload of lora adapter
-	r.POST("/v1/load_lora_adapter", s.HandleLoadLora)
-	r.POST("/v1/unload_lora_adapter", s.HandleUnloadLora)
-	// supports /metrics prometheus API
-	r.GET("/metrics", fasthttpadaptor.NewFastHTTPHandler(promhttp.Handler()))
+	if s.config.AllowRuntimeLoraUpdating {
+		r.POST("/v1/load_lora_adapter", s.HandleLoadLora)
+		r.POST("/v1/unload_lora_adapter", s.HandleUnloadLora)
+	}
+	// supports /metrics prometheus API, unless the metrics are exposed on a separate port
+	if s.config.MetricsPort == 0 {
+		r.GET("/metrics", s.metricsHandler(s.registry))
+	}
+	// supports the side channel of KV cache transfer
+	r.POST(kvTransferPath, s.HandleKVTransfer)
+	// support sleep mode
+	if s.config.EnableSleepMode {
+		r.POST("/sleep", s.HandleSleep)
+		r.POST("/wake_up", s.HandleWakeUp)
+		r.GET("/is_sleeping", s.HandleIsSleeping)
+	}
+	// support fault injection
+	if s.config.EnableFaultInjection {
+		r.POST("/fault", s.HandleSetFault)
+		r.GET("/fault", s.HandleGetFault)
+	}
 	// supports standard Kubernetes health and readiness checks
 	r.GET("/health", s.HandleHealth)
 	r.GET("/ready", s.HandleReady)
 
-	server := fasthttp.Server{
-		ErrorHandler: s.HandleError,
-		Handler:      r.Handler,
-		Logger:       s,
-	}
-
-	defer func() {
-		if err := listener.Close(); err != nil {
-			s.logger.Error(err, "server listener close failed")
-		}
-	}()
-
-	return server.Serve(listener)
+	return r
 }
 
 // Print prints to a log, implementation of fasthttp.Logger
@@ -301,26 +613,51 @@ func (s *VllmSimulator) readRequest(ctx *fasthttp.RequestCtx, isChatCompletion b
 			return nil, err
 		}
 
-		for _, tool := range req.Tools {
-			toolJson, err := json.Marshal(tool.Function)
-			if err != nil {
-				s.logger.Error(err, "failed to marshal request tools")
-				return nil, err
-			}
-			err = s.toolsValidator.validateTool(toolJson)
-			if err != nil {
-				s.logger.Error(err, "tool validation failed")
-				return nil, err
-			}
+		if err := s.validateTools(req.Tools); err != nil {
+			return nil, err
 		}
 
+		req.multimodalTokens = s.countMultimodalTokens(req.getMultimodalItems())
+
 		return &req, nil
 	}
 
 	var req textCompletionRequest
-	err := json.Unmarshal(ctx.Request.Body(), &req)
+	if err := json.Unmarshal(ctx.Request.Body(), &req); err != nil {
+		return nil, err
+	}
 
-	return &req, err
+	// prompts of token IDs are responded in echo mode with their text
+	for i := range req.Prompt {
+		if req.Prompt[i].TokenIDs != nil {
+			req.Prompt[i].Text = s.detokenize(req.Prompt[i].TokenIDs)
+		}
+	}
+
+	return &req, nil
+}
+
+// validateTools validates the definitions of the given tools and compiles their parameters schemas
+func (s *VllmSimulator) validateTools(tools []tool) error {
+	for i := range tools {
+		toolJson, err := json.Marshal(tools[i].Function)
+		if err != nil {
+			s.logger.Error(err, "failed to marshal request tools")
+			return err
+		}
+		err = s.toolsValidator.validateTool(toolJson)
+		if err != nil {
+			s.logger.Error(err, "tool validation failed")
+			return err
+		}
+		// compile the parameters schema, it is used to validate the generated arguments
+		err = tools[i].Function.compileParameters()
+		if err != nil {
+			s.logger.Error(err, "tool parameters schema compilation failed")
+			return err
+		}
+	}
+	return nil
 }
 
 // HandleChatCompletions http handler for /v1/chat/completions
@@ -347,11 +684,22 @@ func (s *VllmSimulator) HandleUnloadLora(ctx *fasthttp.RequestCtx) {
 
 func (s *VllmSimulator) validateRequest(req completionRequest) (string, string, int) {
 	if !s.isValidModel(req.getModel()) {
-		return fmt.Sprintf("The model `%s` does not exist.", req.getModel()), "NotFoundError", fasthttp.StatusNotFound
+		if errMsg, errType, errCode := s.resolveLora(req.getModel()); errMsg != "" {
+			return errMsg, errType, errCode
+		}
+	}
+
+	if textReq, ok := req.(*textCompletionRequest); ok && len(textReq.Prompt) == 0 {
+		return "Either prompt or prompt_embeds must be provided and non-empty.", "BadRequestError",
+			fasthttp.StatusBadRequest
+	}
+
+	if errMsg := s.validateMultimodalLimits(req.getMultimodalItems()); errMsg != "" {
+		return errMsg, "BadRequestError", fasthttp.StatusBadRequest
 	}
 
-	if req.doRemoteDecode() && req.isStream() {
-		return "Prefill does not support streaming", "Invalid request", fasthttp.StatusBadRequest
+	if err := req.validateKVTransferParams(); err != nil {
+		return err.Error(), "BadRequestError", fasthttp.StatusBadRequest
 	}
 
 	return "", "", fasthttp.StatusOK
@@ -384,12 +732,23 @@ func (s *VllmSimulator) isLora(model string) bool {
 	return false
 }
 
-// calculateProcessingTokens calculates the total number of processing tokens for a request
-// Returns prompt tokens + max output tokens, or MaxModelLen if max_tokens is not specified
+// calculateProcessingTokens calculates the total number of processing tokens for a request, the sum of
+// the processing tokens of its prompts. The total is capped by max-num-batched-tokens, so that a request
+// with several prompts that each fit is processed alone, rather than never.
 func (s *VllmSimulator) calculateProcessingTokens(req completionRequest) int {
-	promptTokens := req.getNumberOfPromptTokens()
-	maxCompletionTokens := req.getMaxCompletionTokens()
+	totalTokens := 0
+	for _, promptTokens := range req.getNumberOfTokensPerPrompt() {
+		totalTokens += s.calculatePromptProcessingTokens(promptTokens, req.getMaxCompletionTokens())
+	}
+	if s.config.MaxNumBatchedTokens > 0 {
+		return min(totalTokens, s.config.MaxNumBatchedTokens)
+	}
+	return totalTokens
+}
 
+// calculatePromptProcessingTokens calculates the number of processing tokens for one prompt
+// Returns prompt tokens + max output tokens, or MaxModelLen if max_tokens is not specified
+func (s *VllmSimulator) calculatePromptProcessingTokens(promptTokens int, maxCompletionTokens *int64) int {
 	// If max_tokens is not specified, return the maximum possible tokens (MaxModelLen)
 	if maxCompletionTokens == nil {
 		return s.config.MaxModelLen
@@ -428,12 +787,18 @@ func (s *VllmSimulator) addRunningRequest(reqCtx *completionReqCtx) {
 
 	atomic.AddInt64(&s.processingTokensCount, int64(processingTokens))
 	atomic.AddInt64(&s.nRunningReqs, 1)
+
+	s.updateModelRequests(s.waitingRequests, s.waitingReqsPerModel, reqCtx.model, -1)
+	s.updateModelRequests(s.runningRequests, s.runningReqsPerModel, reqCtx.model, 1)
 }
 
 // removeRunningRequest removes a request from the running requests tracking
 func (s *VllmSimulator) removeRunningRequest(reqCtx *completionReqCtx) {
 	atomic.AddInt64(&s.processingTokensCount, -int64(reqCtx.processingTokens))
 	atomic.AddInt64(&s.nRunningReqs, -1)
+	atomic.AddInt64(&s.nFinishedReqs, 1)
+
+	s.updateModelRequests(s.runningRequests, s.runningReqsPerModel, reqCtx.model, -1)
 }
 
 // handleCompletions general completion requests handler, support both text and chat completion APIs
@@ -445,30 +810,63 @@ func (s *VllmSimulator) handleCompletions(ctx *fasthttp.RequestCtx, isChatComple
 		return
 	}
 
+	s.processRequest(ctx, vllmReq, isChatCompletion)
+}
+
+// getRequestID returns the ID of the given request, which is the value of its X-Request-Id header
+// if set, otherwise a new ID. The ID is returned in the X-Request-Id header of the response.
+func getRequestID(ctx *fasthttp.RequestCtx) string {
+	requestID := string(ctx.Request.Header.Peek(requestIDHeader))
+	if requestID == "" {
+		requestID = chatComplIDPrefix + uuid.NewString()
+	}
+	ctx.Response.Header.Set(requestIDHeader, requestID)
+	return requestID
+}
+
+// processRequest validates the given request, passes it to the processing queue, and waits until
+// it is processed
+func (s *VllmSimulator) processRequest(ctx *fasthttp.RequestCtx, vllmReq completionRequest, isChatCompletion bool) {
+	if s.isLoading() {
+		s.sendCompletionError(ctx, "The model is loading", "ServiceUnavailableError", fasthttp.StatusServiceUnavailable)
+		return
+	}
+	if s.isEngineDead() {
+		s.sendCompletionError(ctx, engineDeadErrorMsg, "EngineDeadError", fasthttp.StatusInternalServerError)
+		return
+	}
+	if !s.admitRequest() {
+		s.sendCompletionError(ctx, "The server is shutting down", "ServiceUnavailableError",
+			fasthttp.StatusServiceUnavailable)
+		return
+	}
+	defer s.releaseRequest()
+
 	errMsg, errType, errCode := s.validateRequest(vllmReq)
 	if errMsg != "" {
 		s.sendCompletionError(ctx, errMsg, errType, errCode)
 		return
 	}
 
-	// Validate context window constraints
-	promptTokens := vllmReq.getNumberOfPromptTokens()
+	// Validate context window constraints, each prompt is validated separately, like in vLLM
 	completionTokens := vllmReq.getMaxCompletionTokens()
-	isValid, actualCompletionTokens, totalTokens := validateContextWindow(promptTokens, completionTokens, s.config.MaxModelLen)
-	if !isValid {
-		s.sendCompletionError(ctx, fmt.Sprintf("This model's maximum context length is %d tokens. However, you requested %d tokens (%d in the messages, %d in the completion). Please reduce the length of the messages or completion",
-			s.config.MaxModelLen, totalTokens, promptTokens, actualCompletionTokens), "BadRequestError", fasthttp.StatusBadRequest)
-		return
-	}
-
-	// Validate max-num-batched-tokens constraint - reject requests that would never be accepted
-	if s.config.MaxNumBatchedTokens > 0 {
-		requestTokens := s.calculateProcessingTokens(vllmReq)
-		if requestTokens > s.config.MaxNumBatchedTokens {
-			s.sendCompletionError(ctx, fmt.Sprintf("Request requires %d tokens, but max-num-batched-tokens is set to %d. This request would never be accepted. Please reduce max_tokens or increase max-num-batched-tokens",
-				requestTokens, s.config.MaxNumBatchedTokens), "BadRequestError", fasthttp.StatusBadRequest)
+	for _, promptTokens := range vllmReq.getNumberOfTokensPerPrompt() {
+		isValid, actualCompletionTokens, totalTokens := validateContextWindow(promptTokens, completionTokens, s.config.MaxModelLen)
+		if !isValid {
+			s.sendCompletionError(ctx, fmt.Sprintf("This model's maximum context length is %d tokens. However, you requested %d tokens (%d in the messages, %d in the completion). Please reduce the length of the messages or completion",
+				s.config.MaxModelLen, totalTokens, promptTokens, actualCompletionTokens), "BadRequestError", fasthttp.StatusBadRequest)
 			return
 		}
+
+		// Validate max-num-batched-tokens constraint - reject requests that would never be accepted
+		if s.config.MaxNumBatchedTokens > 0 {
+			requestTokens := s.calculatePromptProcessingTokens(promptTokens, completionTokens)
+			if requestTokens > s.config.MaxNumBatchedTokens {
+				s.sendCompletionError(ctx, fmt.Sprintf("Request requires %d tokens, but max-num-batched-tokens is set to %d. This request would never be accepted. Please reduce max_tokens or increase max-num-batched-tokens",
+					requestTokens, s.config.MaxNumBatchedTokens), "BadRequestError", fasthttp.StatusBadRequest)
+				return
+			}
+		}
 	}
 
 	var wg sync.WaitGroup
@@ -478,10 +876,14 @@ func (s *VllmSimulator) handleCompletions(ctx *fasthttp.RequestCtx, isChatComple
 		httpReqCtx:       ctx,
 		isChatCompletion: isChatCompletion,
 		wg:               &wg,
+		timing:           &requestTiming{arrival: time.Now(), requestID: getRequestID(ctx)},
+		model:            s.getDisplayedModelName(vllmReq.getModel()),
+	}
+	if s.isLora(vllmReq.getModel()) {
+		s.addWaitingLora(vllmReq.getModel())
 	}
+	s.updateModelRequests(s.waitingRequests, s.waitingReqsPerModel, reqCtx.model, 1)
 	s.reqChan <- reqCtx
-	atomic.StoreInt64(&(s.nWaitingReqs), int64(len(s.reqChan)))
-	s.reportWaitingRequests()
 	wg.Wait()
 }
 
@@ -500,15 +902,17 @@ func (s *VllmSimulator) queueManager(ctx context.Context) {
 			// Add new request to the waiting queue
 			waitingQueue = append(waitingQueue, reqCtx)
 		case <-ticker.C:
-			// Periodically check if we can process waiting requests
-			if len(waitingQueue) == 0 {
+			// Periodically check if we can process waiting requests, requests are not scheduled
+			// while the engine is sleeping
+			if len(waitingQueue) == 0 || s.isSleeping() {
 				continue
 			}
 
 			// Try to process requests from the front of the queue
 			var newQueue []*completionReqCtx
 			for _, reqCtx := range waitingQueue {
-				if s.canAcceptRequest(reqCtx.completionReq) {
+				if s.canAcceptRequest(reqCtx.completionReq) && s.acquireLoraSlot(reqCtx) {
+					reqCtx.timing.scheduled = time.Now()
 					// Add to running requests tracking
 					s.addRunningRequest(reqCtx)
 
@@ -535,49 +939,79 @@ func (s *VllmSimulator) reqProcessingWorker(ctx context.Context, id int) {
 				s.logger.Info("reqProcessingWorker worker exiting: processingChan closed")
 				return
 			}
-			atomic.StoreInt64(&(s.nWaitingReqs), int64(len(s.reqChan)))
-			s.reportWaitingRequests()
-
 			req := reqCtx.completionReq
 			model := req.getModel()
 			displayModel := s.getDisplayedModelName(model)
 
-			if s.isLora(model) {
-				// if current request's model is LoRA, add it to the list of running loras
-				value, ok := s.runningLoras.Load(model)
-				intValue := 0
+			if reqCtx.loraLoadLatency > 0 {
+				// the request's LoRA is loaded to a GPU slot before the request is processed
+				time.Sleep(time.Duration(reqCtx.loraLoadLatency) * time.Millisecond)
+			}
 
-				if !ok {
-					s.logger.Info("Create reference counter", "model", model)
-					intValue = 0
-				} else {
-					intValue = value.(int)
+			if req.doRemotePrefill() {
+				// pull the KV cache of the prompt from the prefill instance
+				if errMsg, errType, errCode := s.pullRemoteKVBlocks(req.getKVTransferParams()); errMsg != "" {
+					s.sendCompletionError(reqCtx.httpReqCtx, errMsg, errType, errCode)
+					s.responseSentCallback(displayModel, reqCtx.timing)
+					s.removeRunningRequest(reqCtx)
+					reqCtx.wg.Done()
+					continue
 				}
-				s.runningLoras.Store(model, intValue+1)
-				s.logger.Info("Update LoRA reference counter", "model", model, "old value", intValue, "new value", intValue+1)
+			}
 
-				// TODO - check if this request went to the waiting queue - add it to waiting map
-				s.reportLoras()
+			// requests to a stuck engine don't progress until it recovers, and requests to a dead
+			// engine fail
+			s.waitWhileStuck()
+			if s.isEngineDead() {
+				s.sendCompletionError(reqCtx.httpReqCtx, engineDeadErrorMsg, "EngineDeadError",
+					fasthttp.StatusInternalServerError)
+				s.responseSentCallback(displayModel, reqCtx.timing)
+				s.removeRunningRequest(reqCtx)
+				reqCtx.wg.Done()
+				continue
 			}
 
-			// Note: we don't increment nRunningReqs here because it's already done in addRunningRequest
-			s.reportRunningRequests()
+			profile := s.getModelProfile(model)
+			if profile.errorRate > 0 && s.random.randomFloat(0, 1) < profile.errorRate {
+				s.sendCompletionError(reqCtx.httpReqCtx, fmt.Sprintf("Simulated failure of a request to model `%s`", displayModel),
+					"InternalServerError", fasthttp.StatusInternalServerError)
+				s.responseSentCallback(displayModel, reqCtx.timing)
+				s.removeRunningRequest(reqCtx)
+				reqCtx.wg.Done()
+				continue
+			}
 
-			var responseTokens []string
-			var finishReason string
+			var responseTokens [][]string
+			var reasoningTokens []string
+			var finishReasons []string
 			var err error
 			var toolCalls []toolCall
 			var completionTokens int
+			if reqCtx.isChatCompletion && s.config.EnableReasoning {
+				// reasoning models generate reasoning before the response
+				reasoningTokens = getReasoningTokens(s.random, s.config.ReasoningTokens, req.getMaxCompletionTokens())
+			}
 			if reqCtx.isChatCompletion &&
 				req.getToolChoice() != toolChoiceNone &&
-				req.getTools() != nil {
+				req.getTools() != nil &&
+				!s.shouldAnswerToolResultsWithText(req) {
+				var finishReason string
 				toolCalls, finishReason, completionTokens, err =
-					createToolCalls(req.getTools(), req.getToolChoice())
+					createToolCalls(s.random, req.getTools(), req.getToolChoice())
+				finishReasons = []string{finishReason}
+				// the tool call arguments are generated from the tools' schemas and cannot be shortened,
+				// so the reasoning gives up the tokens they use
+				if left := reduceMaxTokens(req.getMaxCompletionTokens(), completionTokens); left != nil {
+					reasoningTokens = reasoningTokens[:min(int64(len(reasoningTokens)), *left)]
+				}
 			}
 			if toolCalls == nil && err == nil {
 				// Either no tool calls were defined, or we randomly chose not to create tool calls,
 				// so we generate a response text.
-				responseTokens, finishReason, completionTokens, err = req.createResponseText(s.config.Mode)
+				responseTokens, finishReasons, completionTokens, err = req.createResponseText(s.random, profile.mode, profile.responses, len(reasoningTokens))
+			}
+			if err == nil && len(finishReasons) == 0 {
+				err = errors.New("no choices were generated")
 			}
 			if err != nil {
 				prefix := ""
@@ -588,13 +1022,39 @@ func (s *VllmSimulator) reqProcessingWorker(ctx context.Context, id int) {
 				}
 				s.logger.Error(err, prefix)
 				reqCtx.httpReqCtx.Error(prefix+err.Error(), fasthttp.StatusBadRequest)
+				s.responseSentCallback(displayModel, reqCtx.timing)
 			} else {
+				// reasoning tokens are part of the completion tokens
+				completionTokens += len(reasoningTokens)
 				usageData := usage{
 					PromptTokens:     req.getNumberOfPromptTokens(),
 					CompletionTokens: completionTokens,
 					TotalTokens:      req.getNumberOfPromptTokens() + completionTokens,
 				}
-				if req.isStream() {
+				if reqCtx.isChatCompletion && s.config.EnableReasoning {
+					usageData.CompletionTokensDetails = &completionTokensDetails{ReasoningTokens: len(reasoningTokens)}
+				}
+				s.recordChoicesSuccess(reqCtx.model, req, &usageData, responseTokens, finishReasons)
+				var kvParams *kvTransferParams
+				if req.doRemoteDecode() {
+					// in case this is prefill pod processing, return special finish reason
+					for i := range finishReasons {
+						finishReasons[i] = remoteDecodeFinishReason
+					}
+					kvParams = s.createKVTransferParams(req)
+				}
+				if responsesReq, ok := req.(*responsesRequest); ok {
+					s.sendResponsesAPIResponse(
+						&streamingContext{
+							ctx:              reqCtx.httpReqCtx,
+							isChatCompletion: reqCtx.isChatCompletion,
+							model:            displayModel,
+							nMultimodalItems: len(req.getMultimodalItems()),
+							timing:           reqCtx.timing,
+						},
+						responsesReq, getFirstChoiceTokens(responseTokens), reasoningTokens, toolCalls, finishReasons[0], &usageData,
+					)
+				} else if req.isStream() {
 					var usageDataToSend *usage
 					if req.includeUsage() {
 						usageDataToSend = &usageData
@@ -605,24 +1065,25 @@ func (s *VllmSimulator) reqProcessingWorker(ctx context.Context, id int) {
 							isChatCompletion: reqCtx.isChatCompletion,
 							model:            displayModel,
 							doRemotePrefill:  req.doRemotePrefill(),
+							nMultimodalItems: len(req.getMultimodalItems()),
+							kvParams:         kvParams,
+							timing:           reqCtx.timing,
 						},
-						responseTokens, toolCalls, finishReason, usageDataToSend,
+						responseTokens, reasoningTokens, toolCalls, finishReasons, usageDataToSend,
 					)
 				} else {
-					if req.doRemoteDecode() {
-						// in case this is prefill pod processing, return special finish reason
-						finishReason = remoteDecodeFinishReason
-					}
-
 					s.sendResponse(reqCtx.isChatCompletion,
 						reqCtx.httpReqCtx,
 						responseTokens,
+						reasoningTokens,
 						toolCalls,
 						displayModel,
-						finishReason,
+						finishReasons,
 						&usageData,
-						req.doRemoteDecode(),
-						req.doRemotePrefill())
+						kvParams,
+						req.doRemotePrefill(),
+						len(req.getMultimodalItems()),
+						reqCtx.timing)
 				}
 			}
 
@@ -634,34 +1095,21 @@ func (s *VllmSimulator) reqProcessingWorker(ctx context.Context, id int) {
 	}
 }
 
-// decrease model usage reference number
-func (s *VllmSimulator) responseSentCallback(model string) {
-	// Note: nRunningReqs is now decremented in removeRunningRequest
-	s.reportRunningRequests()
-
-	// Only LoRA models require reference-count handling.
-	if !s.isLora(model) {
-		return
-	}
-
-	value, ok := s.runningLoras.Load(model)
+// shouldAnswerToolResultsWithText returns true if the request ends with results of tool calls,
+// and according to the tool results policy the response should be text and not more tool calls.
+// If tool choice is 'required', the response contains tool calls regardless of the policy.
+func (s *VllmSimulator) shouldAnswerToolResultsWithText(req completionRequest) bool {
+	return s.config.ToolResultsPolicy == toolResultsPolicyText &&
+		req.getToolChoice() != toolChoiceRequired &&
+		req.endsWithToolResults()
+}
 
-	if !ok {
-		s.logger.Info("Error: nil reference counter", "model", model)
-		s.logger.Error(nil, "Zero model reference", "model", model)
-	} else {
-		intValue := value.(int)
-		if intValue > 1 {
-			s.runningLoras.Store(model, intValue-1)
-			s.logger.Info("Update LoRA reference counter", "model", model, "prev value", intValue, "new value", intValue-1)
-		} else {
-			// last lora instance stopped its execution - remove from the map
-			s.runningLoras.Delete(model)
-			s.logger.Info("Remove LoRA from set of running loras", "model", model)
-		}
-	}
+// decrease model usage reference number, and report the latency metrics of the request
+func (s *VllmSimulator) responseSentCallback(model string, timing *requestTiming) {
+	s.recordRequestEnd(model, timing)
 
-	s.reportLoras()
+	// Only LoRA models require reference-count handling.
+	s.releaseLora(model)
 }
 
 // sendCompletionError sends an error response for the current completion request
@@ -707,14 +1155,17 @@ func (s *VllmSimulator) HandleError(_ *fasthttp.RequestCtx, err error) {
 
 // createCompletionResponse creates the response for completion requests, supports both completion request types (text and chat)
 // as defined by isChatCompletion
-// respTokens - tokenized content to be sent in the response
+// respTokens - tokenized content of each choice to be sent in the response, chat completion responses contain
+// a single choice, text completion responses contain a choice per prompt
+// reasoningTokens - tokenized reasoning content to be sent in the response (chat completion only)
 // toolCalls - tool calls to be sent in the response
-// finishReason - a pointer to string that represents finish reason, can be nil or stop or length, ...
+// finishReasons - finish reason of each choice, can be stop or length, ...
 // usageData - usage (tokens statistics) for this response
 // modelName - display name returned to the client and used in metrics. It is either the first alias
 // from --served-model-name (for a base-model request) or the LoRA adapter name (for a LoRA request).
-func (s *VllmSimulator) createCompletionResponse(isChatCompletion bool, respTokens []string, toolCalls []toolCall,
-	finishReason *string, usageData *usage, modelName string, doRemoteDecode bool) completionResponse {
+// kvParams - KV transfer parameters of a prefill request, nil for other requests
+func (s *VllmSimulator) createCompletionResponse(isChatCompletion bool, respTokens [][]string, reasoningTokens []string,
+	toolCalls []toolCall, finishReasons []string, usageData *usage, modelName string, kvParams *kvTransferParams) completionResponse {
 	baseResp := baseCompletionResponse{
 		ID:      chatComplIDPrefix + uuid.NewString(),
 		Created: time.Now().Unix(),
@@ -722,29 +1173,19 @@ func (s *VllmSimulator) createCompletionResponse(isChatCompletion bool, respToke
 		Usage:   usageData,
 	}
 
-	if doRemoteDecode {
-		// add special fields related to the prefill pod special behavior
-		baseResp.DoRemoteDecode = true
-		baseResp.DoRemotePrefill = false
-		// currently remote prefill information is hard-coded
-		baseResp.RemoteBlockIds = []string{"DUMMY_ID"}
-		baseResp.RemoteEngineId = "DUMMY_ID"
-		baseResp.RemoteHost = "DUMMY"
-		baseResp.RemotePort = 1234
-	}
-
-	baseChoice := baseResponseChoice{Index: 0, FinishReason: finishReason}
+	// add special fields related to the prefill pod special behavior
+	setKVTransferParams(&baseResp, kvParams)
 
-	respText := strings.Join(respTokens, "")
 	if isChatCompletion {
 		baseResp.Object = chatCompletionObject
 
-		message := message{Role: roleAssistant}
+		message := message{Role: roleAssistant, ReasoningContent: strings.Join(reasoningTokens, "")}
 		if toolCalls != nil {
 			message.ToolCalls = toolCalls
 		} else {
-			message.Content = content{Raw: respText}
+			message.Content = content{Raw: strings.Join(getFirstChoiceTokens(respTokens), "")}
 		}
+		baseChoice := baseResponseChoice{Index: 0, FinishReason: &finishReasons[0]}
 		return &chatCompletionResponse{
 			baseCompletionResponse: baseResp,
 			Choices:                []chatRespChoice{{Message: message, baseResponseChoice: baseChoice}},
@@ -752,23 +1193,33 @@ func (s *VllmSimulator) createCompletionResponse(isChatCompletion bool, respToke
 	}
 
 	baseResp.Object = textCompletionObject
+	choices := make([]textRespChoice, 0, len(respTokens))
+	for i, tokens := range respTokens {
+		baseChoice := baseResponseChoice{Index: i, FinishReason: &finishReasons[i]}
+		choices = append(choices, textRespChoice{baseResponseChoice: baseChoice, Text: strings.Join(tokens, "")})
+	}
 	return &textCompletionResponse{
 		baseCompletionResponse: baseResp,
-		Choices:                []textRespChoice{{baseResponseChoice: baseChoice, Text: respText}},
+		Choices:                choices,
 	}
 }
 
 // sendResponse sends response for completion API, supports both completions (text and chat)
 // according the value of isChatCompletion
-// respTokens - tokenized content to be sent in the response
+// respTokens - tokenized content of each choice to be sent in the response
+// reasoningTokens - tokenized reasoning content to be sent in the response
 // toolCalls - tool calls to be sent in the response
 // modelName - display name returned to the client and used in metrics. It is either the first alias
 // from --served-model-name (for a base-model request) or the LoRA adapter name (for a LoRA request).
-// finishReason - a pointer to string that represents finish reason, can be nil, stop, length, or tools
+// finishReasons - finish reason of each choice, can be stop, length, or tools
 // usageData - usage (tokens statistics) for this response
-func (s *VllmSimulator) sendResponse(isChatCompletion bool, ctx *fasthttp.RequestCtx, respTokens []string, toolCalls []toolCall,
-	modelName string, finishReason string, usageData *usage, doRemoteDecode bool, doRemotePrefill bool) {
-	resp := s.createCompletionResponse(isChatCompletion, respTokens, toolCalls, &finishReason, usageData, modelName, doRemoteDecode)
+// kvParams - KV transfer parameters of a prefill request, nil for other requests
+// nMultimodalItems - number of images, audios and videos in the prompt, each one adds to the time to first token
+func (s *VllmSimulator) sendResponse(isChatCompletion bool, ctx *fasthttp.RequestCtx, respTokens [][]string, reasoningTokens []string,
+	toolCalls []toolCall, modelName string, finishReasons []string, usageData *usage, kvParams *kvTransferParams, doRemotePrefill bool,
+	nMultimodalItems int, timing *requestTiming) {
+	resp := s.createCompletionResponse(isChatCompletion, respTokens, reasoningTokens, toolCalls, finishReasons, usageData,
+		modelName, kvParams)
 
 	data, err := json.Marshal(resp)
 	if err != nil {
@@ -778,28 +1229,103 @@ func (s *VllmSimulator) sendResponse(isChatCompletion bool, ctx *fasthttp.Reques
 
 	// calculate how long to wait before returning the response, time is based on number of tokens
 	numOfTokens := usageData.CompletionTokens
-	totalMillisToWait := s.getTimeToFirstToken(doRemotePrefill) + (numOfTokens-1)*s.config.InterTokenLatency
-	time.Sleep(time.Duration(totalMillisToWait) * time.Millisecond)
+	if len(respTokens) > 1 {
+		// the prompts are processed in parallel, the time is based on the longest choice
+		numOfTokens = 0
+		for _, tokens := range respTokens {
+			numOfTokens = max(numOfTokens, len(tokens))
+		}
+	}
+	s.waitForResponseTokens(modelName, timing, s.getTimeToFirstToken(modelName, doRemotePrefill, nMultimodalItems), numOfTokens)
 
 	// TODO - maybe add pod id to response header for testing
 	ctx.Response.Header.SetContentType("application/json")
 	ctx.Response.Header.SetStatusCode(fasthttp.StatusOK)
 	ctx.Response.SetBody(data)
 
-	s.responseSentCallback(modelName)
+	s.responseSentCallback(modelName, timing)
 }
 
-// returns time to first token based on the current request's doRemotePrefill
-func (s *VllmSimulator) getTimeToFirstToken(doRemotePrefill bool) int {
+// waitForResponseTokens sleeps for the time to first token and for the inter token latency of each
+// one of the other tokens of a non-streaming response, and records the tokens' timing
+func (s *VllmSimulator) waitForResponseTokens(model string, timing *requestTiming, timeToFirstToken int, numOfTokens int) {
+	time.Sleep(time.Duration(timeToFirstToken) * time.Millisecond)
+	s.recordFirstToken(model, timing)
+	if numOfTokens > 1 {
+		time.Sleep(time.Duration((numOfTokens-1)*s.getInterTokenLatency(model)) * time.Millisecond)
+		s.recordOutputTokens(model, timing, numOfTokens-1)
+	}
+}
+
+// modelProfile defines the latencies and responses of requests to a model, LoRA adapters
+// can override the base model's profile
+type modelProfile struct {
+	// timeToFirstToken is the time to first token, in milliseconds
+	timeToFirstToken int
+	// interTokenLatency is the time between generated tokens, in milliseconds
+	interTokenLatency int
+	// mode is the response generation mode
+	mode string
+	// responses are the sentences of the random mode, if empty the pre-defined sentences are used
+	responses []string
+	// errorRate is the fraction of requests that fail
+	errorRate float64
+}
+
+// getModelProfile returns the profile of the given model, the profile of a LoRA is the base
+// model's profile with the LoRA's overrides
+func (s *VllmSimulator) getModelProfile(model string) modelProfile {
+	profile := modelProfile{
+		timeToFirstToken:  s.config.TimeToFirstToken,
+		interTokenLatency: s.config.InterTokenLatency,
+		mode:              s.config.Mode,
+		errorRate:         s.config.ErrorRate,
+	}
+	module, ok := s.getLoraModule(model)
+	if !ok {
+		return profile
+	}
+	if module.TimeToFirstToken != nil {
+		profile.timeToFirstToken = *module.TimeToFirstToken
+	}
+	if module.InterTokenLatency != nil {
+		profile.interTokenLatency = *module.InterTokenLatency
+	}
+	if module.Mode != "" {
+		profile.mode = module.Mode
+	}
+	profile.responses = module.Responses
+	if module.ErrorRate != nil {
+		profile.errorRate = *module.ErrorRate
+	}
+	// generation of LoRA tokens has an additional overhead that depends on the LoRA's rank
+	profile.interTokenLatency += s.scaleByLoraRank(s.config.LoraTokenOverhead, model)
+	return profile
+}
+
+// getInterTokenLatency returns the time to generate one token of the given model, a slow engine
+// adds the fault token latency
+func (s *VllmSimulator) getInterTokenLatency(model string) int {
+	return s.getModelProfile(model).interTokenLatency + s.getFaultTokenLatency()
+}
+
+// returns time to first token of the given model based on the current request's doRemotePrefill,
+// when prefill is done locally, processing of each multimodal item adds to the time to first token,
+// a slow engine adds the fault token latency
+func (s *VllmSimulator) getTimeToFirstToken(model string, doRemotePrefill bool, nMultimodalItems int) int {
 	if doRemotePrefill {
-		return s.config.KVCacheTransferLatency
+		return s.config.KVCacheTransferLatency + s.getFaultTokenLatency()
 	}
-	return s.config.TimeToFirstToken
+	return s.getModelProfile(model).timeToFirstToken + nMultimodalItems*s.config.MMItemLatency + s.getFaultTokenLatency()
 }
 
 // createModelsResponse creates and returns ModelResponse for the current state, returned array of models contains the base model + LoRA adapters if exist
 func (s *VllmSimulator) createModelsResponse() *vllmapi.ModelsResponse {
 	modelsResp := vllmapi.ModelsResponse{Object: "list", Data: []vllmapi.ModelsResponseModelInfo{}}
+	if s.isLoading() {
+		// no models are served until the model is loaded
+		return &modelsResp
+	}
 
 	// Advertise every public model alias
 	for _, alias := range s.config.ServedModelNames {
@@ -814,15 +1340,28 @@ func (s *VllmSimulator) createModelsResponse() *vllmapi.ModelsResponse {
 	}
 
 	// add LoRA adapter's info
-	parent := s.config.ServedModelNames[0]
 	for _, lora := range s.getLoras() {
+		parent := s.config.ServedModelNames[0]
+		root := lora
+		var info *vllmapi.LoraAdapterInfo
+		if module, ok := s.getLoraModule(lora); ok {
+			if module.BaseModelName != "" {
+				parent = module.BaseModelName
+			}
+			if module.Path != "" {
+				// like in vLLM, the root of an adapter is its path
+				root = module.Path
+			}
+			info = module.info
+		}
 		modelsResp.Data = append(modelsResp.Data, vllmapi.ModelsResponseModelInfo{
-			ID:      lora,
-			Object:  vllmapi.ObjectModel,
-			Created: time.Now().Unix(),
-			OwnedBy: "vllm",
-			Root:    lora,
-			Parent:  &parent,
+			ID:       lora,
+			Object:   vllmapi.ObjectModel,
+			Created:  time.Now().Unix(),
+			OwnedBy:  "vllm",
+			Root:     root,
+			Parent:   &parent,
+			LoraInfo: info,
 		})
 	}
 
@@ -833,7 +1372,11 @@ func (s *VllmSimulator) createModelsResponse() *vllmapi.ModelsResponse {
 func (s *VllmSimulator) HandleHealth(ctx *fasthttp.RequestCtx) {
 	s.logger.V(4).Info("health request received")
 	ctx.Response.Header.SetContentType("application/json")
-	ctx.Response.Header.SetStatusCode(fasthttp.StatusOK)
+	if s.isHealthy() {
+		ctx.Response.Header.SetStatusCode(fasthttp.StatusOK)
+	} else {
+		ctx.Response.Header.SetStatusCode(fasthttp.StatusInternalServerError)
+	}
 	ctx.Response.SetBody([]byte("{}"))
 }
 
@@ -841,7 +1384,11 @@ func (s *VllmSimulator) HandleHealth(ctx *fasthttp.RequestCtx) {
 func (s *VllmSimulator) HandleReady(ctx *fasthttp.RequestCtx) {
 	s.logger.V(4).Info("readiness request received")
 	ctx.Response.Header.SetContentType("application/json")
-	ctx.Response.Header.SetStatusCode(fasthttp.StatusOK)
+	if s.isLoading() || s.isDraining() || !s.isHealthy() {
+		ctx.Response.Header.SetStatusCode(fasthttp.StatusServiceUnavailable)
+	} else {
+		ctx.Response.Header.SetStatusCode(fasthttp.StatusOK)
+	}
 	ctx.Response.SetBody([]byte("{}"))
 }
 
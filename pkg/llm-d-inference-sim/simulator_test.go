@@ -18,12 +18,14 @@ package llmdinferencesim
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
 
 	. "github.com/onsi/ginkgo/v2"
@@ -44,6 +46,23 @@ func startServer(ctx context.Context, mode string) (*http.Client, error) {
 }
 
 func startServerWithArgs(ctx context.Context, mode string, args []string) (*http.Client, error) {
+	listener := fasthttputil.NewInmemoryListener()
+	if _, err := startSimulatorWithListener(ctx, mode, args, listener); err != nil {
+		return nil, err
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
+				return listener.Dial()
+			},
+		},
+	}, nil
+}
+
+// startSimulatorWithListener starts a simulator with the given command line arguments, that serves
+// the given listener
+func startSimulatorWithListener(ctx context.Context, mode string, args []string, listener net.Listener) (*VllmSimulator, error) {
 	oldArgs := os.Args
 	defer func() {
 		os.Args = oldArgs
@@ -65,15 +84,16 @@ func startServerWithArgs(ctx context.Context, mode string, args []string) (*http
 		return nil, err
 	}
 
-	// run queue manager that handles request constraints
-	go s.queueManager(ctx)
-
-	// run request processing workers
-	for i := 1; i <= s.config.MaxNumSeqs; i++ {
-		go s.reqProcessingWorker(ctx, i)
+	// initialize the data parallel ranks, their prometheus metrics and request handlers
+	if err := s.initDataParallelRanks(); err != nil {
+		return nil, err
 	}
 
-	listener := fasthttputil.NewInmemoryListener()
+	// run the queue manager and the request processing workers of all the ranks, requests are
+	// passed to the other ranks by the rank header
+	for _, rank := range s.dpRanks {
+		rank.startProcessing(ctx)
+	}
 
 	// start the http server
 	go func() {
@@ -82,13 +102,7 @@ func startServerWithArgs(ctx context.Context, mode string, args []string) (*http
 		}
 	}()
 
-	return &http.Client{
-		Transport: &http.Transport{
-			DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
-				return listener.Dial()
-			},
-		},
-	}, nil
+	return s, nil
 }
 
 var _ = Describe("Simulator", func() {
@@ -386,6 +400,237 @@ var _ = Describe("Simulator", func() {
 		Expect(resp.StatusCode).To(Equal(http.StatusOK))
 	})
 
+	Context("reasoning", func() {
+		DescribeTable("chat completions with reasoning",
+			func(maxTokens int, expectedReasoningTokens int, expectedFinishReason string) {
+				ctx := context.TODO()
+				args := []string{"cmd", "--model", model, "--mode", modeEcho, "--enable-reasoning", "--reasoning-tokens", "10"}
+				client, err := startServerWithArgs(ctx, modeEcho, args)
+				Expect(err).NotTo(HaveOccurred())
+
+				reqBody := fmt.Sprintf(`{
+					"messages": [{"role": "user", "content": "%s"}],
+					"model": "%s",
+					"max_completion_tokens": %d
+				}`, userMessage, model, maxTokens)
+				resp, err := client.Post("http://localhost/v1/chat/completions", "application/json", strings.NewReader(reqBody))
+				Expect(err).NotTo(HaveOccurred())
+				defer func() {
+					err := resp.Body.Close()
+					Expect(err).NotTo(HaveOccurred())
+				}()
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+				var chatResp chatCompletionResponse
+				err = json.NewDecoder(resp.Body).Decode(&chatResp)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(chatResp.Choices).To(HaveLen(1))
+				choice := chatResp.Choices[0]
+				Expect(tokenize(choice.Message.ReasoningContent)).To(HaveLen(expectedReasoningTokens))
+				Expect(*choice.FinishReason).To(Equal(expectedFinishReason))
+				if expectedFinishReason == stopFinishReason {
+					Expect(choice.Message.Content.PlainText()).To(Equal(userMessage))
+				}
+
+				Expect(chatResp.Usage.CompletionTokensDetails).NotTo(BeNil())
+				Expect(chatResp.Usage.CompletionTokensDetails.ReasoningTokens).To(Equal(expectedReasoningTokens))
+				Expect(chatResp.Usage.CompletionTokens).To(BeNumerically("<=", maxTokens))
+				Expect(chatResp.Usage.CompletionTokens).To(BeNumerically(">=", expectedReasoningTokens))
+			},
+			func(maxTokens int, expectedReasoningTokens int, expectedFinishReason string) string {
+				return fmt.Sprintf("max_completion_tokens: %d", maxTokens)
+			},
+			Entry(nil, 100, 10, stopFinishReason),
+			Entry(nil, 12, 10, lengthFinishReason),
+			Entry(nil, 10, 10, lengthFinishReason),
+			Entry(nil, 5, 5, lengthFinishReason),
+		)
+
+		It("Should stream reasoning before content", func() {
+			ctx := context.TODO()
+			args := []string{"cmd", "--model", model, "--mode", modeEcho, "--enable-reasoning", "--reasoning-tokens", "10"}
+			client, err := startServerWithArgs(ctx, modeEcho, args)
+			Expect(err).NotTo(HaveOccurred())
+
+			reqBody := fmt.Sprintf(`{
+				"messages": [{"role": "user", "content": "%s"}],
+				"model": "%s",
+				"stream": true,
+				"stream_options": {"include_usage": true}
+			}`, userMessage, model)
+			resp, err := client.Post("http://localhost/v1/chat/completions", "application/json", strings.NewReader(reqBody))
+			Expect(err).NotTo(HaveOccurred())
+			defer func() {
+				err := resp.Body.Close()
+				Expect(err).NotTo(HaveOccurred())
+			}()
+			body, err := io.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+
+			reasoningChunks := 0
+			var content string
+			var usageData *usage
+			for _, line := range strings.Split(string(body), "\n") {
+				data, found := strings.CutPrefix(line, "data: ")
+				if !found || data == "[DONE]" {
+					continue
+				}
+				var chunk chatCompletionRespChunk
+				err := json.Unmarshal([]byte(data), &chunk)
+				Expect(err).NotTo(HaveOccurred())
+				if chunk.Usage != nil {
+					usageData = chunk.Usage
+				}
+				for _, choice := range chunk.Choices {
+					if choice.Delta.ReasoningContent != "" {
+						// all the reasoning is sent before the content
+						Expect(content).To(BeEmpty())
+						reasoningChunks++
+					}
+					content += choice.Delta.Content.PlainText()
+				}
+			}
+			Expect(reasoningChunks).To(Equal(10))
+			Expect(content).To(Equal(userMessage))
+			Expect(usageData).NotTo(BeNil())
+			Expect(usageData.CompletionTokensDetails.ReasoningTokens).To(Equal(10))
+			Expect(usageData.CompletionTokens).To(Equal(10 + len(tokenize(userMessage))))
+		})
+	})
+
+	Context("text completions prompts", func() {
+		DescribeTable("prompt formats",
+			func(prompt openai.CompletionNewParamsPromptUnion, expectedTexts []string, expectedPromptTokens int) {
+				ctx := context.TODO()
+				client, err := startServer(ctx, modeEcho)
+				Expect(err).NotTo(HaveOccurred())
+
+				openaiclient := openai.NewClient(
+					option.WithBaseURL(baseURL),
+					option.WithHTTPClient(client))
+
+				params := openai.CompletionNewParams{
+					Prompt: prompt,
+					Model:  openai.CompletionNewParamsModel(model),
+				}
+				resp, err := openaiclient.Completions.New(ctx, params)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.Choices).To(HaveLen(len(expectedTexts)))
+				for i, choice := range resp.Choices {
+					Expect(choice.Index).To(Equal(int64(i)))
+					Expect(choice.Text).To(Equal(expectedTexts[i]))
+					Expect(string(choice.FinishReason)).To(Equal(stopFinishReason))
+				}
+				Expect(resp.Usage.PromptTokens).To(Equal(int64(expectedPromptTokens)))
+			},
+			Entry("array of strings",
+				openai.CompletionNewParamsPromptUnion{OfArrayOfStrings: []string{userMessage, "Second prompt"}},
+				[]string{userMessage, "Second prompt"}, 6),
+			Entry("array of tokens",
+				openai.CompletionNewParamsPromptUnion{OfArrayOfTokens: []int64{10, 20, 30}},
+				[]string{"10 20 30"}, 3),
+			Entry("array of token arrays",
+				openai.CompletionNewParamsPromptUnion{OfArrayOfTokenArrays: [][]int64{{10, 20}, {30}}},
+				[]string{"10 20", "30"}, 3),
+		)
+
+		It("Should stream a choice per prompt", func() {
+			ctx := context.TODO()
+			client, err := startServer(ctx, modeEcho)
+			Expect(err).NotTo(HaveOccurred())
+
+			openaiclient := openai.NewClient(
+				option.WithBaseURL(baseURL),
+				option.WithHTTPClient(client))
+
+			prompts := []string{userMessage, "Second prompt", "Third"}
+			params := openai.CompletionNewParams{
+				Prompt: openai.CompletionNewParamsPromptUnion{OfArrayOfStrings: prompts},
+				Model:  openai.CompletionNewParamsModel(model),
+			}
+			stream := openaiclient.Completions.NewStreaming(ctx, params)
+			defer func() {
+				err := stream.Close()
+				Expect(err).NotTo(HaveOccurred())
+			}()
+			texts := make([]string, len(prompts))
+			finishReasons := make([]string, len(prompts))
+			for stream.Next() {
+				for _, choice := range stream.Current().Choices {
+					Expect(choice.Index).To(BeNumerically("<", len(prompts)))
+					texts[choice.Index] += choice.Text
+					if choice.FinishReason != "" {
+						finishReasons[choice.Index] = string(choice.FinishReason)
+					}
+				}
+			}
+			Expect(stream.Err()).NotTo(HaveOccurred())
+			Expect(texts).To(Equal(prompts))
+			Expect(finishReasons).To(Equal([]string{stopFinishReason, stopFinishReason, stopFinishReason}))
+		})
+
+		It("Should echo detokenized token IDs when a tokenizer is configured", func() {
+			tokenizerFile := filepath.Join(GinkgoT().TempDir(), "tokenizer.json")
+			err := os.WriteFile(tokenizerFile, []byte(`{"decoder": {"type": "ByteLevel"},
+				"model": {"type": "BPE", "vocab": {"Hello": 0, "Ġworld": 1, "!": 2}}}`), 0644)
+			Expect(err).NotTo(HaveOccurred())
+
+			ctx := context.TODO()
+			args := []string{"cmd", "--model", model, "--mode", modeEcho, "--tokenizer", tokenizerFile}
+			client, err := startServerWithArgs(ctx, modeEcho, args)
+			Expect(err).NotTo(HaveOccurred())
+
+			reqBody := fmt.Sprintf(`{"model": "%s", "prompt": [0, 1, 2]}`, model)
+			resp, err := client.Post("http://localhost/v1/completions", "application/json", strings.NewReader(reqBody))
+			Expect(err).NotTo(HaveOccurred())
+			defer func() {
+				err := resp.Body.Close()
+				Expect(err).NotTo(HaveOccurred())
+			}()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+			var textResp textCompletionResponse
+			err = json.NewDecoder(resp.Body).Decode(&textResp)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(textResp.Choices).To(HaveLen(1))
+			Expect(textResp.Choices[0].Text).To(Equal("Hello world!"))
+			Expect(textResp.Usage.PromptTokens).To(Equal(3))
+		})
+
+		DescribeTable("invalid prompts",
+			func(prompt string) {
+				ctx := context.TODO()
+				client, err := startServer(ctx, modeEcho)
+				Expect(err).NotTo(HaveOccurred())
+
+				reqBody := fmt.Sprintf(`{"model": "%s", "prompt": %s}`, model, prompt)
+				resp, err := client.Post("http://localhost/v1/completions", "application/json", strings.NewReader(reqBody))
+				Expect(err).NotTo(HaveOccurred())
+				defer func() {
+					err := resp.Body.Close()
+					Expect(err).NotTo(HaveOccurred())
+				}()
+				Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+			},
+			Entry("empty array", "[]"),
+			Entry("empty token array", "[[1, 2], []]"),
+			Entry("mixed array", `["text", 1]`),
+		)
+
+		It("Should reject a request without a prompt", func() {
+			client, err := startServer(context.TODO(), modeEcho)
+			Expect(err).NotTo(HaveOccurred())
+
+			status, body := sendToRank(client, http.MethodPost, "/v1/completions", "", `{"model": "`+model+`"}`)
+			Expect(status).To(Equal(http.StatusBadRequest))
+			Expect(body).To(ContainSubstring("Either prompt or prompt_embeds must be provided and non-empty."))
+
+			status, body = sendToRank(client, http.MethodPost, "/v1/completions", "",
+				`{"model": "`+model+`", "prompt": "Hello world"}`)
+			Expect(status).To(Equal(http.StatusOK), body)
+		})
+	})
+
 	Context("max-model-len context window validation", func() {
 		It("Should reject requests exceeding context window", func() {
 			ctx := context.TODO()
@@ -491,6 +736,27 @@ var _ = Describe("Simulator", func() {
 			Expect(string(body)).To(ContainSubstring("This model's maximum context length is 10 tokens"))
 			Expect(string(body)).To(ContainSubstring("BadRequestError"))
 		})
+
+		It("Should validate each prompt of a text completion separately", func() {
+			ctx := context.TODO()
+			args := []string{"cmd", "--model", model, "--mode", modeEcho, "--max-model-len", "10",
+				"--max-num-batched-tokens", "10"}
+			client, err := startServerWithArgs(ctx, modeEcho, args)
+			Expect(err).NotTo(HaveOccurred())
+
+			// each prompt fits in the context window with max_tokens, both prompts together don't
+			reqBody := `{"prompt": ["one two three four", "five six seven eight"], "model": "my_model", "max_tokens": 5}`
+			status, body := sendToRank(client, http.MethodPost, "/v1/completions", "", reqBody)
+			Expect(status).To(Equal(http.StatusOK), body)
+			var textResp textCompletionResponse
+			Expect(json.Unmarshal([]byte(body), &textResp)).To(Succeed())
+			Expect(textResp.Choices).To(HaveLen(2))
+
+			reqBody = `{"prompt": ["one two three four", "five six seven eight nine ten"], "model": "my_model", "max_tokens": 5}`
+			status, body = sendToRank(client, http.MethodPost, "/v1/completions", "", reqBody)
+			Expect(status).To(Equal(http.StatusBadRequest))
+			Expect(body).To(ContainSubstring("However, you requested 11 tokens (6 in the messages, 5 in the completion)"))
+		})
 	})
 
 	Context("max-num-batched-tokens functionality", func() {
@@ -502,7 +768,7 @@ var _ = Describe("Simulator", func() {
 			Expect(err).NotTo(HaveOccurred())
 
 			// Setup basic configuration
-			simulator.config = newConfig()
+			simulator.config = NewConfig()
 			simulator.config.Model = "test-model"
 			simulator.config.MaxModelLen = 1024
 			simulator.config.MaxNumSeqs = 5
@@ -546,6 +812,17 @@ var _ = Describe("Simulator", func() {
 				// which equals max-model-len = 1024
 				Expect(tokens).To(Equal(1024))
 			})
+
+			It("should sum the tokens of the prompts up to max-num-batched-tokens", func() {
+				req := &textCompletionRequest{
+					Prompt:    prompts{{Text: "Hello world"}, {Text: "Second prompt here"}},
+					MaxTokens: int64Ptr(100),
+				}
+				Expect(simulator.calculateProcessingTokens(req)).To(Equal(2 + 100 + 3 + 100))
+
+				simulator.config.MaxNumBatchedTokens = 150
+				Expect(simulator.calculateProcessingTokens(req)).To(Equal(150))
+			})
 		})
 
 		Describe("canAcceptRequest", func() {
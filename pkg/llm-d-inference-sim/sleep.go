@@ -0,0 +1,141 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Simulation of vLLM's sleep mode
+package llmdinferencesim
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	// sleepLevelOffloadWeights is the sleep level that offloads the model's weights to CPU memory
+	sleepLevelOffloadWeights = 1
+	// sleepLevelDiscardWeights is the sleep level that discards the model's weights
+	sleepLevelDiscardWeights = 2
+)
+
+// isSleepingResponse is the response of /is_sleeping
+type isSleepingResponse struct {
+	// IsSleeping is true if the engine is sleeping
+	IsSleeping bool `json:"is_sleeping"`
+}
+
+// HandleSleep http handler for /sleep, puts the engines of all the data parallel ranks to sleep,
+// the KV cache is cleared
+func (s *VllmSimulator) HandleSleep(ctx *fasthttp.RequestCtx) {
+	s.logger.Info("sleep request received")
+
+	level := sleepLevelOffloadWeights
+	if levelArg := ctx.QueryArgs().Peek("level"); len(levelArg) > 0 {
+		var err error
+		level, err = strconv.Atoi(string(levelArg))
+		if err != nil || (level != sleepLevelOffloadWeights && level != sleepLevelDiscardWeights) {
+			s.sendCompletionError(ctx, fmt.Sprintf("Invalid sleep level '%s', valid values are 1 and 2", levelArg),
+				"BadRequestError", fasthttp.StatusBadRequest)
+			return
+		}
+	}
+
+	for _, engine := range s.getEngines() {
+		engine.sleep(level)
+	}
+	ctx.SetStatusCode(fasthttp.StatusOK)
+}
+
+// HandleWakeUp http handler for /wake_up, wakes up the engines of all the data parallel ranks,
+// returns after the wake up time
+func (s *VllmSimulator) HandleWakeUp(ctx *fasthttp.RequestCtx) {
+	s.logger.Info("wake up request received")
+
+	var wg sync.WaitGroup
+	for _, engine := range s.getEngines() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			engine.wakeUp()
+		}()
+	}
+	wg.Wait()
+	ctx.SetStatusCode(fasthttp.StatusOK)
+}
+
+// HandleIsSleeping http handler for /is_sleeping
+func (s *VllmSimulator) HandleIsSleeping(ctx *fasthttp.RequestCtx) {
+	s.logger.V(4).Info("is sleeping request received")
+
+	data, err := json.Marshal(isSleepingResponse{IsSleeping: s.isSleeping()})
+	if err != nil {
+		s.logger.Error(err, "Failed to marshal is sleeping response")
+		ctx.Error("Failed to marshal is sleeping response, "+err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	ctx.Response.Header.SetContentType("application/json")
+	ctx.Response.Header.SetStatusCode(fasthttp.StatusOK)
+	ctx.Response.SetBody(data)
+}
+
+// sleep puts the engine to sleep with the given level, and clears the KV cache. A deeper sleep
+// level replaces a lighter one.
+func (s *VllmSimulator) sleep(level int) {
+	s.sleepLock.Lock()
+	s.sleepLevel = max(s.sleepLevel, level)
+	s.sleepID++
+	s.sleepLock.Unlock()
+
+	s.kvBlocksLock.Lock()
+	clear(s.kvBlocks)
+	s.kvBlocksLock.Unlock()
+}
+
+// wakeUp wakes up the engine after the wake up time, the weights of an engine that discarded
+// them are loaded again. Waiting requests are not scheduled until the engine is awake. The engine
+// keeps sleeping if it was put to sleep again during the wake up.
+func (s *VllmSimulator) wakeUp() {
+	s.sleepLock.Lock()
+	level := s.sleepLevel
+	sleepID := s.sleepID
+	s.sleepLock.Unlock()
+	if level == 0 {
+		return
+	}
+
+	wakeUpTime := time.Duration(s.config.WakeUpLatency) * time.Millisecond
+	if level == sleepLevelDiscardWeights {
+		wakeUpTime += s.getLoadingTime()
+	}
+	time.Sleep(wakeUpTime)
+
+	s.sleepLock.Lock()
+	if s.sleepID == sleepID {
+		s.sleepLevel = 0
+	}
+	s.sleepLock.Unlock()
+}
+
+// isSleeping returns true if the engine is sleeping or waking up
+func (s *VllmSimulator) isSleeping() bool {
+	s.sleepLock.Lock()
+	defer s.sleepLock.Unlock()
+
+	return s.sleepLevel != 0
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/klog/v2"
+)
+
+var _ = Describe("Sleep mode", func() {
+	completionBody := `{"model": "` + model + `", "prompt": "Hello world", "max_tokens": 2}`
+
+	It("Should not support sleep mode by default", func() {
+		client, err := startServerWithArgs(context.TODO(), modeEcho, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		status, _ := sendToRank(client, http.MethodGet, "/is_sleeping", "", "")
+		Expect(status).To(Equal(http.StatusNotFound))
+		status, _ = sendToRank(client, http.MethodPost, "/sleep", "", "")
+		Expect(status).To(Equal(http.StatusNotFound))
+	})
+
+	It("Should queue requests until the engine wakes up", func() {
+		client, err := startServerWithArgs(context.TODO(), modeEcho, []string{"cmd", "--model", model, "--mode", modeEcho,
+			"--enable-sleep-mode", "--wake-up-latency", "200"})
+		Expect(err).NotTo(HaveOccurred())
+
+		_, body := sendToRank(client, http.MethodGet, "/is_sleeping", "", "")
+		Expect(body).To(MatchJSON(`{"is_sleeping": false}`))
+
+		status, _ := sendToRank(client, http.MethodPost, "/sleep?level=1", "", "")
+		Expect(status).To(Equal(http.StatusOK))
+		_, body = sendToRank(client, http.MethodGet, "/is_sleeping", "", "")
+		Expect(body).To(MatchJSON(`{"is_sleeping": true}`))
+
+		completed := make(chan int, 1)
+		go func() {
+			defer GinkgoRecover()
+			status, _ := sendToRank(client, http.MethodPost, "/v1/completions", "", completionBody)
+			completed <- status
+		}()
+		Consistently(completed, 300*time.Millisecond).ShouldNot(Receive())
+
+		start := time.Now()
+		status, _ = sendToRank(client, http.MethodPost, "/wake_up", "", "")
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(time.Since(start)).To(BeNumerically(">=", 200*time.Millisecond))
+		_, body = sendToRank(client, http.MethodGet, "/is_sleeping", "", "")
+		Expect(body).To(MatchJSON(`{"is_sleeping": false}`))
+		Eventually(completed).Should(Receive(Equal(http.StatusOK)))
+	})
+
+	It("Should keep sleeping if put to sleep during a wake up", func() {
+		client, err := startServerWithArgs(context.TODO(), modeEcho, []string{"cmd", "--model", model, "--mode", modeEcho,
+			"--enable-sleep-mode", "--wake-up-latency", "300"})
+		Expect(err).NotTo(HaveOccurred())
+
+		status, _ := sendToRank(client, http.MethodPost, "/sleep", "", "")
+		Expect(status).To(Equal(http.StatusOK))
+
+		wokeUp := make(chan int, 1)
+		go func() {
+			defer GinkgoRecover()
+			status, _ := sendToRank(client, http.MethodPost, "/wake_up", "", "")
+			wokeUp <- status
+		}()
+		time.Sleep(100 * time.Millisecond)
+		status, _ = sendToRank(client, http.MethodPost, "/sleep", "", "")
+		Expect(status).To(Equal(http.StatusOK))
+
+		Eventually(wokeUp, time.Second).Should(Receive(Equal(http.StatusOK)))
+		_, body := sendToRank(client, http.MethodGet, "/is_sleeping", "", "")
+		Expect(body).To(MatchJSON(`{"is_sleeping": true}`))
+	})
+
+	It("Should reject invalid sleep levels", func() {
+		client, err := startServerWithArgs(context.TODO(), modeEcho, []string{"cmd", "--model", model, "--mode", modeEcho,
+			"--enable-sleep-mode"})
+		Expect(err).NotTo(HaveOccurred())
+
+		status, body := sendToRank(client, http.MethodPost, "/sleep?level=3", "", "")
+		Expect(status).To(Equal(http.StatusBadRequest))
+		Expect(body).To(ContainSubstring("Invalid sleep level '3'"))
+		_, body = sendToRank(client, http.MethodGet, "/is_sleeping", "", "")
+		Expect(body).To(MatchJSON(`{"is_sleeping": false}`))
+	})
+
+	It("Should clear the KV cache and reload discarded weights", func() {
+		s, err := New(klog.Background())
+		Expect(err).NotTo(HaveOccurred())
+		s.config = NewConfig()
+		s.config.WakeUpLatency = 100
+		s.config.StartupDelay = 200
+		s.random = newRandom(time.Now().UnixNano())
+		s.allocateKVBlocks(100)
+		Expect(s.kvBlocks).NotTo(BeEmpty())
+
+		s.sleep(sleepLevelOffloadWeights)
+		Expect(s.kvBlocks).To(BeEmpty())
+		start := time.Now()
+		s.wakeUp()
+		Expect(time.Since(start)).To(BeNumerically("<", 200*time.Millisecond))
+
+		// the weights are loaded again when waking up from level 2
+		s.sleep(sleepLevelDiscardWeights)
+		s.sleep(sleepLevelOffloadWeights)
+		start = time.Now()
+		s.wakeUp()
+		Expect(time.Since(start)).To(BeNumerically(">=", 300*time.Millisecond))
+		Expect(s.isSleeping()).To(BeFalse())
+	})
+})
@@ -32,37 +32,57 @@ type streamingContext struct {
 	model            string
 	creationTime     int64
 	doRemotePrefill  bool
+	// kvParams are the KV transfer parameters of a prefill request, sent in the last chunk,
+	// nil for other requests
+	kvParams *kvTransferParams
+	// nTokensSent is the number of tokens sent so far, used to apply time to first token
+	// before the first token only
+	nTokensSent int
+	// nMultimodalItems is the number of images, audios and videos in the prompt
+	nMultimodalItems int
+	// timing contains the times of the stages of the request's lifecycle
+	timing *requestTiming
 }
 
 // sendStreamingResponse creates and sends a streaming response for completion requests of both types (text and chat)
 // as defined by isChatCompletion
 // response content is wrapped according SSE format
 // First token is send after timeToFirstToken milliseconds, every other token is sent after interTokenLatency milliseconds
-func (s *VllmSimulator) sendStreamingResponse(context *streamingContext, responseTokens []string, toolCalls []toolCall,
-	finishReason string, usageData *usage) {
+// Reasoning tokens, if exist, are sent before the response tokens
+// Text completion responses contain a choice per prompt, the choices are streamed in parallel
+func (s *VllmSimulator) sendStreamingResponse(context *streamingContext, responseTokens [][]string, reasoningTokens []string,
+	toolCalls []toolCall, finishReasons []string, usageData *usage) {
 	context.ctx.SetContentType("text/event-stream")
 	context.ctx.SetStatusCode(fasthttp.StatusOK)
 
 	context.ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
 		context.creationTime = time.Now().Unix()
 
-		if len(responseTokens) > 0 || len(toolCalls) > 0 {
-			if context.isChatCompletion {
-				// in chat completion first chunk contains the role
-				chunk := s.createChatCompletionChunk(context, "", nil, roleAssistant, nil)
-				if err := s.sendChunk(w, chunk, ""); err != nil {
-					context.ctx.Error("Sending stream first chunk failed, "+err.Error(), fasthttp.StatusInternalServerError)
+		if !context.isChatCompletion {
+			s.logger.Info("Going to send text", "number of choices", len(responseTokens))
+			s.sendTextChoicesChunks(context, w, responseTokens, finishReasons)
+		} else if chatTokens := getFirstChoiceTokens(responseTokens); len(chatTokens) > 0 || len(reasoningTokens) > 0 || len(toolCalls) > 0 {
+			// in chat completion first chunk contains the role
+			chunk := s.createChatCompletionChunk(context, "", nil, roleAssistant, nil)
+			if err := s.sendChunk(w, chunk, ""); err != nil {
+				context.ctx.Error("Sending stream first chunk failed, "+err.Error(), fasthttp.StatusInternalServerError)
+				return
+			}
+			if len(reasoningTokens) > 0 {
+				s.logger.Info("Going to send reasoning", "number of tokens", len(reasoningTokens))
+				if err := s.sendReasoningChunks(context, w, reasoningTokens); err != nil {
+					context.ctx.Error("Sending stream reasoning chunk failed, "+err.Error(), fasthttp.StatusInternalServerError)
 					return
 				}
 			}
 			if len(toolCalls) > 0 {
 				s.logger.Info("Going to send tools calls")
 				for _, tc := range toolCalls {
-					s.sendTokenChunks(context, w, tc.Function.tokenizedArguments, &tc, finishReason)
+					s.sendTokenChunks(context, w, tc.Function.tokenizedArguments, &tc, finishReasons[0])
 				}
 			} else {
-				s.logger.Info("Going to send text", "number of tokens", len(responseTokens))
-				s.sendTokenChunks(context, w, responseTokens, nil, finishReason)
+				s.logger.Info("Going to send text", "number of tokens", len(chatTokens))
+				s.sendTokenChunks(context, w, chatTokens, nil, finishReasons[0])
 			}
 		}
 
@@ -80,19 +100,40 @@ func (s *VllmSimulator) sendStreamingResponse(context *streamingContext, respons
 			context.ctx.Error("Sending last stream chunk failed, "+err.Error(), fasthttp.StatusInternalServerError)
 			return
 		}
-		s.responseSentCallback(context.model)
+		s.responseSentCallback(context.model, context.timing)
 	})
 }
 
-// sendTokenChunks creates and sends response chunks
-func (s *VllmSimulator) sendTokenChunks(context *streamingContext, w *bufio.Writer, tokens []string, tc *toolCall, finishReason string) {
-	// time to first token delay
-	time.Sleep(time.Duration(s.getTimeToFirstToken(context.doRemotePrefill)) * time.Millisecond)
+// waitForNextToken sleeps for time to first token before the first token of the response,
+// and for inter token latency before each one of the other tokens
+func (s *VllmSimulator) waitForNextToken(context *streamingContext) {
+	if context.nTokensSent == 0 {
+		time.Sleep(time.Duration(s.getTimeToFirstToken(context.model, context.doRemotePrefill, context.nMultimodalItems)) * time.Millisecond)
+		s.recordFirstToken(context.model, context.timing)
+	} else {
+		time.Sleep(time.Duration(s.getInterTokenLatency(context.model)) * time.Millisecond)
+		s.recordOutputTokens(context.model, context.timing, 1)
+	}
+	context.nTokensSent++
+}
 
-	for i, token := range tokens {
-		if i != 0 {
-			time.Sleep(time.Duration(s.config.InterTokenLatency) * time.Millisecond)
+// sendReasoningChunks creates and sends chat completion chunks with reasoning content
+func (s *VllmSimulator) sendReasoningChunks(context *streamingContext, w *bufio.Writer, tokens []string) error {
+	for _, token := range tokens {
+		s.waitForNextToken(context)
+		chunk := s.createChatCompletionChunk(context, "", nil, "", nil)
+		chunk.Choices[0].Delta.ReasoningContent = token
+		if err := s.sendChunk(w, chunk, ""); err != nil {
+			return err
 		}
+	}
+	return nil
+}
+
+// sendTokenChunks creates and sends chat completion response chunks
+func (s *VllmSimulator) sendTokenChunks(context *streamingContext, w *bufio.Writer, tokens []string, tc *toolCall, finishReason string) {
+	for i, token := range tokens {
+		s.waitForNextToken(context)
 		var toolChunkInsert *toolCall
 		if tc != nil {
 			toolChunkInsert = &toolCall{
@@ -108,31 +149,20 @@ func (s *VllmSimulator) sendTokenChunks(context *streamingContext, w *bufio.Writ
 			}
 		}
 
-		var chunk completionRespChunk
 		var finishReasonToSend *string
 		if i == len(tokens)-1 && (finishReason == lengthFinishReason || finishReason == toolsFinishReason) {
 			finishReasonToSend = &finishReason
 		}
-		if context.isChatCompletion {
-			chunk = s.createChatCompletionChunk(context, token, toolChunkInsert, "", finishReasonToSend)
-		} else {
-			chunk = s.createTextCompletionChunk(context, token, finishReasonToSend)
-		}
-
+		chunk := s.createChatCompletionChunk(context, token, toolChunkInsert, "", finishReasonToSend)
 		if err := s.sendChunk(w, chunk, ""); err != nil {
 			context.ctx.Error("Sending stream chunk failed, "+err.Error(), fasthttp.StatusInternalServerError)
 			return
 		}
 	}
 
-	// send the last chunk if finish reason is stop
-	var chunk completionRespChunk
-	if finishReason == stopFinishReason {
-		if context.isChatCompletion {
-			chunk = s.createChatCompletionChunk(context, "", nil, "", &finishReason)
-		} else {
-			chunk = s.createTextCompletionChunk(context, "", &finishReason)
-		}
+	// send the last chunk if finish reason is stop or remote decode, or if there are no tokens to carry the finish reason
+	if finishReason == stopFinishReason || finishReason == remoteDecodeFinishReason || len(tokens) == 0 {
+		chunk := s.createChatCompletionChunk(context, "", nil, "", &finishReason)
 		if err := s.sendChunk(w, chunk, ""); err != nil {
 			context.ctx.Error("Sending last stream chunk failed, "+err.Error(), fasthttp.StatusInternalServerError)
 			return
@@ -140,6 +170,46 @@ func (s *VllmSimulator) sendTokenChunks(context *streamingContext, w *bufio.Writ
 	}
 }
 
+// sendTextChoicesChunks creates and sends text completion response chunks of all the choices, the choices
+// are generated in parallel, so after each wait the next token of each one of the choices is sent
+func (s *VllmSimulator) sendTextChoicesChunks(context *streamingContext, w *bufio.Writer, choicesTokens [][]string,
+	finishReasons []string) {
+	numOfSteps := 0
+	for _, tokens := range choicesTokens {
+		numOfSteps = max(numOfSteps, len(tokens))
+	}
+
+	for step := 0; step < numOfSteps; step++ {
+		s.waitForNextToken(context)
+		for index, tokens := range choicesTokens {
+			if step >= len(tokens) {
+				continue
+			}
+			var finishReasonToSend *string
+			if step == len(tokens)-1 && finishReasons[index] == lengthFinishReason {
+				finishReasonToSend = &finishReasons[index]
+			}
+			chunk := s.createTextCompletionChunk(context, index, tokens[step], finishReasonToSend)
+			if err := s.sendChunk(w, chunk, ""); err != nil {
+				context.ctx.Error("Sending stream chunk failed, "+err.Error(), fasthttp.StatusInternalServerError)
+				return
+			}
+		}
+	}
+
+	// send the last chunk of each choice that finished with stop or remote decode, or that has no tokens to carry
+	// the finish reason
+	for index, tokens := range choicesTokens {
+		if finishReasons[index] == stopFinishReason || finishReasons[index] == remoteDecodeFinishReason || len(tokens) == 0 {
+			chunk := s.createTextCompletionChunk(context, index, "", &finishReasons[index])
+			if err := s.sendChunk(w, chunk, ""); err != nil {
+				context.ctx.Error("Sending last stream chunk failed, "+err.Error(), fasthttp.StatusInternalServerError)
+				return
+			}
+		}
+	}
+}
+
 // createUsageChunk creates and returns a CompletionRespChunk with usage data, a single chunk of streamed completion API response,
 // supports both modes (text and chat)
 func (s *VllmSimulator) createUsageChunk(context *streamingContext, usageData *usage) completionRespChunk {
@@ -166,8 +236,8 @@ func (s *VllmSimulator) createUsageChunk(context *streamingContext, usageData *u
 
 // createTextCompletionChunk creates and returns a CompletionRespChunk, a single chunk of streamed completion API response,
 // for text completion
-func (s *VllmSimulator) createTextCompletionChunk(context *streamingContext, token string, finishReason *string) completionRespChunk {
-	return &textCompletionResponse{
+func (s *VllmSimulator) createTextCompletionChunk(context *streamingContext, index int, token string, finishReason *string) completionRespChunk {
+	chunk := textCompletionResponse{
 		baseCompletionResponse: baseCompletionResponse{
 			ID:      chatComplIDPrefix + uuid.NewString(),
 			Created: context.creationTime,
@@ -176,17 +246,21 @@ func (s *VllmSimulator) createTextCompletionChunk(context *streamingContext, tok
 		},
 		Choices: []textRespChoice{
 			{
-				baseResponseChoice: baseResponseChoice{Index: 0, FinishReason: finishReason},
+				baseResponseChoice: baseResponseChoice{Index: index, FinishReason: finishReason},
 				Text:               token,
 			},
 		},
 	}
+	if finishReason != nil && *finishReason == remoteDecodeFinishReason {
+		setKVTransferParams(&chunk.baseCompletionResponse, context.kvParams)
+	}
+	return &chunk
 }
 
 // createChatCompletionChunk creates and returns a CompletionRespChunk, a single chunk of streamed completion
 // API response, for chat completion. It sets either role, or token, or tool call info in the message.
 func (s *VllmSimulator) createChatCompletionChunk(context *streamingContext, token string, tool *toolCall,
-	role string, finishReason *string) completionRespChunk {
+	role string, finishReason *string) *chatCompletionRespChunk {
 	chunk := chatCompletionRespChunk{
 		baseCompletionResponse: baseCompletionResponse{
 			ID:      chatComplIDPrefix + uuid.NewString(),
@@ -201,6 +275,9 @@ func (s *VllmSimulator) createChatCompletionChunk(context *streamingContext, tok
 			},
 		},
 	}
+	if finishReason != nil && *finishReason == remoteDecodeFinishReason {
+		setKVTransferParams(&chunk.baseCompletionResponse, context.kvParams)
+	}
 
 	if len(role) > 0 {
 		chunk.Choices[0].Delta.Role = role
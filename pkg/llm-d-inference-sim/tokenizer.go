@@ -0,0 +1,170 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// metaspace replaces spaces in SentencePiece tokenizers
+	metaspace = "▁"
+	// byteLevelDecoder is the type of the decoder of byte level BPE tokenizers
+	byteLevelDecoder = "ByteLevel"
+)
+
+// tokenizer converts token IDs to text, it is loaded from a HuggingFace tokenizer.json file
+type tokenizer struct {
+	// vocab maps token IDs to tokens
+	vocab map[int]string
+	// isByteLevel is true for byte level BPE tokenizers, in which each character of a token
+	// represents a single byte
+	isByteLevel bool
+	// byteLevelChars maps the characters of byte level tokens to the bytes they represent
+	byteLevelChars map[rune]byte
+}
+
+// tokenizerFile is the part of the tokenizer.json file that is needed for detokenization
+type tokenizerFile struct {
+	AddedTokens []struct {
+		ID      int    `json:"id"`
+		Content string `json:"content"`
+	} `json:"added_tokens"`
+	Decoder json.RawMessage `json:"decoder"`
+	Model   struct {
+		Vocab json.RawMessage `json:"vocab"`
+	} `json:"model"`
+}
+
+// loadTokenizer loads a tokenizer from the given tokenizer.json file
+func loadTokenizer(path string) (*tokenizer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tokenizer file: %s", err)
+	}
+	var file tokenizerFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse tokenizer file: %s", err)
+	}
+
+	t := tokenizer{vocab: make(map[int]string)}
+	// BPE and WordPiece vocabularies map tokens to IDs
+	var vocab map[string]int
+	if err := json.Unmarshal(file.Model.Vocab, &vocab); err == nil {
+		for token, id := range vocab {
+			t.vocab[id] = token
+		}
+	} else {
+		// Unigram vocabularies are lists of tokens and their scores, the ID is the index in the list
+		var pieces [][]any
+		if err := json.Unmarshal(file.Model.Vocab, &pieces); err != nil {
+			return nil, fmt.Errorf("tokenizer vocabulary format not supported: %s", err)
+		}
+		for id, piece := range pieces {
+			if len(piece) > 0 {
+				if token, ok := piece[0].(string); ok {
+					t.vocab[id] = token
+				}
+			}
+		}
+	}
+	for _, token := range file.AddedTokens {
+		t.vocab[token.ID] = token.Content
+	}
+
+	if bytes.Contains(file.Decoder, []byte(`"`+byteLevelDecoder+`"`)) {
+		t.isByteLevel = true
+		t.byteLevelChars = createByteLevelChars()
+	}
+	return &t, nil
+}
+
+// createByteLevelChars returns the mapping of byte level BPE characters to bytes, this is the
+// inverse of the mapping used by GPT-2: printable bytes are mapped to themselves, the other
+// bytes are mapped to characters starting from 256
+func createByteLevelChars() map[rune]byte {
+	chars := make(map[rune]byte)
+	next := rune(256)
+	for b := 0; b < 256; b++ {
+		if (b >= '!' && b <= '~') || (b >= 0xA1 && b <= 0xAC) || (b >= 0xAE && b <= 0xFF) {
+			chars[rune(b)] = byte(b)
+		} else {
+			chars[next] = byte(b)
+			next++
+		}
+	}
+	return chars
+}
+
+// decode returns the text of the given token IDs, unknown token IDs are ignored
+func (t *tokenizer) decode(tokenIDs []int) string {
+	var buf bytes.Buffer
+	for _, id := range tokenIDs {
+		token, ok := t.vocab[id]
+		if !ok {
+			continue
+		}
+		if t.isByteLevel {
+			for _, char := range token {
+				if b, ok := t.byteLevelChars[char]; ok {
+					buf.WriteByte(b)
+				} else {
+					buf.WriteRune(char)
+				}
+			}
+		} else if b, ok := parseByteFallbackToken(token); ok {
+			buf.WriteByte(b)
+		} else {
+			buf.WriteString(strings.ReplaceAll(token, metaspace, " "))
+		}
+	}
+	if t.isByteLevel {
+		return buf.String()
+	}
+	// SentencePiece tokenizers add a space at the beginning of the text
+	return strings.TrimPrefix(buf.String(), " ")
+}
+
+// parseByteFallbackToken parses a byte fallback token of SentencePiece tokenizers, e.g. <0x0A>
+func parseByteFallbackToken(token string) (byte, bool) {
+	if len(token) != 6 || !strings.HasPrefix(token, "<0x") || !strings.HasSuffix(token, ">") {
+		return 0, false
+	}
+	b, err := strconv.ParseUint(token[3:5], 16, 8)
+	if err != nil {
+		return 0, false
+	}
+	return byte(b), true
+}
+
+// detokenize returns the text of the given token IDs, if no tokenizer is configured
+// the text is the token IDs separated by spaces
+func (s *VllmSimulator) detokenize(tokenIDs []int) string {
+	if s.tokenizer != nil {
+		return s.tokenizer.decode(tokenIDs)
+	}
+	ids := make([]string, 0, len(tokenIDs))
+	for _, id := range tokenIDs {
+		ids = append(ids, strconv.Itoa(id))
+	}
+	return strings.Join(ids, " ")
+}
@@ -0,0 +1,57 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Tokenizer", func() {
+	DescribeTable("decode",
+		func(tokenizerJSON string, tokenIDs []int, expectedText string) {
+			tokenizerFile := filepath.Join(GinkgoT().TempDir(), "tokenizer.json")
+			err := os.WriteFile(tokenizerFile, []byte(tokenizerJSON), 0644)
+			Expect(err).NotTo(HaveOccurred())
+
+			tokenizer, err := loadTokenizer(tokenizerFile)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tokenizer.decode(tokenIDs)).To(Equal(expectedText))
+		},
+		Entry("byte level BPE",
+			`{"decoder": {"type": "ByteLevel"}, "model": {"type": "BPE", "vocab": {"Hi": 0, "Ġthere": 1, "Ċ": 2}},
+			"added_tokens": [{"id": 3, "content": "<|end|>"}]}`,
+			[]int{0, 1, 2, 3}, "Hi there\n<|end|>"),
+		Entry("SentencePiece BPE",
+			`{"decoder": {"type": "Sequence"}, "model": {"type": "BPE", "vocab": {"▁Hi": 0, "▁there": 1, "<0x0A>": 2}}}`,
+			[]int{0, 1, 2}, "Hi there\n"),
+		Entry("Unigram",
+			`{"decoder": {"type": "Metaspace"}, "model": {"type": "Unigram", "vocab": [["▁Hi", -1.0], ["▁there", -2.0]]}}`,
+			[]int{0, 1}, "Hi there"),
+		Entry("unknown token IDs",
+			`{"decoder": {"type": "ByteLevel"}, "model": {"type": "BPE", "vocab": {"Hi": 0}}}`,
+			[]int{0, 7}, "Hi"),
+	)
+
+	It("Should fail to load an invalid tokenizer file", func() {
+		_, err := loadTokenizer(filepath.Join(GinkgoT().TempDir(), "missing.json"))
+		Expect(err).To(HaveOccurred())
+	})
+})
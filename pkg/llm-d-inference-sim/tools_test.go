@@ -20,6 +20,7 @@ import (
 	"context"
 	"encoding/json"
 	"strings"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -282,6 +283,102 @@ var toolWithObjectAndArray = []openai.ChatCompletionToolParam{
 	},
 }
 
+var toolWithSchemaKeywords = []openai.ChatCompletionToolParam{
+	{
+		Function: openai.FunctionDefinitionParam{
+			Name:        "book_flight",
+			Description: openai.String("Book a flight"),
+			Parameters: openai.FunctionParameters{
+				"type": "object",
+				"$defs": map[string]interface{}{
+					"airport": map[string]interface{}{
+						"type":    "string",
+						"pattern": "^[A-Z]{3}$",
+					},
+					"passenger": map[string]interface{}{
+						"type": "object",
+						"properties": map[string]interface{}{
+							"name": map[string]interface{}{
+								"type":      "string",
+								"minLength": 12,
+								"maxLength": 20,
+							},
+							"age": map[string]interface{}{
+								"type":             "integer",
+								"minimum":          18,
+								"exclusiveMaximum": 21,
+							},
+							"email": map[string]interface{}{
+								"type":   "string",
+								"format": "email",
+							},
+						},
+						"required": []string{"name", "age", "email"},
+					},
+				},
+				"properties": map[string]interface{}{
+					"from": map[string]interface{}{"$ref": "#/$defs/airport"},
+					"to":   map[string]interface{}{"$ref": "#/$defs/airport"},
+					"date": map[string]interface{}{
+						"type":   "string",
+						"format": "date",
+					},
+					"passengers": map[string]interface{}{
+						"type":     "array",
+						"items":    map[string]interface{}{"$ref": "#/$defs/passenger"},
+						"minItems": 1,
+						"maxItems": 3,
+					},
+					"class": map[string]interface{}{
+						"const": "economy",
+					},
+					"budget": map[string]interface{}{
+						"anyOf": []map[string]interface{}{
+							{"type": "number", "minimum": 100, "maximum": 200, "multipleOf": 0.5},
+							{"type": "null"},
+						},
+					},
+					"seat": map[string]interface{}{
+						"oneOf": []map[string]interface{}{
+							{"type": "string", "enum": []string{"window", "aisle"}},
+							{"type": "integer", "minimum": 1, "maximum": 3},
+						},
+					},
+					"notes": map[string]interface{}{
+						"allOf": []map[string]interface{}{
+							{"type": "object", "properties": map[string]interface{}{"text": map[string]string{"type": "string"}}},
+							{"required": []string{"text"}},
+						},
+					},
+					"tags": map[string]interface{}{
+						"type": "array",
+					},
+					"extra": map[string]interface{}{
+						"type": "object",
+					},
+				},
+				"required": []string{"from", "to", "date", "passengers", "class", "budget", "seat", "notes", "tags", "extra"},
+			},
+		},
+	},
+}
+
+var toolWithInvalidRef = []openai.ChatCompletionToolParam{
+	{
+		Function: openai.FunctionDefinitionParam{
+			Name:        "get_weather",
+			Description: openai.String("Get weather at the given location"),
+			Parameters: openai.FunctionParameters{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"location": map[string]interface{}{"$ref": "#/$defs/location"},
+				},
+				"required": []string{"location"},
+			},
+		},
+	},
+}
+
 var _ = Describe("Simulator for request with tools", func() {
 
 	DescribeTable("streaming",
@@ -712,4 +809,290 @@ var _ = Describe("Simulator for request with tools", func() {
 		},
 		Entry(nil, modeRandom),
 	)
+
+	DescribeTable("schema keywords, no streaming",
+		func(mode string) {
+			ctx := context.TODO()
+			client, err := startServer(ctx, mode)
+			Expect(err).NotTo(HaveOccurred())
+
+			openaiclient := openai.NewClient(
+				option.WithBaseURL(baseURL),
+				option.WithHTTPClient(client))
+
+			params := openai.ChatCompletionNewParams{
+				Messages:   []openai.ChatCompletionMessageParamUnion{openai.UserMessage(userMessage)},
+				Model:      model,
+				ToolChoice: openai.ChatCompletionToolChoiceOptionUnionParam{OfAuto: param.NewOpt("required")},
+				Tools:      toolWithSchemaKeywords,
+			}
+
+			resp, err := openaiclient.Chat.Completions.New(ctx, params)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Choices).ShouldNot(BeEmpty())
+
+			toolCalls := resp.Choices[0].Message.ToolCalls
+			Expect(toolCalls).To(HaveLen(1))
+			tc := toolCalls[0]
+			Expect(tc.Function.Name).To(Equal("book_flight"))
+
+			args := make(map[string]any)
+			err = json.Unmarshal([]byte(tc.Function.Arguments), &args)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(args["from"]).To(MatchRegexp("^[A-Z]{3}$"))
+			Expect(args["to"]).To(MatchRegexp("^[A-Z]{3}$"))
+			Expect(args["date"]).To(MatchRegexp(`^\d{4}-\d{2}-\d{2}$`))
+			Expect(args["class"]).To(Equal("economy"))
+			Expect(args).To(HaveKey("budget"))
+			if args["budget"] != nil {
+				Expect(args["budget"]).To(BeNumerically(">=", 100))
+				Expect(args["budget"]).To(BeNumerically("<=", 200))
+			}
+			Expect(args["seat"]).To(Or(Equal("window"), Equal("aisle"), BeNumerically(">=", 1)))
+			Expect(args["notes"]).To(HaveKey("text"))
+			Expect(args["tags"]).To(BeAssignableToTypeOf([]any{}))
+			Expect(args["extra"]).To(BeAssignableToTypeOf(map[string]any{}))
+
+			passengers, ok := args["passengers"].([]any)
+			Expect(ok).To(BeTrue())
+			Expect(len(passengers)).To(BeNumerically(">=", 1))
+			Expect(len(passengers)).To(BeNumerically("<=", 3))
+			for _, p := range passengers {
+				passenger, ok := p.(map[string]any)
+				Expect(ok).To(BeTrue())
+				name, ok := passenger["name"].(string)
+				Expect(ok).To(BeTrue())
+				Expect(len(name)).To(BeNumerically(">=", 12))
+				Expect(len(name)).To(BeNumerically("<=", 20))
+				Expect(passenger["age"]).To(Or(BeNumerically("==", 18), BeNumerically("==", 19), BeNumerically("==", 20)))
+				Expect(passenger["email"]).To(HaveSuffix("@example.com"))
+			}
+		},
+		func(mode string) string {
+			return "mode: " + mode
+		},
+		// Call several times because the tools and arguments are chosen randomly
+		Entry(nil, modeRandom),
+		Entry(nil, modeRandom),
+		Entry(nil, modeRandom),
+		Entry(nil, modeRandom),
+	)
+
+	DescribeTable("invalid reference, no streaming",
+		func(mode string) {
+			ctx := context.TODO()
+			client, err := startServer(ctx, mode)
+			Expect(err).NotTo(HaveOccurred())
+
+			openaiclient := openai.NewClient(
+				option.WithBaseURL(baseURL),
+				option.WithHTTPClient(client))
+
+			params := openai.ChatCompletionNewParams{
+				Messages:   []openai.ChatCompletionMessageParamUnion{openai.UserMessage(userMessage)},
+				Model:      model,
+				ToolChoice: openai.ChatCompletionToolChoiceOptionUnionParam{OfAuto: param.NewOpt("required")},
+				Tools:      toolWithInvalidRef,
+			}
+
+			_, err = openaiclient.Chat.Completions.New(ctx, params)
+			Expect(err).To(HaveOccurred())
+		},
+		func(mode string) string {
+			return "mode: " + mode
+		},
+		Entry(nil, modeRandom),
+	)
+
+	Context("arguments generation", Ordered, func() {
+		var rnd *random
+
+		BeforeAll(func() {
+			rnd = newRandom(time.Now().UnixNano())
+		})
+
+		DescribeTable("generated arguments are valid",
+			func(parameters map[string]any) {
+				t := tool{Function: function{Name: "test", Parameters: parameters}}
+				for range 20 {
+					args, err := generateToolArguments(rnd, &t)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(validateToolArguments(t.Function.parametersSchema, args)).To(Succeed())
+				}
+			},
+			Entry("null", map[string]any{"type": "object",
+				"properties": map[string]any{"n": map[string]any{"type": "null"}}, "required": []any{"n"}}),
+			Entry("types list", map[string]any{"type": "object",
+				"properties": map[string]any{"n": map[string]any{"type": []any{"string", "null"}}}, "required": []any{"n"}}),
+			Entry("missing items and properties", map[string]any{"type": "object",
+				"properties": map[string]any{"a": map[string]any{"type": "array"}, "o": map[string]any{"type": "object"}},
+				"required":   []any{"a", "o"}}),
+			Entry("numbers", map[string]any{"type": "object",
+				"properties": map[string]any{
+					"i": map[string]any{"type": "integer", "minimum": -10.0, "maximum": 10.0, "multipleOf": 5.0},
+					"n": map[string]any{"type": "number", "exclusiveMinimum": 0.0, "exclusiveMaximum": 1.0, "multipleOf": 0.1},
+				},
+				"required": []any{"i", "n"}}),
+			Entry("pattern", map[string]any{"type": "object",
+				"properties": map[string]any{"p": map[string]any{"type": "string", "pattern": `^(ab|cd)+-[0-9a-f]{4}\.(x|y)?$`}},
+				"required":   []any{"p"}}),
+			Entry("formats", map[string]any{"type": "object",
+				"properties": map[string]any{
+					"a": map[string]any{"type": "string", "format": "date-time"},
+					"b": map[string]any{"type": "string", "format": "uuid"},
+					"c": map[string]any{"type": "string", "format": "ipv4"},
+					"d": map[string]any{"type": "string", "format": "ipv6"},
+					"e": map[string]any{"type": "string", "format": "uri"},
+					"f": map[string]any{"type": "string", "format": "time"},
+					"g": map[string]any{"type": "string", "format": "hostname"},
+				},
+				"required": []any{"a", "b", "c", "d", "e", "f", "g"}}),
+			Entry("recursive reference", map[string]any{"type": "object",
+				"properties": map[string]any{"tree": map[string]any{"$ref": "#/definitions/node"}},
+				"definitions": map[string]any{"node": map[string]any{"type": "object",
+					"properties": map[string]any{
+						"value":    map[string]any{"type": "integer"},
+						"children": map[string]any{"type": "array", "items": map[string]any{"$ref": "#/definitions/node"}},
+					},
+					"required": []any{"value", "children"}}},
+				"required": []any{"tree"}}),
+			Entry("unique items", map[string]any{"type": "object",
+				"properties": map[string]any{"u": map[string]any{"type": "array", "uniqueItems": true,
+					"items": map[string]any{"type": "integer", "minimum": 1.0, "maximum": 1000.0}}},
+				"required": []any{"u"}}),
+		)
+
+		It("should fail for unsatisfiable schemas", func() {
+			t := tool{Function: function{Name: "test", Parameters: map[string]any{"type": "object",
+				"properties": map[string]any{"i": map[string]any{"type": "integer", "minimum": 5.5, "maximum": 5.7}},
+				"required":   []any{"i"}}}}
+			_, err := generateToolArguments(rnd, &t)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("tool results", func() {
+		toolResultsMessages := []openai.ChatCompletionMessageParamUnion{
+			openai.UserMessage(userMessage),
+			{OfAssistant: &openai.ChatCompletionAssistantMessageParam{
+				ToolCalls: []openai.ChatCompletionMessageToolCallParam{
+					{
+						ID: "chatcmpl-tool-123",
+						Function: openai.ChatCompletionMessageToolCallFunctionParam{
+							Name:      "get_weather",
+							Arguments: `{"location":"Boston"}`,
+						},
+					},
+				},
+			}},
+			openai.ToolMessage("It is sunny in Boston", "chatcmpl-tool-123"),
+		}
+
+		It("Should respond with text after tool results", func() {
+			ctx := context.TODO()
+			client, err := startServer(ctx, modeEcho)
+			Expect(err).NotTo(HaveOccurred())
+
+			openaiclient := openai.NewClient(
+				option.WithBaseURL(baseURL),
+				option.WithHTTPClient(client))
+
+			params := openai.ChatCompletionNewParams{
+				Messages:   toolResultsMessages,
+				Model:      model,
+				ToolChoice: openai.ChatCompletionToolChoiceOptionUnionParam{OfAuto: param.NewOpt("auto")},
+				Tools:      tools,
+			}
+
+			for range 5 {
+				resp, err := openaiclient.Chat.Completions.New(ctx, params)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.Choices).ShouldNot(BeEmpty())
+				Expect(resp.Choices[0].Message.ToolCalls).To(BeEmpty())
+				Expect(resp.Choices[0].FinishReason).To(Equal(stopFinishReason))
+				// in echo mode the tool results are returned
+				Expect(resp.Choices[0].Message.Content).To(Equal("It is sunny in Boston"))
+				// 4 user message tokens, 12 assistant tool call tokens and 5 tool result tokens
+				Expect(resp.Usage.PromptTokens).To(Equal(int64(21)))
+			}
+		})
+
+		It("Should respond with tool calls after tool results when tool choice is required", func() {
+			ctx := context.TODO()
+			client, err := startServer(ctx, modeEcho)
+			Expect(err).NotTo(HaveOccurred())
+
+			openaiclient := openai.NewClient(
+				option.WithBaseURL(baseURL),
+				option.WithHTTPClient(client))
+
+			params := openai.ChatCompletionNewParams{
+				Messages:   toolResultsMessages,
+				Model:      model,
+				ToolChoice: openai.ChatCompletionToolChoiceOptionUnionParam{OfAuto: param.NewOpt("required")},
+				Tools:      tools,
+			}
+
+			resp, err := openaiclient.Chat.Completions.New(ctx, params)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Choices).ShouldNot(BeEmpty())
+			Expect(resp.Choices[0].Message.ToolCalls).NotTo(BeEmpty())
+		})
+
+		It("Should respond with tool calls after tool results with random policy", func() {
+			ctx := context.TODO()
+			client, err := startServerWithArgs(ctx, modeRandom,
+				[]string{"cmd", "--model", model, "--mode", modeRandom, "--tool-results-policy", toolResultsPolicyRandom})
+			Expect(err).NotTo(HaveOccurred())
+
+			openaiclient := openai.NewClient(
+				option.WithBaseURL(baseURL),
+				option.WithHTTPClient(client))
+
+			params := openai.ChatCompletionNewParams{
+				Messages:   toolResultsMessages,
+				Model:      model,
+				ToolChoice: openai.ChatCompletionToolChoiceOptionUnionParam{OfAuto: param.NewOpt("auto")},
+				Tools:      tools,
+			}
+
+			// tool calls are created randomly, so try several times
+			toolCallsCreated := false
+			for range 20 {
+				resp, err := openaiclient.Chat.Completions.New(ctx, params)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.Choices).ShouldNot(BeEmpty())
+				if len(resp.Choices[0].Message.ToolCalls) > 0 {
+					toolCallsCreated = true
+					break
+				}
+			}
+			Expect(toolCallsCreated).To(BeTrue())
+		})
+	})
+
+	It("Should limit the reasoning of tool calls by max completion tokens", func() {
+		ctx := context.TODO()
+		client, err := startServerWithArgs(ctx, modeRandom,
+			[]string{"cmd", "--model", model, "--mode", modeRandom, "--enable-reasoning", "--reasoning-tokens", "1000"})
+		Expect(err).NotTo(HaveOccurred())
+
+		openaiclient := openai.NewClient(
+			option.WithBaseURL(baseURL),
+			option.WithHTTPClient(client))
+
+		params := openai.ChatCompletionNewParams{
+			Messages:            []openai.ChatCompletionMessageParamUnion{openai.UserMessage(userMessage)},
+			Model:               model,
+			MaxCompletionTokens: param.NewOpt(int64(100)),
+			ToolChoice:          openai.ChatCompletionToolChoiceOptionUnionParam{OfAuto: param.NewOpt("required")},
+			Tools:               tools,
+		}
+		resp, err := openaiclient.Chat.Completions.New(ctx, params)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Choices).ShouldNot(BeEmpty())
+		Expect(resp.Choices[0].Message.ToolCalls).NotTo(BeEmpty())
+		Expect(resp.Usage.CompletionTokensDetails.ReasoningTokens).To(BeNumerically(">", 0))
+		Expect(resp.Usage.CompletionTokens).To(Equal(int64(100)))
+	})
 })
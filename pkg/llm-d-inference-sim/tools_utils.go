@@ -17,12 +17,43 @@ limitations under the License.
 package llmdinferencesim
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"net/url"
+	"reflect"
+	"regexp/syntax"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/santhosh-tekuri/jsonschema/v5"
 )
 
+const (
+	// maxToolArgumentsAttempts is the number of times the arguments of a tool call are
+	// generated before giving up, in case the generated arguments do not pass validation
+	maxToolArgumentsAttempts = 10
+	// maxSchemaDepth is the maximal nesting depth of a generated argument, it protects
+	// from infinite recursion in recursive schemas
+	maxSchemaDepth = 32
+	// minimalSchemaDepth is the nesting depth starting from which only the required
+	// properties and the minimal number of array items are generated
+	minimalSchemaDepth = 5
+	// defaultMaxItems is the maximal number of generated array items, if maxItems is not defined
+	defaultMaxItems = 5
+	// defaultNumbersRange is the range of generated numbers, if minimum or maximum is not defined
+	defaultNumbersRange = 100
+	// maxPatternRepeat is the maximal number of repetitions of an unbounded pattern element
+	maxPatternRepeat = 5
+	// parametersSchemaURL is the URL used to compile tool parameters schemas
+	parametersSchemaURL = "parameters.json"
+)
+
 func countTokensForToolCalls(toolCalls []toolCall) int {
 	numberOfTokens := 0
 	for _, tc := range toolCalls {
@@ -48,7 +79,7 @@ var fakeStringArguments = []string{
 // createToolCalls creates and returns response payload based on this request
 // (tool calls or nothing in case we randomly choose not to generate calls),
 // and the number of generated completion token sand the finish reason
-func createToolCalls(tools []tool, toolChoice string) ([]toolCall, string, int, error) {
+func createToolCalls(rnd *random, tools []tool, toolChoice string) ([]toolCall, string, int, error) {
 	// This function is called if tool choice is either 'required' or 'auto'.
 	// In case of 'required' at least one tool call has to be created, and we randomly choose
 	// the number of calls starting from one. Otherwise, we start from 0, and in case we randomly
@@ -57,7 +88,7 @@ func createToolCalls(tools []tool, toolChoice string) ([]toolCall, string, int,
 	if toolChoice == toolChoiceRequired {
 		min = 1
 	}
-	numberOfCalls := randomInt(min, len(tools))
+	numberOfCalls := rnd.randomInt(min, len(tools))
 	if numberOfCalls == 0 {
 		return nil, "", 0, nil
 	}
@@ -65,12 +96,8 @@ func createToolCalls(tools []tool, toolChoice string) ([]toolCall, string, int,
 	calls := make([]toolCall, 0)
 	for i := range numberOfCalls {
 		// Randomly choose which tools to call. We may call the same tool more than once.
-		index := randomInt(0, len(tools)-1)
-		args, err := generateToolArguments(tools[index])
-		if err != nil {
-			return nil, "", 0, err
-		}
-		argsJson, err := json.Marshal(args)
+		index := rnd.randomInt(0, len(tools)-1)
+		argsJson, err := generateToolArguments(rnd, &tools[index])
 		if err != nil {
 			return nil, "", 0, err
 		}
@@ -81,7 +108,7 @@ func createToolCalls(tools []tool, toolChoice string) ([]toolCall, string, int,
 				tokenizedArguments: tokenize(string(argsJson)),
 				Name:               &tools[index].Function.Name,
 			},
-			ID:    "chatcmpl-tool-" + randomNumericString(10),
+			ID:    "chatcmpl-tool-" + randomNumericString(rnd, 10),
 			Type:  "function",
 			Index: i,
 		}
@@ -104,100 +131,745 @@ func getRequiredAsMap(property map[string]any) map[string]struct{} {
 	return required
 }
 
-func generateToolArguments(tool tool) (map[string]any, error) {
-	arguments := make(map[string]any)
-	properties, _ := tool.Function.Parameters["properties"].(map[string]any)
+// generateToolArguments generates arguments for the given tool according to its parameters schema,
+// and returns them in JSON format. The generated arguments are validated against the schema,
+// and generation is retried in case the validation fails (e.g., oneOf matched more than one
+// alternative).
+func generateToolArguments(rnd *random, tool *tool) ([]byte, error) {
+	if err := tool.Function.compileParameters(); err != nil {
+		return nil, err
+	}
 
-	required := getRequiredAsMap(tool.Function.Parameters)
+	parameters := tool.Function.Parameters
+	if parameters == nil {
+		parameters = map[string]any{"type": "object"}
+	}
+	generator := argumentsGenerator{root: parameters, rnd: rnd}
 
-	for param, property := range properties {
-		_, paramIsRequired := required[param]
-		if !paramIsRequired && !flipCoin() {
+	var lastErr error
+	for range maxToolArgumentsAttempts {
+		args, err := generator.generate(parameters, 0)
+		if err != nil {
+			lastErr = err
 			continue
 		}
-		arg, err := createArgument(property)
+		argsJson, err := json.Marshal(args)
 		if err != nil {
 			return nil, err
 		}
-		arguments[param] = arg
+		if err := validateToolArguments(tool.Function.parametersSchema, argsJson); err != nil {
+			lastErr = err
+			continue
+		}
+		return argsJson, nil
 	}
 
-	return arguments, nil
+	return nil, fmt.Errorf("failed to generate arguments for tool %s: %s", tool.Function.Name, lastErr)
 }
 
-func createArgument(property any) (any, error) {
-	propertyMap, _ := property.(map[string]any)
-	paramType := propertyMap["type"]
+// compileParameters compiles the function's parameters JSON schema, does nothing
+// if the schema was already compiled
+func (f *function) compileParameters() error {
+	if f.parametersSchema != nil {
+		return nil
+	}
 
-	// If there is an enum, choose from it
-	enum, ok := propertyMap["enum"]
-	if ok {
-		enumArray, ok := enum.([]any)
-		if ok && len(enumArray) > 0 {
-			index := randomInt(0, len(enumArray)-1)
-			return enumArray[index], nil
+	parameters := f.Parameters
+	if parameters == nil {
+		parameters = map[string]any{"type": "object"}
+	}
+	data, err := json.Marshal(parameters)
+	if err != nil {
+		return err
+	}
+
+	compiler := jsonschema.NewCompiler()
+	// tool schemas are usually written in draft 7, which also supports items as an array
+	compiler.Draft = jsonschema.Draft7
+	compiler.AssertFormat = true
+	if err := compiler.AddResource(parametersSchemaURL, bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("invalid parameters of tool %s: %s", f.Name, err)
+	}
+	sch, err := compiler.Compile(parametersSchemaURL)
+	if err != nil {
+		return fmt.Errorf("invalid parameters of tool %s: %s", f.Name, err)
+	}
+	f.parametersSchema = sch
+	return nil
+}
+
+// validateToolArguments validates the given arguments in JSON format against the schema
+func validateToolArguments(schema *jsonschema.Schema, args []byte) error {
+	// numbers are decoded as json.Number, so that multipleOf is validated precisely
+	decoder := json.NewDecoder(bytes.NewReader(args))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return err
+	}
+	return schema.Validate(value)
+}
+
+// argumentsGenerator generates random values according to a JSON schema
+type argumentsGenerator struct {
+	// root is the root schema, local references are resolved relative to it
+	root map[string]any
+	// rnd is the random numbers generator of the simulator
+	rnd *random
+}
+
+// generate creates a random value that matches the given schema
+func (g *argumentsGenerator) generate(schema any, depth int) (any, error) {
+	if depth > maxSchemaDepth {
+		return nil, errors.New("tool parameters schema is too deep or infinitely recursive")
+	}
+
+	var schemaMap map[string]any
+	switch s := schema.(type) {
+	case map[string]any:
+		schemaMap = s
+	case bool:
+		if !s {
+			return nil, errors.New("false schema cannot be satisfied")
 		}
+		schemaMap = map[string]any{}
+	case nil:
+		schemaMap = map[string]any{}
+	default:
+		return nil, fmt.Errorf("invalid schema %v", schema)
 	}
 
+	resolved, err := g.resolve(schemaMap, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if value, ok := resolved["const"]; ok {
+		return value, nil
+	}
+	// If there is an enum, choose from it
+	if enumArray, ok := resolved["enum"].([]any); ok && len(enumArray) > 0 {
+		return enumArray[g.rnd.randomInt(0, len(enumArray)-1)], nil
+	}
+
+	paramType := getSchemaType(g.rnd, resolved)
 	switch paramType {
+	case "null":
+		return nil, nil
 	case "string":
-		return getStringArgument(), nil
+		return generateString(g.rnd, resolved)
 	case "integer":
-		return randomInt(0, 100), nil
+		return generateInteger(g.rnd, resolved)
 	case "number":
-		return randomFloat(0, 100), nil
+		return generateNumber(g.rnd, resolved)
 	case "boolean":
-		return flipCoin(), nil
+		return g.rnd.flipCoin(), nil
 	case "array":
-		items := propertyMap["items"]
-		itemsMap := items.(map[string]any)
-		minItems := 1
-		maxItems := 5
-		if value, ok := propertyMap["minItems"]; ok {
-			minItems = int(value.(float64))
-		}
-		if value, ok := propertyMap["maxItems"]; ok {
-			maxItems = int(value.(float64))
-		}
-		if minItems > maxItems {
-			return nil, fmt.Errorf("minItems (%d) is greater than maxItems(%d)", minItems, maxItems)
-		}
-		numberOfElements := randomInt(minItems, maxItems)
-		array := make([]any, numberOfElements)
-		for i := range numberOfElements {
-			elem, err := createArgument(itemsMap)
+		return g.generateArray(resolved, depth)
+	case "object":
+		return g.generateObject(resolved, depth)
+	default:
+		return nil, fmt.Errorf("tool parameters of type %s are not supported", paramType)
+	}
+}
+
+// resolve returns a schema without references and combinators ($ref, allOf, anyOf, oneOf),
+// references and allOf sub-schemas are merged into the schema, and for anyOf and oneOf
+// one of the alternatives is chosen randomly and merged
+func (g *argumentsGenerator) resolve(schema map[string]any, refDepth int) (map[string]any, error) {
+	if refDepth > maxSchemaDepth {
+		return nil, errors.New("tool parameters schema contains a reference loop")
+	}
+
+	resolved := make(map[string]any, len(schema))
+	for key, value := range schema {
+		switch key {
+		case "$ref", "allOf", "anyOf", "oneOf":
+		default:
+			resolved[key] = value
+		}
+	}
+
+	if ref, ok := schema["$ref"].(string); ok {
+		target, err := g.lookupRef(ref)
+		if err != nil {
+			return nil, err
+		}
+		target, err = g.resolve(target, refDepth+1)
+		if err != nil {
+			return nil, err
+		}
+		resolved = mergeSchemas(target, resolved)
+	}
+
+	if allOf, ok := schema["allOf"].([]any); ok {
+		for _, sub := range allOf {
+			subMap, _ := sub.(map[string]any)
+			subResolved, err := g.resolve(subMap, refDepth+1)
 			if err != nil {
 				return nil, err
 			}
-			array[i] = elem
+			resolved = mergeSchemas(resolved, subResolved)
 		}
-		return array, nil
-	case "object":
-		required := getRequiredAsMap(propertyMap)
-		objectProperties := propertyMap["properties"].(map[string]any)
-		object := make(map[string]interface{})
-		for fieldName, fieldProperties := range objectProperties {
-			_, fieldIsRequired := required[fieldName]
-			if !fieldIsRequired && !flipCoin() {
-				continue
-			}
-			fieldValue, err := createArgument(fieldProperties)
+	}
+
+	for _, key := range []string{"anyOf", "oneOf"} {
+		alternatives, ok := schema[key].([]any)
+		if !ok || len(alternatives) == 0 {
+			continue
+		}
+		alternative, _ := alternatives[g.rnd.randomInt(0, len(alternatives)-1)].(map[string]any)
+		altResolved, err := g.resolve(alternative, refDepth+1)
+		if err != nil {
+			return nil, err
+		}
+		resolved = mergeSchemas(resolved, altResolved)
+	}
+
+	return resolved, nil
+}
+
+// lookupRef returns the sub-schema of the root schema the given local reference points to
+func (g *argumentsGenerator) lookupRef(ref string) (map[string]any, error) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, fmt.Errorf("reference %s is not supported, only local references are supported", ref)
+	}
+
+	var current any = g.root
+	pointer := strings.TrimPrefix(strings.TrimPrefix(ref, "#"), "/")
+	if pointer != "" {
+		for _, token := range strings.Split(pointer, "/") {
+			token, err := url.PathUnescape(token)
 			if err != nil {
-				return nil, err
+				return nil, fmt.Errorf("invalid reference %s: %s", ref, err)
+			}
+			token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+			switch node := current.(type) {
+			case map[string]any:
+				current = node[token]
+			case []any:
+				index, err := strconv.Atoi(token)
+				if err != nil || index < 0 || index >= len(node) {
+					return nil, fmt.Errorf("invalid reference %s", ref)
+				}
+				current = node[index]
+			default:
+				return nil, fmt.Errorf("invalid reference %s", ref)
+			}
+		}
+	}
+
+	target, ok := current.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("reference %s does not point to a schema", ref)
+	}
+	return target, nil
+}
+
+// mergeSchemas returns a schema that contains the restrictions of both given schemas
+func mergeSchemas(base map[string]any, overlay map[string]any) map[string]any {
+	merged := make(map[string]any, len(base)+len(overlay))
+	for key, value := range base {
+		merged[key] = value
+	}
+
+	for key, value := range overlay {
+		baseValue, exists := merged[key]
+		if !exists {
+			merged[key] = value
+			continue
+		}
+		switch key {
+		case "properties":
+			baseProperties, _ := baseValue.(map[string]any)
+			properties := make(map[string]any, len(baseProperties))
+			for name, property := range baseProperties {
+				properties[name] = property
+			}
+			overlayProperties, _ := value.(map[string]any)
+			for name, property := range overlayProperties {
+				if baseProperty, ok := properties[name]; ok {
+					properties[name] = map[string]any{"allOf": []any{baseProperty, property}}
+				} else {
+					properties[name] = property
+				}
+			}
+			merged[key] = properties
+		case "required":
+			baseRequired, _ := baseValue.([]any)
+			overlayRequired, _ := value.([]any)
+			merged[key] = append(append([]any{}, baseRequired...), overlayRequired...)
+		case "minimum", "exclusiveMinimum", "minLength", "minItems":
+			if overlayNumber, ok := toFloat(value); ok {
+				if baseNumber, ok := toFloat(baseValue); !ok || overlayNumber > baseNumber {
+					merged[key] = value
+				}
 			}
-			object[fieldName] = fieldValue
+		case "maximum", "exclusiveMaximum", "maxLength", "maxItems":
+			if overlayNumber, ok := toFloat(value); ok {
+				if baseNumber, ok := toFloat(baseValue); !ok || overlayNumber < baseNumber {
+					merged[key] = value
+				}
+			}
+		case "type":
+			if types := intersectTypes(getSchemaTypes(baseValue), getSchemaTypes(value)); len(types) > 0 {
+				merged[key] = types
+			} else {
+				merged[key] = value
+			}
+		case "enum":
+			baseEnum, _ := baseValue.([]any)
+			overlayEnum, _ := value.([]any)
+			enum := make([]any, 0)
+			for _, baseItem := range baseEnum {
+				for _, overlayItem := range overlayEnum {
+					if reflect.DeepEqual(baseItem, overlayItem) {
+						enum = append(enum, baseItem)
+						break
+					}
+				}
+			}
+			merged[key] = enum
+		default:
+			merged[key] = value
 		}
-		return object, nil
+	}
+
+	return merged
+}
+
+// getSchemaTypes returns the types defined by the value of a schema's type keyword
+func getSchemaTypes(value any) []string {
+	switch t := value.(type) {
+	case string:
+		return []string{t}
+	case []string:
+		return t
+	case []any:
+		types := make([]string, 0, len(t))
+		for _, item := range t {
+			if typeName, ok := item.(string); ok {
+				types = append(types, typeName)
+			}
+		}
+		return types
+	}
+	return nil
+}
+
+// intersectTypes returns the types allowed by both given type lists, integer is a subset of number
+func intersectTypes(first []string, second []string) []any {
+	types := make([]any, 0)
+	for _, firstType := range first {
+		for _, secondType := range second {
+			switch {
+			case firstType == secondType:
+				types = append(types, firstType)
+			case firstType == "integer" && secondType == "number",
+				firstType == "number" && secondType == "integer":
+				types = append(types, "integer")
+			}
+		}
+	}
+	return types
+}
+
+// getSchemaType returns the type of the value to generate for the given schema, if several types
+// are allowed one of them is chosen randomly. If the type is not defined, it is deduced from the
+// other keywords of the schema.
+func getSchemaType(rnd *random, schema map[string]any) string {
+	if typeValue, ok := schema["type"]; ok {
+		types := getSchemaTypes(typeValue)
+		if len(types) == 0 {
+			return fmt.Sprintf("%v", typeValue)
+		}
+		return types[rnd.randomInt(0, len(types)-1)]
+	}
+
+	hasAny := func(keys ...string) bool {
+		for _, key := range keys {
+			if _, ok := schema[key]; ok {
+				return true
+			}
+		}
+		return false
+	}
+	switch {
+	case hasAny("properties", "required", "additionalProperties"):
+		return "object"
+	case hasAny("items", "minItems", "maxItems", "uniqueItems"):
+		return "array"
+	case hasAny("minimum", "maximum", "exclusiveMinimum", "exclusiveMaximum", "multipleOf"):
+		return "number"
 	default:
-		return nil, fmt.Errorf("tool parameters of type %s are not supported", paramType)
+		return "string"
+	}
+}
+
+// toFloat converts a numeric schema value to float64
+func toFloat(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// toInt converts a numeric schema value to int
+func toInt(value any) (int, bool) {
+	f, ok := toFloat(value)
+	return int(f), ok
+}
+
+// getNumbersRange returns the range of numbers allowed by the schema, and whether
+// each of the range's ends is exclusive
+func getNumbersRange(schema map[string]any) (float64, float64, bool, bool) {
+	min, hasMin := toFloat(schema["minimum"])
+	minExclusive := false
+	if exclusiveMin, ok := toFloat(schema["exclusiveMinimum"]); ok && (!hasMin || exclusiveMin >= min) {
+		min, hasMin, minExclusive = exclusiveMin, true, true
+	}
+	max, hasMax := toFloat(schema["maximum"])
+	maxExclusive := false
+	if exclusiveMax, ok := toFloat(schema["exclusiveMaximum"]); ok && (!hasMax || exclusiveMax <= max) {
+		max, hasMax, maxExclusive = exclusiveMax, true, true
+	}
+
+	switch {
+	case !hasMin && !hasMax:
+		min, max = 0, defaultNumbersRange
+	case !hasMin:
+		min = max - defaultNumbersRange
+	case !hasMax:
+		max = min + defaultNumbersRange
+	}
+	return min, max, minExclusive, maxExclusive
+}
+
+func generateInteger(rnd *random, schema map[string]any) (any, error) {
+	min, max, minExclusive, maxExclusive := getNumbersRange(schema)
+	intMin := math.Ceil(min)
+	if minExclusive && intMin == min {
+		intMin++
+	}
+	intMax := math.Floor(max)
+	if maxExclusive && intMax == max {
+		intMax--
+	}
+
+	step := 1.0
+	if multipleOf, ok := toFloat(schema["multipleOf"]); ok && multipleOf > 0 {
+		step = multipleOf
+	}
+	first := math.Ceil(intMin / step)
+	last := math.Floor(intMax / step)
+	if first > last {
+		return nil, fmt.Errorf("no integer in range [%v, %v] satisfies the schema", min, max)
+	}
+	return int64(float64(rnd.randomInt(int(first), int(last))) * step), nil
+}
+
+func generateNumber(rnd *random, schema map[string]any) (any, error) {
+	min, max, minExclusive, maxExclusive := getNumbersRange(schema)
+	if min > max || (min == max && (minExclusive || maxExclusive)) {
+		return nil, fmt.Errorf("minimum (%v) is greater than maximum (%v)", min, max)
+	}
+
+	if multipleOf, ok := toFloat(schema["multipleOf"]); ok && multipleOf > 0 {
+		first := math.Ceil(min / multipleOf)
+		if minExclusive && first*multipleOf == min {
+			first++
+		}
+		last := math.Floor(max / multipleOf)
+		if maxExclusive && last*multipleOf == max {
+			last--
+		}
+		if first > last {
+			return nil, fmt.Errorf("no multiple of %v in range [%v, %v]", multipleOf, min, max)
+		}
+		// round to the precision of multipleOf to avoid floating point errors, e.g. 3*0.1
+		value := float64(rnd.randomInt(int(first), int(last))) * multipleOf
+		precision := math.Pow10(countDecimals(multipleOf))
+		return math.Round(value*precision) / precision, nil
+	}
+
+	value := rnd.randomFloat(min, max)
+	if minExclusive && value == min {
+		value = (min + max) / 2
+	}
+	return value, nil
+}
+
+// countDecimals returns the number of digits after the decimal point in the given number
+func countDecimals(number float64) int {
+	str := strconv.FormatFloat(number, 'f', -1, 64)
+	if index := strings.IndexByte(str, '.'); index >= 0 {
+		return len(str) - index - 1
+	}
+	return 0
+}
+
+func generateString(rnd *random, schema map[string]any) (any, error) {
+	minLength, hasMinLength := toInt(schema["minLength"])
+	maxLength, hasMaxLength := toInt(schema["maxLength"])
+	if hasMinLength && hasMaxLength && minLength > maxLength {
+		return nil, fmt.Errorf("minLength (%d) is greater than maxLength(%d)", minLength, maxLength)
+	}
+
+	if format, ok := schema["format"].(string); ok {
+		if str, ok := getFormattedStringArgument(rnd, format); ok {
+			return str, nil
+		}
+	}
+	if pattern, ok := schema["pattern"].(string); ok {
+		return getStringArgumentFromPattern(rnd, pattern)
+	}
+
+	str := getStringArgument(rnd)
+	for len(str) < minLength {
+		str += " " + getStringArgument(rnd)
+	}
+	if hasMaxLength && len(str) > maxLength {
+		str = str[:maxLength]
 	}
+	return str, nil
 }
 
-func getStringArgument() string {
-	index := randomInt(0, len(fakeStringArguments)-1)
+func getStringArgument(rnd *random) string {
+	index := rnd.randomInt(0, len(fakeStringArguments)-1)
 	return fakeStringArguments[index]
 }
 
+// getFormattedStringArgument returns a random string in the given format,
+// the boolean is false if the format is not supported
+func getFormattedStringArgument(rnd *random, format string) (string, bool) {
+	date := time.Date(rnd.randomInt(2000, 2030), time.Month(rnd.randomInt(1, 12)), rnd.randomInt(1, 28),
+		rnd.randomInt(0, 23), rnd.randomInt(0, 59), rnd.randomInt(0, 59), 0, time.UTC)
+	word := strings.ToLower(getStringArgument(rnd))
+
+	switch format {
+	case "date-time":
+		return date.Format(time.RFC3339), true
+	case "date":
+		return date.Format(time.DateOnly), true
+	case "time":
+		return date.Format("15:04:05Z07:00"), true
+	case "duration":
+		return fmt.Sprintf("P%dDT%dH", rnd.randomInt(1, 30), rnd.randomInt(1, 23)), true
+	case "email", "idn-email":
+		return word + "@example.com", true
+	case "hostname", "idn-hostname":
+		return word + ".example.com", true
+	case "ipv4":
+		return fmt.Sprintf("%d.%d.%d.%d", rnd.randomInt(1, 254), rnd.randomInt(0, 255), rnd.randomInt(0, 255), rnd.randomInt(1, 254)), true
+	case "ipv6":
+		return fmt.Sprintf("2001:db8::%x:%x", rnd.randomInt(1, 0xffff), rnd.randomInt(1, 0xffff)), true
+	case "uri", "uri-reference", "iri", "iri-reference", "url":
+		return "https://example.com/" + word, true
+	case "uuid":
+		id, err := uuid.NewRandomFromReader(rnd)
+		if err != nil {
+			return "", false
+		}
+		return id.String(), true
+	}
+	return "", false
+}
+
+// getStringArgumentFromPattern returns a random string that matches the given regular expression
+func getStringArgumentFromPattern(rnd *random, pattern string) (string, error) {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return "", fmt.Errorf("invalid pattern %s: %s", pattern, err)
+	}
+	var sb strings.Builder
+	if err := writePatternMatch(rnd, &sb, re.Simplify()); err != nil {
+		return "", fmt.Errorf("cannot generate a string for pattern %s: %s", pattern, err)
+	}
+	return sb.String(), nil
+}
+
+// writePatternMatch writes a random string that matches the given regular expression
+func writePatternMatch(rnd *random, sb *strings.Builder, re *syntax.Regexp) error {
+	switch re.Op {
+	case syntax.OpNoMatch:
+		return errors.New("pattern does not match any string")
+	case syntax.OpLiteral:
+		for _, r := range re.Rune {
+			sb.WriteRune(r)
+		}
+	case syntax.OpCharClass:
+		sb.WriteRune(randomRuneFromClass(rnd, re.Rune))
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		sb.WriteRune(rune(rnd.randomInt('a', 'z')))
+	case syntax.OpCapture:
+		return writePatternMatch(rnd, sb, re.Sub[0])
+	case syntax.OpStar:
+		return writePatternRepeat(rnd, sb, re.Sub[0], 0, maxPatternRepeat)
+	case syntax.OpPlus:
+		return writePatternRepeat(rnd, sb, re.Sub[0], 1, maxPatternRepeat)
+	case syntax.OpQuest:
+		return writePatternRepeat(rnd, sb, re.Sub[0], 0, 1)
+	case syntax.OpRepeat:
+		max := re.Max
+		if max < 0 {
+			max = re.Min + maxPatternRepeat
+		}
+		return writePatternRepeat(rnd, sb, re.Sub[0], re.Min, max)
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			if err := writePatternMatch(rnd, sb, sub); err != nil {
+				return err
+			}
+		}
+	case syntax.OpAlternate:
+		return writePatternMatch(rnd, sb, re.Sub[rnd.randomInt(0, len(re.Sub)-1)])
+	}
+	// empty matches, anchors and word boundaries don't add characters
+	return nil
+}
+
+func writePatternRepeat(rnd *random, sb *strings.Builder, re *syntax.Regexp, min int, max int) error {
+	for range rnd.randomInt(min, max) {
+		if err := writePatternMatch(rnd, sb, re); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// randomRuneFromClass returns a random rune from a character class defined as pairs of
+// ranges, printable ASCII characters are preferred
+func randomRuneFromClass(rnd *random, ranges []rune) rune {
+	printable := make([]rune, 0)
+	for i := 0; i+1 < len(ranges); i += 2 {
+		for r := max(ranges[i], ' '); r <= min(ranges[i+1], '~'); r++ {
+			printable = append(printable, r)
+		}
+	}
+	if len(printable) > 0 {
+		return printable[rnd.randomInt(0, len(printable)-1)]
+	}
+
+	index := rnd.randomInt(0, len(ranges)/2-1) * 2
+	return ranges[index] + rune(rnd.randomInt(0, min(int(ranges[index+1]-ranges[index]), 0xff)))
+}
+
+func (g *argumentsGenerator) generateArray(schema map[string]any, depth int) (any, error) {
+	// an array of items of different types
+	if tupleItems, ok := schema["items"].([]any); ok {
+		array := make([]any, len(tupleItems))
+		for i, item := range tupleItems {
+			elem, err := g.generate(item, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			array[i] = elem
+		}
+		return array, nil
+	}
+
+	minItems, hasMinItems := toInt(schema["minItems"])
+	maxItems, hasMaxItems := toInt(schema["maxItems"])
+	if !hasMaxItems {
+		maxItems = max(minItems, defaultMaxItems)
+	}
+	if !hasMinItems {
+		minItems = min(1, maxItems)
+		if depth >= minimalSchemaDepth {
+			minItems = 0
+		}
+	}
+	if minItems > maxItems {
+		return nil, fmt.Errorf("minItems (%d) is greater than maxItems(%d)", minItems, maxItems)
+	}
+	if depth >= minimalSchemaDepth {
+		maxItems = minItems
+	}
+	uniqueItems, _ := schema["uniqueItems"].(bool)
+
+	numberOfElements := g.rnd.randomInt(minItems, maxItems)
+	array := make([]any, 0, numberOfElements)
+	for range numberOfElements {
+		elem, err := g.generate(schema["items"], depth+1)
+		if err != nil {
+			return nil, err
+		}
+		if uniqueItems && containsValue(array, elem) {
+			// skip duplicates, if there are not enough items validation will fail
+			// and the arguments will be generated again
+			continue
+		}
+		array = append(array, elem)
+	}
+	return array, nil
+}
+
+func containsValue(array []any, value any) bool {
+	for _, elem := range array {
+		if reflect.DeepEqual(elem, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *argumentsGenerator) generateObject(schema map[string]any, depth int) (any, error) {
+	required := getRequiredAsMap(schema)
+	objectProperties, _ := schema["properties"].(map[string]any)
+	object := make(map[string]any)
+
+	// iterate in a sorted order, so that the result depends on the seed only
+	fieldNames := make([]string, 0, len(objectProperties))
+	for fieldName := range objectProperties {
+		fieldNames = append(fieldNames, fieldName)
+	}
+	sort.Strings(fieldNames)
+
+	for _, fieldName := range fieldNames {
+		_, fieldIsRequired := required[fieldName]
+		if !fieldIsRequired && (depth >= minimalSchemaDepth || !g.rnd.flipCoin()) {
+			continue
+		}
+		fieldValue, err := g.generate(objectProperties[fieldName], depth+1)
+		if err != nil {
+			return nil, err
+		}
+		object[fieldName] = fieldValue
+	}
+
+	// required fields that are not defined in the properties
+	requiredNames := make([]string, 0, len(required))
+	for fieldName := range required {
+		if _, ok := object[fieldName]; !ok {
+			requiredNames = append(requiredNames, fieldName)
+		}
+	}
+	sort.Strings(requiredNames)
+	for _, fieldName := range requiredNames {
+		var fieldSchema any
+		if additional, ok := schema["additionalProperties"].(map[string]any); ok {
+			fieldSchema = additional
+		}
+		fieldValue, err := g.generate(fieldSchema, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		object[fieldName] = fieldValue
+	}
+
+	return object, nil
+}
+
 type validator struct {
 	schema *jsonschema.Schema
 }
@@ -242,24 +914,80 @@ const schema = `{
   ],
   "additionalProperties": false,
   "$defs": {
+    "type_name": {
+      "type": "string",
+      "enum": [
+        "object",
+        "array",
+        "string",
+        "number",
+        "integer",
+        "boolean",
+        "null"
+      ]
+    },
+    "param_definitions": {
+      "type": "array",
+      "minItems": 1,
+      "items": {
+        "$ref": "#/$defs/param_definition"
+      }
+    },
+    "param_definitions_map": {
+      "type": "object",
+      "additionalProperties": {
+        "$ref": "#/$defs/param_definition"
+      }
+    },
     "param_definition": {
       "type": "object",
       "properties": {
         "type": {
-          "type": "string",
-          "enum": [
-            "object",
-            "array",
-            "string",
-            "number",
-            "integer",
-            "boolean",
-            "null"
+          "anyOf": [
+            {
+              "$ref": "#/$defs/type_name"
+            },
+            {
+              "type": "array",
+              "minItems": 1,
+              "items": {
+                "$ref": "#/$defs/type_name"
+              }
+            }
           ]
         },
         "description": {
           "type": "string"
         },
+        "title": {
+          "type": "string"
+        },
+        "default": {},
+        "examples": {
+          "type": "array"
+        },
+        "$schema": {
+          "type": "string"
+        },
+        "$ref": {
+          "type": "string"
+        },
+        "$defs": {
+          "$ref": "#/$defs/param_definitions_map"
+        },
+        "definitions": {
+          "$ref": "#/$defs/param_definitions_map"
+        },
+        "anyOf": {
+          "$ref": "#/$defs/param_definitions"
+        },
+        "oneOf": {
+          "$ref": "#/$defs/param_definitions"
+        },
+        "allOf": {
+          "$ref": "#/$defs/param_definitions"
+        },
+        "const": {},
         "enum": {
           "type": "array",
           "items": {
@@ -267,15 +995,13 @@ const schema = `{
               "string",
               "number",
               "integer",
-              "boolean"
+              "boolean",
+              "null"
             ]
           }
         },
         "properties": {
-          "type": "object",
-          "additionalProperties": {
-            "$ref": "#/$defs/param_definition"
-          }
+          "$ref": "#/$defs/param_definitions_map"
         },
         "items": {
           "anyOf": [
@@ -297,7 +1023,14 @@ const schema = `{
           }
         },
         "additionalProperties": {
-          "type": "boolean"
+          "anyOf": [
+            {
+              "type": "boolean"
+            },
+            {
+              "$ref": "#/$defs/param_definition"
+            }
+          ]
         },
         "minItems": {
           "type": "integer",
@@ -306,15 +1039,48 @@ const schema = `{
         "maxItems": {
           "type": "integer",
           "minimum": 0
+        },
+        "uniqueItems": {
+          "type": "boolean"
+        },
+        "minLength": {
+          "type": "integer",
+          "minimum": 0
+        },
+        "maxLength": {
+          "type": "integer",
+          "minimum": 0
+        },
+        "pattern": {
+          "type": "string"
+        },
+        "format": {
+          "type": "string"
+        },
+        "minimum": {
+          "type": "number"
+        },
+        "maximum": {
+          "type": "number"
+        },
+        "exclusiveMinimum": {
+          "type": "number"
+        },
+        "exclusiveMaximum": {
+          "type": "number"
+        },
+        "multipleOf": {
+          "type": "number",
+          "exclusiveMinimum": 0
         }
       },
-      "required": [
-        "type"
-      ],
       "additionalProperties": false,
       "allOf": [
         {
           "if": {
+            "required": [
+              "type"
+            ],
             "properties": {
               "type": {
                 "const": "string"
@@ -334,6 +1100,9 @@ const schema = `{
         },
         {
           "if": {
+            "required": [
+              "type"
+            ],
             "properties": {
               "type": {
                 "const": "number"
@@ -353,6 +1122,9 @@ const schema = `{
         },
         {
           "if": {
+            "required": [
+              "type"
+            ],
             "properties": {
               "type": {
                 "const": "integer"
@@ -372,6 +1144,9 @@ const schema = `{
         },
         {
           "if": {
+            "required": [
+              "type"
+            ],
             "properties": {
               "type": {
                 "const": "boolean"
@@ -391,6 +1166,9 @@ const schema = `{
         },
         {
           "if": {
+            "required": [
+              "type"
+            ],
             "anyOf": [
               {
                 "properties": {
@@ -422,34 +1200,6 @@ const schema = `{
               ]
             }
           }
-        },
-        {
-          "if": {
-            "properties": {
-              "type": {
-                "const": "array"
-              }
-            }
-          },
-          "then": {
-            "required": [
-              "items"
-            ]
-          }
-        },
-        {
-          "if": {
-            "properties": {
-              "type": {
-                "const": "object"
-              }
-            }
-          },
-          "then": {
-            "required": [
-              "properties"
-            ]
-          }
         }
       ]
     }
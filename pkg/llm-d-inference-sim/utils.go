@@ -21,6 +21,7 @@ import (
 	"math/rand"
 	"regexp"
 	"strings"
+	"sync"
 )
 
 // list of responses to use in random mode for comepltion requests
@@ -58,6 +59,41 @@ func getMaxTokens(maxCompletionTokens *int64, maxTokens *int64) (*int64, error)
 	return tokens, nil
 }
 
+// reduceMaxTokens returns the max tokens left after the given number of tokens were generated,
+// returns nil if max tokens is nil
+func reduceMaxTokens(maxTokens *int64, generatedTokens int) *int64 {
+	if maxTokens == nil || generatedTokens == 0 {
+		return maxTokens
+	}
+	left := max(*maxTokens-int64(generatedTokens), 0)
+	return &left
+}
+
+// getFirstChoiceTokens returns the tokens of the first choice of the response, chat completion
+// responses contain a single choice
+func getFirstChoiceTokens(choicesTokens [][]string) []string {
+	if len(choicesTokens) == 0 {
+		return nil
+	}
+	return choicesTokens[0]
+}
+
+// getReasoningTokens returns the given number of random reasoning tokens, considering
+// max completion tokens if it is not nil
+func getReasoningTokens(rnd *random, numberOfTokens int, maxCompletionTokens *int64) []string {
+	if maxCompletionTokens != nil && *maxCompletionTokens < int64(numberOfTokens) {
+		numberOfTokens = int(max(*maxCompletionTokens, 0))
+	}
+
+	tokens := make([]string, 0, numberOfTokens)
+	for len(tokens) < numberOfTokens {
+		index := rnd.randomInt(0, len(chatCompletionFakeResponses)-1)
+		sentenceTokens := tokenize(chatCompletionFakeResponses[index] + " ")
+		tokens = append(tokens, sentenceTokens[:min(len(sentenceTokens), numberOfTokens-len(tokens))]...)
+	}
+	return tokens
+}
+
 // validateContextWindow checks if the request fits within the model's context window
 // Returns validation result, actual completion tokens, and total tokens
 func validateContextWindow(promptTokens int, maxCompletionTokens *int64, maxModelLen int) (bool, int64, int64) {
@@ -74,9 +110,19 @@ func validateContextWindow(promptTokens int, maxCompletionTokens *int64, maxMode
 
 // getRandomResponseText returns random response text from the pre-defined list of responses
 // considering max completion tokens if it is not nil, and a finish reason (stop or length)
-func getRandomResponseText(maxCompletionTokens *int64) (string, string) {
-	index := randomInt(0, len(chatCompletionFakeResponses)-1)
-	text := chatCompletionFakeResponses[index]
+func getRandomResponseText(rnd *random, maxCompletionTokens *int64) (string, string) {
+	return getRandomResponseTextFrom(rnd, chatCompletionFakeResponses, maxCompletionTokens)
+}
+
+// getRandomResponseTextFrom returns random response text from the given list of responses, or from
+// the pre-defined list if the given list is empty, considering max completion tokens if it is not nil,
+// and a finish reason (stop or length)
+func getRandomResponseTextFrom(rnd *random, responses []string, maxCompletionTokens *int64) (string, string) {
+	if len(responses) == 0 {
+		responses = chatCompletionFakeResponses
+	}
+	index := rnd.randomInt(0, len(responses)-1)
+	text := responses[index]
 
 	return getResponseText(maxCompletionTokens, text)
 }
@@ -114,36 +160,59 @@ func getFullTextFromPartialString(partial string) string {
 	return ""
 }
 
-func randomNumericString(length int) string {
+func randomNumericString(rnd *random, length int) string {
 	digits := "0123456789"
 	result := make([]byte, length)
 	for i := 0; i < length; i++ {
-		num := randomInt(0, 9)
+		num := rnd.randomInt(0, 9)
 		result[i] = digits[num]
 	}
 	return string(result)
 }
 
-var randomGenerator *rand.Rand
+// random is a random numbers generator, each simulator has its own generator, so that simulators
+// in the same process with the same seed generate the same values. It is safe for concurrent use.
+type random struct {
+	mutex     sync.Mutex
+	generator *rand.Rand
+}
 
-func initRandom(seed int64) {
-	src := rand.NewSource(seed)
-	randomGenerator = rand.New(src)
+// newRandom creates a random numbers generator with the given seed
+func newRandom(seed int64) *random {
+	return &random{generator: rand.New(rand.NewSource(seed))}
 }
 
 // Returns an integer between min and max (included)
-func randomInt(min int, max int) int {
-	return randomGenerator.Intn(max-min+1) + min
+func (r *random) randomInt(min int, max int) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.generator.Intn(max-min+1) + min
 }
 
 // Returns true or false randomly
-func flipCoin() bool {
-	return randomInt(0, 1) != 0
+func (r *random) flipCoin() bool {
+	return r.randomInt(0, 1) != 0
 }
 
 // Returns a random float64 in the range [min, max)
-func randomFloat(min float64, max float64) float64 {
-	return randomGenerator.Float64()*(max-min) + min
+func (r *random) randomFloat(min float64, max float64) float64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.generator.Float64()*(max-min) + min
+}
+
+// Returns an exponentially distributed random float64 with the given mean
+func (r *random) randomExponential(mean float64) float64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.generator.ExpFloat64() * mean
+}
+
+// Read fills the given buffer with random bytes, implementation of io.Reader
+func (r *random) Read(p []byte) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.generator.Read(p)
 }
 
 // Regular expression for the response tokenization
@@ -25,25 +25,27 @@ import (
 )
 
 var _ = Describe("Utils", Ordered, func() {
+	var rnd *random
+
 	BeforeAll(func() {
-		initRandom(time.Now().UnixNano())
+		rnd = newRandom(time.Now().UnixNano())
 	})
 
 	Context("GetRandomResponseText", func() {
 		It("should return complete text", func() {
-			text, finishReason := getRandomResponseText(nil)
+			text, finishReason := getRandomResponseText(rnd, nil)
 			Expect(text).Should(Equal(getFullTextFromPartialString(text)))
 			Expect(finishReason).Should(Equal(stopFinishReason))
 		})
 		It("should return partial text", func() {
 			maxCompletionTokens := int64(2)
-			text, finishReason := getRandomResponseText(&maxCompletionTokens)
+			text, finishReason := getRandomResponseText(rnd, &maxCompletionTokens)
 			Expect(int64(len(strings.Fields(text)))).Should(Equal(maxCompletionTokens))
 			Expect(finishReason).Should(Equal(lengthFinishReason))
 		})
 		It("should return complete text", func() {
 			maxCompletionTokens := int64(2000)
-			text, finishReason := getRandomResponseText(&maxCompletionTokens)
+			text, finishReason := getRandomResponseText(rnd, &maxCompletionTokens)
 			Expect(text).Should(Equal(getFullTextFromPartialString(text)))
 			Expect(finishReason).Should(Equal(stopFinishReason))
 		})
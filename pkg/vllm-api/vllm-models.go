@@ -27,6 +27,8 @@ const (
 	PromLabelRunningLoraAdapters = "running_lora_adapters"
 	PromLabelMaxLora             = "max_lora"
 	PromLabelModelName           = "model_name"
+	PromLabelFinishedReason      = "finished_reason"
+	PromLabelEngine              = "engine"
 
 	VllmLoraRequestInfo    = "vllm:lora_requests_info"
 	VllmNumRequestsRunning = "vllm:num_requests_running"
@@ -46,6 +48,19 @@ type ModelsResponseModelInfo struct {
 	Root string `json:"root"`
 	// Parent is name of base model when the model is LoRA adapter, if the model is not a LoRA - null
 	Parent *string `json:"parent"`
+	// LoraInfo is the metadata of a LoRA adapter read from its adapter_config.json file,
+	// nil if the model is not a LoRA or the file was not found
+	LoraInfo *LoraAdapterInfo `json:"lora_info,omitempty"`
+}
+
+// LoraAdapterInfo contains metadata of a LoRA adapter
+type LoraAdapterInfo struct {
+	// Rank is the rank of the adapter's matrices
+	Rank int `json:"rank,omitempty"`
+	// TargetModules are the names of the modules the adapter is applied to
+	TargetModules []string `json:"target_modules,omitempty"`
+	// BaseModel is the model the adapter was trained for
+	BaseModel string `json:"base_model,omitempty"`
 }
 
 // modelsResponse is the response of /models API